@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aspirshar/myContainer/build"
+	"github.com/aspirshar/myContainer/cgroups/resource"
+	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/image"
+	"github.com/aspirshar/myContainer/utils"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// buildRepository 中间镜像不打标签，构建过程中通过 mycontainer-build@<digest> 引用
+const buildRepository = "mycontainer-build"
+
+// buildOptions build 命令的参数
+type buildOptions struct {
+	Tags       []string // 构建成功后打上的标签
+	Dockerfile string   // 为空时使用构建上下文中的 Dockerfile
+	ContextDir string   // 构建上下文目录
+	BuildArgs  []string // --build-arg NAME=VALUE
+	NoCache    bool     // 不使用构建缓存
+	Network    string   // RUN 指令的容器连接的网络
+}
+
+// builder 构建过程中的状态
+type builder struct {
+	opts      *buildOptions
+	context   *build.Context
+	img       *image.Image           // 上一步生成的镜像，FROM scratch 之后还没有生成镜像时为 nil
+	config    *container.ImageConfig // img 的镜像配置
+	buildArgs map[string]string      // --build-arg 指定的值
+	usedArgs  map[string]bool        // Dockerfile 中声明过的 --build-arg
+	metaArgs  map[string]string      // FROM 之前声明的 ARG，只能在 FROM 中使用
+	args      map[string]string      // FROM 之后声明的 ARG
+	cmdSet    bool                   // Dockerfile 中设置过 CMD，之后的 ENTRYPOINT 不再清空 CMD
+}
+
+// buildImage 按 Dockerfile 构建镜像
+/*
+1.支持 FROM、RUN、COPY、ADD、ENV、WORKDIR、USER、CMD、ENTRYPOINT、EXPOSE、LABEL、ARG，只支持单阶段构建
+2.每一步在上一步的镜像之上生成新的中间镜像，RUN、COPY、ADD 生成新的层，其余指令只修改镜像配置
+3.RUN 通过 run 的流程在容器中执行，容器的 upper 目录打包为新的层
+4.上一步的镜像、指令和输入（复制的文件、ARG 的值）都没有变化时使用缓存的镜像
+5.构建完成后为最终的镜像打上所有标签
+*/
+func buildImage(opts *buildOptions) error {
+	ctx, err := build.NewContext(opts.ContextDir)
+	if err != nil {
+		return err
+	}
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(ctx.Dir, "Dockerfile")
+	}
+	file, err := os.Open(dockerfile)
+	if err != nil {
+		return errors.WithMessage(err, "open Dockerfile failed")
+	}
+	instructions, err := build.Parse(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	b := &builder{
+		opts:      opts,
+		context:   ctx,
+		buildArgs: make(map[string]string),
+		usedArgs:  make(map[string]bool),
+		metaArgs:  make(map[string]string),
+		args:      make(map[string]string),
+	}
+	for _, arg := range opts.BuildArgs {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			// 只写 NAME 时使用宿主机上的同名环境变量
+			if value, ok = os.LookupEnv(name); !ok {
+				continue
+			}
+		}
+		b.buildArgs[name] = value
+	}
+
+	fromSeen := false
+	for i, instruction := range instructions {
+		fmt.Printf("Step %d/%d : %s\n", i+1, len(instructions), instruction.Original)
+		switch {
+		case instruction.Cmd == "FROM":
+			if fromSeen {
+				return instruction.Errorf("multi-stage builds are not supported")
+			}
+			fromSeen = true
+			err = b.from(instruction)
+		case instruction.Cmd == "ARG":
+			err = b.arg(instruction, !fromSeen)
+		case !fromSeen:
+			return instruction.Errorf("FROM must be the first instruction")
+		case instruction.Cmd == "RUN":
+			err = b.run(instruction)
+		case instruction.Cmd == "COPY" || instruction.Cmd == "ADD":
+			err = b.copy(instruction)
+		default:
+			err = b.configure(instruction)
+		}
+		if err != nil {
+			return err
+		}
+		if b.img != nil {
+			fmt.Printf(" ---> %s\n", shortID(b.img.ID()))
+		}
+	}
+	if b.img == nil {
+		return errors.New("no image was generated, the Dockerfile has no instructions after FROM scratch")
+	}
+
+	var unused []string
+	for name := range b.buildArgs {
+		if !b.usedArgs[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		fmt.Printf("[Warning] One or more build-args %v were not consumed\n", unused)
+	}
+	fmt.Printf("Successfully built %s\n", shortID(b.img.ID()))
+	for _, tag := range opts.Tags {
+		if err = image.Tag(tag, b.img.Digest); err != nil {
+			return errors.WithMessagef(err, "tag %s failed", tag)
+		}
+		fmt.Printf("Successfully tagged %s\n", image.FamiliarName(tag))
+	}
+	return nil
+}
+
+// setImage 切换到新生成或缓存的镜像
+func (b *builder) setImage(img *image.Image) error {
+	config, err := container.ImageConfigOf(img)
+	if err != nil {
+		return err
+	}
+	b.img, b.config = img, config
+	return nil
+}
+
+// imageRef 当前镜像的引用，中间镜像没有标签
+func (b *builder) imageRef() string {
+	return buildRepository + "@" + b.img.Digest
+}
+
+// parentDigest 构建缓存 key 中的上一步镜像，FROM scratch 之后为 scratch
+func (b *builder) parentDigest() string {
+	if b.img == nil {
+		return "scratch"
+	}
+	return b.img.Digest
+}
+
+// hasFilesystem 当前镜像是否有层，没有层的镜像不能创建容器
+func (b *builder) hasFilesystem() bool {
+	return b.img != nil && len(b.img.Manifest.Layers) > 0
+}
+
+// lookup 查找变量，ENV 设置的环境变量优先于 ARG
+func (b *builder) lookup(name string) (string, bool) {
+	for i := len(b.config.Env) - 1; i >= 0; i-- {
+		if key, value, _ := strings.Cut(b.config.Env[i], "="); key == name {
+			return value, true
+		}
+	}
+	value, ok := b.args[name]
+	return value, ok
+}
+
+// argEnv 返回 ARG 的值，作为 RUN 的环境变量
+func (b *builder) argEnv() []string {
+	env := make([]string, 0, len(b.args))
+	for name, value := range b.args {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// from 设置基础镜像，本地没有时从 registry 拉取，scratch 表示空镜像
+func (b *builder) from(instruction *build.Instruction) error {
+	words, err := build.ProcessWords(instruction.Args, func(name string) (string, bool) {
+		value, ok := b.metaArgs[name]
+		return value, ok
+	})
+	if err != nil {
+		return instruction.Errorf("%v", err)
+	}
+	if len(words) != 1 && !(len(words) == 3 && strings.EqualFold(words[1], "AS")) {
+		return instruction.Errorf("expected FROM <image> [AS <name>]")
+	}
+	b.config = &container.ImageConfig{}
+	if words[0] == "scratch" {
+		return nil
+	}
+	if err = ensureImage(words[0], instruction.Flags["platform"]); err != nil {
+		return err
+	}
+	img, err := image.Get(words[0])
+	if err != nil {
+		return err
+	}
+	return b.setImage(img)
+}
+
+// arg 声明构建参数，值的优先级从高到低依次为 --build-arg、默认值、FROM 之前声明的同名 ARG
+func (b *builder) arg(instruction *build.Instruction, beforeFrom bool) error {
+	lookup := b.lookup
+	if beforeFrom {
+		lookup = func(name string) (string, bool) {
+			value, ok := b.metaArgs[name]
+			return value, ok
+		}
+	}
+	words, err := build.ProcessWords(instruction.Args, lookup)
+	if err != nil {
+		return instruction.Errorf("%v", err)
+	}
+	for _, word := range words {
+		name, value, hasDefault := strings.Cut(word, "=")
+		if name == "" {
+			return instruction.Errorf("invalid ARG %s", word)
+		}
+		b.usedArgs[name] = true
+		if buildArg, ok := b.buildArgs[name]; ok {
+			value, hasDefault = buildArg, true
+		} else if !hasDefault && !beforeFrom {
+			value, hasDefault = b.metaArgs[name]
+		}
+		if !hasDefault {
+			continue
+		}
+		if beforeFrom {
+			b.metaArgs[name] = value
+		} else {
+			b.args[name] = value
+		}
+	}
+	return nil
+}
+
+// cached 查找构建缓存，命中时切换到缓存的镜像
+func (b *builder) cached(key string) (bool, error) {
+	if b.opts.NoCache {
+		return false, nil
+	}
+	img, ok := build.LookupCache(key)
+	if !ok {
+		return false, nil
+	}
+	fmt.Println(" ---> Using cache")
+	return true, b.setImage(img)
+}
+
+// commit 在当前镜像之上生成新的中间镜像并记录到构建缓存，layer 为 nil 时只修改镜像配置
+func (b *builder) commit(instruction *build.Instruction, key string, config *container.ImageConfig, layer *image.Descriptor, diffID string) error {
+	configFile := image.NewConfigFile()
+	var layers []image.Descriptor
+	var history []image.History
+	if b.img != nil {
+		if b.img.Config.Architecture != "" {
+			configFile.Architecture, configFile.OS = b.img.Config.Architecture, b.img.Config.OS
+		}
+		configFile.Author = b.img.Config.Author
+		configFile.RootFS.DiffIDs = append([]string{}, b.img.Config.RootFS.DiffIDs...)
+		layers = append([]image.Descriptor{}, b.img.Manifest.Layers...)
+		history = append([]image.History{}, b.img.Config.History...)
+		if len(history) == 0 {
+			// 基础镜像没有构建记录时为每一层补一条空记录，保持构建记录与层一一对应
+			history = make([]image.History, len(layers))
+		}
+	}
+	configFile.History = append(history, image.History{
+		Created:    configFile.Created,
+		CreatedBy:  instruction.Original,
+		EmptyLayer: layer == nil,
+	})
+	if layer != nil {
+		layers = append(layers, *layer)
+		configFile.RootFS.DiffIDs = append(configFile.RootFS.DiffIDs, diffID)
+	}
+	var err error
+	if configFile.Config, err = json.Marshal(config); err != nil {
+		return errors.WithMessage(err, "image config marshal failed")
+	}
+	img, err := image.WriteImage(configFile, layers)
+	if err != nil {
+		return err
+	}
+	if err = build.SaveCache(key, img.Digest); err != nil {
+		log.Warnf("save build cache failed, detail: %v", err)
+	}
+	return b.setImage(img)
+}
+
+// run 在当前镜像的容器中执行命令，容器的修改作为新的层
+func (b *builder) run(instruction *build.Instruction) error {
+	args, ok := instruction.JSONArgs()
+	if !ok {
+		args = []string{"/bin/sh", "-c", instruction.Args}
+	}
+	if !b.hasFilesystem() {
+		return instruction.Errorf("the image has no filesystem to run %v", args)
+	}
+	argEnv := b.argEnv()
+	command, _ := json.Marshal(args)
+	key := build.CacheKey(b.img.Digest, "RUN "+string(command), strings.Join(argEnv, "\n"))
+	if hit, err := b.cached(key); hit || err != nil {
+		return err
+	}
+
+	initConf := &container.InitConfig{Args: args, Cwd: b.config.WorkingDir, User: b.config.User}
+	// ENV 设置的环境变量优先于 ARG
+	envSlice := utils.MergeEnv(argEnv, b.config.Env)
+	// 与 docker build 一样，RUN 不分配终端也没有标准输入，输出直接显示在构建输出中
+	sigCh := notifySignals()
+	c, err := startContainer(false, initConf, nil, envSlice, &resource.ResourceConfig{}, "", "", b.imageRef(),
+		b.opts.Network, nil, nil, nil, nil, os.Stdout)
+	if err != nil {
+		stopSignals(sigCh)
+		return instruction.Errorf("%v", err)
+	}
+	fmt.Printf(" ---> Running in %s\n", c.Id)
+	exitCode := waitForwarding(c.Parent, sigCh)
+	if exitCode != 0 {
+		c.Cleanup()
+		return instruction.Errorf("the command %v returned a non-zero code: %d", args, exitCode)
+	}
+	layer, diffID, err := writeDiffLayer(utils.GetUpper(c.Id))
+	c.Cleanup()
+	if err != nil {
+		return err
+	}
+	return b.commit(instruction, key, b.config, &layer, diffID)
+}
+
+// copy 将构建上下文中的文件复制到镜像中，ADD 还会解压本地的 tar 包
+func (b *builder) copy(instruction *build.Instruction) error {
+	words, ok := instruction.JSONArgs()
+	if !ok {
+		var err error
+		if words, err = build.ProcessWords(instruction.Args, b.lookup); err != nil {
+			return instruction.Errorf("%v", err)
+		}
+	}
+	if len(words) < 2 {
+		return instruction.Errorf("requires at least two arguments")
+	}
+	srcs, dest := words[:len(words)-1], words[len(words)-1]
+	if !path.IsAbs(dest) {
+		workdir := b.config.WorkingDir
+		if workdir == "" {
+			workdir = "/"
+		}
+		dirSuffix := strings.HasSuffix(dest, "/")
+		dest = path.Join(workdir, dest)
+		if dirSuffix {
+			dest += "/"
+		}
+	}
+	var chmod os.FileMode
+	if value := instruction.Flags["chmod"]; value != "" {
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || mode > 0777 {
+			return instruction.Errorf("invalid --chmod %s", value)
+		}
+		chmod = os.FileMode(mode)
+	}
+	chown := instruction.Flags["chown"]
+
+	files, err := b.context.Plan(srcs, dest, instruction.Cmd == "ADD")
+	if err != nil {
+		return instruction.Errorf("%v", err)
+	}
+	hash, err := build.HashFiles(files)
+	if err != nil {
+		return err
+	}
+	key := build.CacheKey(b.parentDigest(), fmt.Sprintf("%s --chown=%s --chmod=%s %s", instruction.Cmd, chown, instruction.Flags["chmod"], dest), hash)
+	if hit, err := b.cached(key); hit || err != nil {
+		return err
+	}
+	layer, diffID, err := b.copyLayer(files, chown, chmod)
+	if err != nil {
+		return instruction.Errorf("%v", err)
+	}
+	return b.commit(instruction, key, b.config, &layer, diffID)
+}
+
+// copyLayer 将文件复制到当前镜像的 overlay 工作目录中，upper 目录打包为新的层，没有层的镜像直接复制到临时目录
+func (b *builder) copyLayer(files []build.CopyFile, chown string, chmod os.FileMode) (image.Descriptor, string, error) {
+	var root, upperDir string
+	if b.hasFilesystem() {
+		id := container.GenerateContainerID()
+		if err := container.NewWorkSpace(id, b.imageRef(), ""); err != nil {
+			return image.Descriptor{}, "", err
+		}
+		defer container.DeleteWorkSpace(id, "")
+		root, upperDir = utils.GetMerged(id), utils.GetUpper(id)
+	} else {
+		tmpDir, err := os.MkdirTemp("", "mycontainer-build-")
+		if err != nil {
+			return image.Descriptor{}, "", err
+		}
+		defer os.RemoveAll(tmpDir)
+		root, upperDir = tmpDir, tmpDir
+	}
+
+	uid, gid := 0, 0
+	if chown != "" {
+		// 按镜像中的 /etc/passwd 和 /etc/group 解析用户名和组名
+		user, err := container.ResolveUser(root, chown, nil)
+		if err != nil {
+			return image.Descriptor{}, "", errors.WithMessagef(err, "invalid --chown %s", chown)
+		}
+		uid, gid = user.Uid, user.Gid
+	}
+	if err := build.CopyFiles(root, files, uid, gid, chmod); err != nil {
+		return image.Descriptor{}, "", err
+	}
+	return writeDiffLayer(upperDir)
+}
+
+// configure 执行只修改镜像配置的指令
+func (b *builder) configure(instruction *build.Instruction) error {
+	// 重新解析镜像配置，修改时不影响当前镜像的配置
+	config := &container.ImageConfig{}
+	var err error
+	if b.img != nil {
+		if config, err = container.ImageConfigOf(b.img); err != nil {
+			return err
+		}
+	}
+
+	switch instruction.Cmd {
+	case "ENV", "LABEL":
+		pairs, err := build.ParseKeyValues(instruction.Args, b.lookup)
+		if err != nil {
+			return instruction.Errorf("%v", err)
+		}
+		for _, pair := range pairs {
+			if instruction.Cmd == "ENV" {
+				config.Env = utils.MergeEnv(config.Env, []string{pair[0] + "=" + pair[1]})
+				continue
+			}
+			if config.Labels == nil {
+				config.Labels = make(map[string]string)
+			}
+			config.Labels[pair[0]] = pair[1]
+		}
+	case "WORKDIR":
+		dir, err := build.ProcessWord(instruction.Args, b.lookup)
+		if err != nil {
+			return instruction.Errorf("%v", err)
+		}
+		// 相对路径相对于上一个 WORKDIR
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", config.WorkingDir, dir)
+		}
+		config.WorkingDir = path.Clean(dir)
+	case "USER", "EXPOSE", "VOLUME", "STOPSIGNAL":
+		args := instruction.Args
+		if _, isJSON := instruction.JSONArgs(); !isJSON {
+			if args, err = build.ProcessWord(args, b.lookup); err != nil {
+				return instruction.Errorf("%v", err)
+			}
+		}
+		if err = config.ApplyChange(instruction.Cmd + " " + args); err != nil {
+			return instruction.Errorf("%v", err)
+		}
+	case "CMD", "ENTRYPOINT":
+		cmd := config.Cmd
+		if err = config.ApplyChange(instruction.Cmd + " " + instruction.Args); err != nil {
+			return instruction.Errorf("%v", err)
+		}
+		if instruction.Cmd == "CMD" {
+			b.cmdSet = true
+		} else if b.cmdSet {
+			// 同一个 Dockerfile 中在 ENTRYPOINT 之前设置的 CMD 保留
+			config.Cmd = cmd
+		}
+	default:
+		return instruction.Errorf("unsupported instruction")
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return errors.WithMessage(err, "image config marshal failed")
+	}
+	key := build.CacheKey(b.parentDigest(), instruction.Original, string(data))
+	if hit, err := b.cached(key); hit || err != nil {
+		return err
+	}
+	return b.commit(instruction, key, config, nil, "")
+}
@@ -0,0 +1,98 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/aspirshar/myContainer/config"
+	"github.com/aspirshar/myContainer/image"
+	"github.com/pkg/errors"
+)
+
+// cacheFile 构建缓存，记录每一步的缓存 key 对应的镜像 manifest digest
+const cacheFile = "build-cache.json"
+
+// CacheKey 计算一步构建的缓存 key，由上一步的镜像、指令和指令的输入（e.g. 复制的文件的摘要）决定
+func CacheKey(parent string, parts ...string) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n", parent)
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%s\n", part)
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil))
+}
+
+// loadCache 读取构建缓存，文件不存在时返回空缓存
+func loadCache() (map[string]string, error) {
+	cache := make(map[string]string)
+	data, err := os.ReadFile(path.Join(config.ImagesPath, cacheFile))
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "read build cache failed")
+	}
+	if err = json.Unmarshal(data, &cache); err != nil {
+		return nil, errors.WithMessage(err, "parse build cache failed")
+	}
+	return cache, nil
+}
+
+// LookupCache 返回缓存 key 对应的镜像，镜像或其中的 blob 已经被删除时视为没有缓存
+func LookupCache(key string) (*image.Image, bool) {
+	cache, err := loadCache()
+	if err != nil {
+		return nil, false
+	}
+	digest, ok := cache[key]
+	if !ok {
+		return nil, false
+	}
+	img, err := image.GetByDigest(digest)
+	if err != nil {
+		return nil, false
+	}
+	for _, blob := range img.Blobs() {
+		if !image.BlobExists(blob) {
+			return nil, false
+		}
+	}
+	return img, true
+}
+
+// SaveCache 记录缓存 key 对应的镜像 manifest digest
+func SaveCache(key, digest string) error {
+	cachePath := path.Join(config.ImagesPath, cacheFile)
+	lockFile, err := os.OpenFile(cachePath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return errors.WithMessage(err, "open build cache lock failed")
+	}
+	defer lockFile.Close()
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return errors.WithMessage(err, "lock build cache failed")
+	}
+	cache, err := loadCache()
+	if err != nil {
+		return err
+	}
+	// 删除指向已删除镜像的缓存
+	for k, d := range cache {
+		if !image.BlobExists(d) {
+			delete(cache, k)
+		}
+	}
+	cache[key] = digest
+	data, err := json.MarshalIndent(cache, "", "    ")
+	if err != nil {
+		return errors.WithMessage(err, "build cache marshal failed")
+	}
+	tmpPath := cachePath + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.WithMessage(err, "write build cache failed")
+	}
+	return errors.WithMessage(os.Rename(tmpPath, cachePath), "rename build cache failed")
+}
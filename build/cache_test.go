@@ -0,0 +1,43 @@
+package build
+
+import (
+	"os"
+	"testing"
+
+	"github.com/aspirshar/myContainer/config"
+	"github.com/aspirshar/myContainer/image"
+)
+
+func TestCache(t *testing.T) {
+	root := t.TempDir()
+	config.ImagesPath = root + "/images/"
+	config.LayersPath = root + "/layers/"
+	os.MkdirAll(config.ImagesPath, 0755)
+
+	key := CacheKey("scratch", "CMD sh")
+	if key == CacheKey("scratch", "CMD", "sh") || key != CacheKey("scratch", "CMD sh") {
+		t.Fatal("cache key should depend on every part")
+	}
+	if _, ok := LookupCache(key); ok {
+		t.Fatal("unexpected cache hit")
+	}
+	img, err := image.WriteImage(image.NewConfigFile(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = SaveCache(key, img.Digest); err != nil {
+		t.Fatal(err)
+	}
+	cached, ok := LookupCache(key)
+	if !ok || cached.Digest != img.Digest {
+		t.Fatalf("expected cache hit for %s", img.Digest)
+	}
+
+	// 镜像被删除后缓存失效
+	if _, _, err = image.Delete(img.Digest, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = LookupCache(key); ok {
+		t.Fatal("cache should miss after the image is deleted")
+	}
+}
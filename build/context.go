@@ -0,0 +1,312 @@
+package build
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aspirshar/myContainer/image"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// CopyFile COPY、ADD 复制到镜像中的一个文件、目录或符号链接
+type CopyFile struct {
+	Src     string      // 源文件的路径
+	Dest    string      // 镜像中的绝对路径
+	Mode    os.FileMode // 源文件的类型和权限
+	ModTime time.Time
+	Link    string // 符号链接的目标
+	Extract bool   // ADD 的本地 tar 包，解压到 Dest 目录
+	Name    string // dest 没有以 / 结尾时的源文件名，Dest 在镜像中已经是目录时复制为 Dest/Name
+}
+
+// Context 构建上下文，COPY、ADD 只能复制构建上下文中没有被 .dockerignore 忽略的文件
+type Context struct {
+	Dir    string
+	Ignore *Ignore
+}
+
+// NewContext 打开构建上下文并读取 .dockerignore
+func NewContext(dir string) (*Context, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, errors.Errorf("build context %s is not a directory", dir)
+	}
+	ignore, err := ReadIgnore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Context{Dir: dir, Ignore: ignore}, nil
+}
+
+// Plan 列出 COPY、ADD 要复制的文件，dest 为镜像中的绝对路径
+/*
+1.源路径相对于构建上下文，可以使用通配符，.. 不能跳出构建上下文
+2.源路径是目录时复制目录中的内容，不包括目录本身
+3.dest 以 / 结尾或有多个源文件时 dest 是目录，文件复制到 dest 目录下，否则 dest 是文件的路径，
+  dest 在镜像中已经是目录时由 CopyFiles 复制到该目录下
+4.add 为 true 时本地的 tar 包解压到 dest 目录，不支持从 URL 下载
+*/
+func (c *Context) Plan(srcs []string, dest string, add bool) ([]CopyFile, error) {
+	destIsDir := strings.HasSuffix(dest, "/")
+	dest = path.Clean(dest)
+	var files []CopyFile
+	var matches []string
+	for _, src := range srcs {
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			return nil, errors.Errorf("source %s is a url, only files in the build context can be copied", src)
+		}
+		matched, err := c.match(src)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, matched...)
+	}
+	if len(matches) > 1 && !destIsDir {
+		return nil, errors.Errorf("when copying more than one source file, the destination %s must be a directory and end with a /", dest)
+	}
+
+	for _, rel := range matches {
+		src, err := image.ResolveInRoot(c.Dir, rel, true)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "%s not found in build context", rel)
+		}
+		switch {
+		case info.IsDir():
+			dirFiles, err := c.walk(src, rel, dest)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, dirFiles...)
+		case add && image.IsArchive(src):
+			files = append(files, CopyFile{Src: src, Dest: dest, Mode: info.Mode(), ModTime: info.ModTime(), Extract: true})
+		default:
+			file := CopyFile{Src: src, Dest: dest, Mode: info.Mode(), ModTime: info.ModTime(), Name: path.Base(rel)}
+			if destIsDir {
+				file.Dest, file.Name = path.Join(dest, path.Base(rel)), ""
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// match 返回源路径匹配的文件，路径相对于构建上下文
+func (c *Context) match(src string) ([]string, error) {
+	rel := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(src)), "/")
+	if rel == "" {
+		return []string{"."}, nil
+	}
+	if !strings.ContainsAny(rel, "*?[") {
+		if c.Ignore.Match(rel) {
+			return nil, errors.Errorf("%s is excluded by %s", src, IgnoreFile)
+		}
+		if _, err := os.Lstat(filepath.Join(c.Dir, rel)); err != nil {
+			return nil, errors.Errorf("%s not found in build context", src)
+		}
+		return []string{rel}, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(c.Dir, rel))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid pattern %s", src)
+	}
+	var matches []string
+	for _, p := range paths {
+		matchedRel, err := filepath.Rel(c.Dir, p)
+		if err != nil || c.Ignore.Match(matchedRel) {
+			continue
+		}
+		matches = append(matches, filepath.ToSlash(matchedRel))
+	}
+	if len(matches) == 0 {
+		return nil, errors.Errorf("no source files were specified by %s", src)
+	}
+	return matches, nil
+}
+
+// walk 列出目录中没有被忽略的文件，复制到 dest 目录下
+func (c *Context) walk(dir, rel, dest string) ([]CopyFile, error) {
+	files := []CopyFile{}
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		sub, err := filepath.Rel(dir, p)
+		if err != nil || sub == "." {
+			return err
+		}
+		sub = filepath.ToSlash(sub)
+		if c.Ignore.Match(path.Join(rel, sub)) {
+			// 有 ! 规则时目录下仍可能有需要复制的文件
+			if entry.IsDir() && !c.Ignore.HasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		file := CopyFile{Src: p, Dest: path.Join(dest, sub), Mode: info.Mode(), ModTime: info.ModTime()}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if file.Link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		files = append(files, file)
+		return nil
+	})
+	return files, errors.WithMessagef(err, "walk %s failed", rel)
+}
+
+// HashFiles 计算要复制的文件的摘要，作为构建缓存的一部分，只包含路径、权限和内容，不包含修改时间
+func HashFiles(files []CopyFile) (string, error) {
+	hash := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(hash, "%s\x00%s\x00%o\x00%s\x00%v\x00", f.Dest, f.Name, f.Mode, f.Link, f.Extract)
+		if !f.Mode.IsRegular() {
+			continue
+		}
+		file, err := os.Open(f.Src)
+		if err != nil {
+			return "", errors.WithMessagef(err, "open %s failed", f.Src)
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", errors.WithMessagef(err, "read %s failed", f.Src)
+		}
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+// CopyFiles 将文件复制到镜像的根目录 root 中
+/*
+1.镜像中的路径按 root 为根目录解析符号链接，不会写到 root 之外
+2.dest 没有以 / 结尾但在镜像中已经是目录时，文件复制到该目录下，与 docker 一致
+3.文件属主设置为 uid、gid，chmod 不为 0 时替换文件的权限，修改时间保持不变
+4.目录的修改时间在所有文件复制完后再设置
+*/
+func CopyFiles(root string, files []CopyFile, uid, gid int, chmod os.FileMode) error {
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []dirTime
+	for _, f := range files {
+		if f.Extract {
+			if err := extractFile(root, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Name != "" {
+			existing, err := image.ResolveInRoot(root, f.Dest, true)
+			if err != nil {
+				return err
+			}
+			if info, err := os.Lstat(existing); err == nil && info.IsDir() {
+				f.Dest = path.Join(f.Dest, f.Name)
+			}
+		}
+		// 目录跟随最后一级的符号链接，e.g. 复制到 /bin/ 而 /bin 是指向 usr/bin 的符号链接
+		target, err := image.ResolveInRoot(root, f.Dest, f.Mode.IsDir())
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return errors.WithMessagef(err, "mkdir %s failed", path.Dir(f.Dest))
+		}
+		if err = copyFile(target, f); err != nil {
+			return errors.WithMessagef(err, "copy %s to %s failed", f.Src, f.Dest)
+		}
+		if err = os.Lchown(target, uid, gid); err != nil {
+			return errors.WithMessagef(err, "chown %s failed", f.Dest)
+		}
+		if f.Mode&os.ModeSymlink != 0 {
+			continue
+		}
+		mode := f.Mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if chmod != 0 {
+			mode = chmod
+		}
+		if err = os.Chmod(target, mode); err != nil {
+			return errors.WithMessagef(err, "chmod %s failed", f.Dest)
+		}
+		if f.Mode.IsDir() {
+			dirs = append(dirs, dirTime{target, f.ModTime})
+		} else if err = os.Chtimes(target, f.ModTime, f.ModTime); err != nil {
+			return errors.WithMessagef(err, "set mtime of %s failed", f.Dest)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime); err != nil {
+			return errors.WithMessagef(err, "set mtime of %s failed", dirs[i].path)
+		}
+	}
+	return nil
+}
+
+// copyFile 创建目录、符号链接或复制普通文件的内容，已有的同名文件被替换
+func copyFile(target string, f CopyFile) error {
+	existing, err := os.Lstat(target)
+	if err == nil && !(existing.IsDir() && f.Mode.IsDir()) {
+		if err = os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	switch {
+	case f.Mode.IsDir():
+		return os.MkdirAll(target, 0755)
+	case f.Mode&os.ModeSymlink != 0:
+		return os.Symlink(f.Link, target)
+	case f.Mode.IsRegular():
+		src, err := os.Open(f.Src)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY|unix.O_NOFOLLOW, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	default:
+		return errors.Errorf("unsupported file type %s", f.Mode.Type())
+	}
+}
+
+// extractFile 将 ADD 的本地 tar 包解压到目标目录
+func extractFile(root string, f CopyFile) error {
+	target, err := image.ResolveInRoot(root, f.Dest, true)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(target, 0755); err != nil {
+		return errors.WithMessagef(err, "mkdir %s failed", f.Dest)
+	}
+	file, err := os.Open(f.Src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return errors.WithMessagef(image.ExtractArchive(target, file), "extract %s to %s failed", f.Src, f.Dest)
+}
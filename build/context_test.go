@@ -0,0 +1,269 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeFiles 在 dir 下创建文件，内容为空的路径以 / 结尾时创建目录
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		filePath := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dests(files []CopyFile) []string {
+	var result []string
+	for _, f := range files {
+		result = append(result, f.Dest)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestPlan(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		IgnoreFile:       "logs\n**/*.tmp\n",
+		"src/a.txt":      "a",
+		"src/sub/b.txt":  "b",
+		"src/skip.tmp":   "skip",
+		"logs/x.log":     "log",
+		"data/c.txt":     "c",
+		"data/d.txt":     "d",
+		"outside/e.txt":  "e",
+		"archive/README": "not an archive",
+	})
+	if err := os.Symlink("a.txt", filepath.Join(dir, "src/link")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Name: "x", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("x"))
+	tw.Close()
+	gw.Close()
+	writeFiles(t, dir, map[string]string{"app.tar.gz": buf.String()})
+
+	ctx, err := NewContext(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ctx.Plan([]string{"src"}, "/app/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dests(files); !reflect.DeepEqual(got, []string{"/app/a.txt", "/app/link", "/app/sub", "/app/sub/b.txt"}) {
+		t.Fatalf("unexpected files %v", got)
+	}
+	for _, f := range files {
+		if f.Dest == "/app/link" && (f.Link != "a.txt" || f.Mode&os.ModeSymlink == 0) {
+			t.Fatalf("unexpected symlink %+v", f)
+		}
+	}
+
+	files, err = ctx.Plan([]string{"src/a.txt"}, "/bin/a", false)
+	if err != nil || !reflect.DeepEqual(dests(files), []string{"/bin/a"}) {
+		t.Fatalf("unexpected files %v, %v", dests(files), err)
+	}
+	files, err = ctx.Plan([]string{"data/*.txt"}, "/data/", false)
+	if err != nil || !reflect.DeepEqual(dests(files), []string{"/data/c.txt", "/data/d.txt"}) {
+		t.Fatalf("unexpected files %v, %v", dests(files), err)
+	}
+	// .. 不能跳出构建上下文
+	files, err = ctx.Plan([]string{"../../outside/e.txt"}, "/e", false)
+	if err != nil || files[0].Src != filepath.Join(dir, "outside/e.txt") {
+		t.Fatalf("unexpected files %+v, %v", files, err)
+	}
+
+	files, err = ctx.Plan([]string{"app.tar.gz", "archive/README"}, "/opt/", true)
+	if err != nil || len(files) != 2 || !files[0].Extract || files[0].Dest != "/opt" || files[1].Extract {
+		t.Fatalf("unexpected ADD files %+v, %v", files, err)
+	}
+	if files, err = ctx.Plan([]string{"app.tar.gz"}, "/opt/", false); err != nil || files[0].Extract {
+		t.Fatalf("COPY should not extract archives %+v, %v", files, err)
+	}
+
+	for _, srcs := range [][]string{{"logs/x.log"}, {"src/skip.tmp"}, {"missing"}, {"*.none"}, {"data/*.txt", "src/a.txt"}} {
+		dest := "/dest/"
+		if len(srcs) > 1 {
+			dest = "/dest"
+		}
+		if _, err = ctx.Plan(srcs, dest, false); err == nil {
+			t.Fatalf("expected error for %v", srcs)
+		}
+	}
+}
+
+func TestPlanURL(t *testing.T) {
+	ctx, err := NewContext(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, add := range []bool{true, false} {
+		if _, err = ctx.Plan([]string{"https://example.com/tool.sh"}, "/usr/bin/", add); err == nil {
+			t.Fatalf("expected error for url, add %v", add)
+		}
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "a"})
+	ctx, err := NewContext(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := func() string {
+		files, err := ctx.Plan([]string{"a.txt"}, "/a.txt", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := HashFiles(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	first := hash()
+	// 修改时间不影响摘要
+	if err = os.Chtimes(filepath.Join(dir, "a.txt"), time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if hash() != first {
+		t.Fatal("mtime should not change the hash")
+	}
+	writeFiles(t, dir, map[string]string{"a.txt": "b"})
+	if hash() == first {
+		t.Fatal("content change should change the hash")
+	}
+}
+
+func TestCopyFiles(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFiles(t, dir, map[string]string{"src/a.txt": "a", "src/sub/b.txt": "b"})
+	if err := os.Symlink("a.txt", filepath.Join(dir, "src/link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"src/a.txt", "src/sub/b.txt", "src/sub"} {
+		if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, err := NewContext(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	// /bin 是指向 usr/bin 的符号链接，/escape 指向根目录之外，复制时都按 root 解析
+	if err = os.MkdirAll(filepath.Join(root, "usr/bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("usr/bin", filepath.Join(root, "bin")); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err = os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{"app/a.txt": "old"})
+
+	files, err := ctx.Plan([]string{"src"}, "/app/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool, err := ctx.Plan([]string{"src/a.txt"}, "/bin/tool", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	escape, err := ctx.Plan([]string{"src/a.txt"}, "/escape/a.txt", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(append(files, tool...), escape...)
+	if err = CopyFiles(root, files, os.Getuid(), os.Getgid(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = CopyFiles(root, tool, os.Getuid(), os.Getgid(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"app/a.txt": "a", "app/sub/b.txt": "b", "usr/bin/tool": "a", "escape/a.txt": "a"} {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if name == "escape/a.txt" {
+			// escape 是符号链接，按 root 解析后写到 root/<outside> 中
+			data, err = os.ReadFile(filepath.Join(root, outside, "a.txt"))
+		}
+		if err != nil || string(data) != want {
+			t.Fatalf("unexpected content of %s: %q, %v", name, data, err)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(outside, "a.txt")); !os.IsNotExist(err) {
+		t.Fatal("file escaped the root")
+	}
+	if link, err := os.Readlink(filepath.Join(root, "app/link")); err != nil || link != "a.txt" {
+		t.Fatalf("unexpected link %s, %v", link, err)
+	}
+	info, err := os.Stat(filepath.Join(root, "usr/bin/tool"))
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("unexpected mode of tool %v, %v", info.Mode(), err)
+	}
+	for _, name := range []string{"app/a.txt", "app/sub"} {
+		info, err := os.Stat(filepath.Join(root, name))
+		if err != nil || !info.ModTime().Equal(mtime) {
+			t.Fatalf("unexpected mtime of %s: %v, %v", name, info.ModTime(), err)
+		}
+	}
+}
+
+func TestCopyFilesIntoExistingDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.sh": "#!/bin/sh"})
+	ctx, err := NewContext(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"usr/local/bin/keep": "keep", "etc/app.sh": "old"})
+	if err = os.Symlink("usr/local/bin", filepath.Join(root, "bin")); err != nil {
+		t.Fatal(err)
+	}
+
+	// dest 没有以 / 结尾，但在镜像中已经是目录（或指向目录的符号链接）时复制到目录下，已有的文件不受影响
+	for _, dest := range []string{"/usr/local/bin", "/bin", "/etc/app.sh"} {
+		files, err := ctx.Plan([]string{"app.sh"}, dest, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = CopyFiles(root, files, os.Getuid(), os.Getgid(), 0); err != nil {
+			t.Fatalf("%s: %v", dest, err)
+		}
+	}
+	for name, want := range map[string]string{"usr/local/bin/keep": "keep", "usr/local/bin/app.sh": "#!/bin/sh", "etc/app.sh": "#!/bin/sh"} {
+		if data, err := os.ReadFile(filepath.Join(root, name)); err != nil || string(data) != want {
+			t.Fatalf("unexpected content of %s: %q, %v", name, data, err)
+		}
+	}
+	if info, err := os.Lstat(filepath.Join(root, "bin")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("symlink to the directory should be kept")
+	}
+}
@@ -0,0 +1,126 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Instruction Dockerfile 中的一条指令
+type Instruction struct {
+	Cmd      string            // 大写的指令名称，e.g. RUN
+	Args     string            // 去掉选项后的参数
+	Flags    map[string]string // --name=value 形式的选项，e.g. COPY --chown=1000:1000
+	Line     int               // 指令所在的行号
+	Original string            // 指令原文，续行已经合并
+}
+
+// flagsAllowed 各指令支持的选项
+var flagsAllowed = map[string][]string{
+	"FROM": {"platform"},
+	"COPY": {"chown", "chmod"},
+	"ADD":  {"chown", "chmod"},
+}
+
+// Parse 解析 Dockerfile
+/*
+1.以 # 开头的行是注释，空行忽略，注释行和空行不会打断续行
+2.行尾的反斜杠表示续行，与下一行合并
+3.指令名称不区分大小写，紧跟指令名称的 --name=value 是指令的选项
+*/
+func Parse(r io.Reader) ([]*Instruction, error) {
+	var instructions []*Instruction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	var current strings.Builder
+	startLine, lineNum := 0, 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if current.Len() == 0 {
+			startLine = lineNum
+		}
+		if continued, ok := strings.CutSuffix(line, "\\"); ok {
+			current.WriteString(continued)
+			continue
+		}
+		current.WriteString(line)
+		instruction, err := parseInstruction(current.String(), startLine)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithMessage(err, "read Dockerfile failed")
+	}
+	if current.Len() > 0 {
+		instruction, err := parseInstruction(current.String(), startLine)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+	if len(instructions) == 0 {
+		return nil, errors.New("the Dockerfile has no instructions")
+	}
+	return instructions, nil
+}
+
+// parseInstruction 解析一条指令的名称和选项
+func parseInstruction(text string, line int) (*Instruction, error) {
+	cmd, args := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		cmd, args = text[:i], text[i+1:]
+	}
+	instruction := &Instruction{
+		Cmd:      strings.ToUpper(cmd),
+		Flags:    make(map[string]string),
+		Line:     line,
+		Original: text,
+	}
+	args = strings.TrimSpace(args)
+	for strings.HasPrefix(args, "--") {
+		var flag string
+		flag, args = args, ""
+		if i := strings.IndexAny(flag, " \t"); i >= 0 {
+			flag, args = flag[:i], strings.TrimSpace(flag[i+1:])
+		}
+		name, value, _ := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+		if !slices.Contains(flagsAllowed[instruction.Cmd], name) {
+			return nil, errors.Errorf("Dockerfile line %d: unknown flag --%s for %s", line, name, instruction.Cmd)
+		}
+		instruction.Flags[name] = value
+	}
+	if args == "" {
+		return nil, errors.Errorf("Dockerfile line %d: %s requires at least one argument", line, instruction.Cmd)
+	}
+	instruction.Args = args
+	return instruction, nil
+}
+
+// JSONArgs 解析 JSON 数组形式的参数，e.g. RUN ["echo", "hi"]，不是 JSON 数组时返回 false
+func (i *Instruction) JSONArgs() ([]string, bool) {
+	if !strings.HasPrefix(i.Args, "[") {
+		return nil, false
+	}
+	var args []string
+	if err := json.Unmarshal([]byte(i.Args), &args); err != nil {
+		return nil, false
+	}
+	return args, true
+}
+
+// Errorf 返回带有指令行号的错误
+func (i *Instruction) Errorf(format string, args ...interface{}) error {
+	return errors.Errorf("Dockerfile line %d: %s: %s", i.Line, i.Cmd, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,93 @@
+package build
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dockerfile := `# syntax comment
+from busybox AS base
+
+RUN echo a \
+    # comment inside continuation
+    && echo b
+COPY --chown=1000:1000 --chmod=755 src/ /app/
+CMD ["sh", "-c", "echo hi"]
+`
+	instructions, err := Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instructions) != 4 {
+		t.Fatalf("expected 4 instructions, got %d", len(instructions))
+	}
+	if instructions[0].Cmd != "FROM" || instructions[0].Args != "busybox AS base" || instructions[0].Line != 2 {
+		t.Fatalf("unexpected FROM %+v", instructions[0])
+	}
+	if instructions[1].Args != "echo a && echo b" || instructions[1].Line != 4 {
+		t.Fatalf("unexpected RUN %+v", instructions[1])
+	}
+	copyIns := instructions[2]
+	if copyIns.Args != "src/ /app/" || copyIns.Flags["chown"] != "1000:1000" || copyIns.Flags["chmod"] != "755" {
+		t.Fatalf("unexpected COPY %+v", copyIns)
+	}
+	if args, ok := instructions[3].JSONArgs(); !ok || !reflect.DeepEqual(args, []string{"sh", "-c", "echo hi"}) {
+		t.Fatalf("unexpected CMD args %v", args)
+	}
+	if _, ok := instructions[1].JSONArgs(); ok {
+		t.Fatal("shell form should not be parsed as JSON")
+	}
+
+	for _, bad := range []string{"", "# only comment\n", "RUN --mount=type=cache make\n", "FROM busybox\nENV\n"} {
+		if _, err = Parse(strings.NewReader(bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestProcessWords(t *testing.T) {
+	vars := map[string]string{"APP": "/app", "NAME": "my app", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`$APP/bin ${APP}/lib`, []string{"/app/bin", "/app/lib"}},
+		{`$NAME`, []string{"my app"}},
+		{`"a b" 'c $APP' "d $APP"`, []string{"a b", "c $APP", "d /app"}},
+		{`\$APP a\ b`, []string{"$APP", "a b"}},
+		{`${EMPTY:-def} ${APP:-def} ${APP:+set} x${EMPTY:+set}`, []string{"def", "/app", "set", "x"}},
+		{`$MISSING-x $ 5$`, []string{"-x", "$", "5$"}},
+	}
+	for _, test := range tests {
+		got, err := ProcessWords(test.in, lookup)
+		if err != nil {
+			t.Fatalf("%s: %v", test.in, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s: expected %q, got %q", test.in, test.want, got)
+		}
+	}
+	if word, err := ProcessWord(`hello  "$NAME"`, lookup); err != nil || word != "hello  my app" {
+		t.Fatalf("unexpected word %q, %v", word, err)
+	}
+	for _, bad := range []string{`"open`, `${APP`, `${1A}`, `${APP:?err}`, `a\`} {
+		if _, err := ProcessWords(bad, lookup); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+
+	pairs, err := ParseKeyValues(`A=$APP B="x y"`, lookup)
+	if err != nil || !reflect.DeepEqual(pairs, [][2]string{{"A", "/app"}, {"B", "x y"}}) {
+		t.Fatalf("unexpected pairs %v, %v", pairs, err)
+	}
+	pairs, err = ParseKeyValues(`KEY value of $NAME`, lookup)
+	if err != nil || !reflect.DeepEqual(pairs, [][2]string{{"KEY", "value of my app"}}) {
+		t.Fatalf("unexpected pairs %v, %v", pairs, err)
+	}
+}
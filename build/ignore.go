@@ -0,0 +1,147 @@
+package build
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IgnoreFile 构建上下文中的忽略文件，其中的文件不会发送给 COPY、ADD
+const IgnoreFile = ".dockerignore"
+
+// ignorePattern .dockerignore 中的一条规则
+type ignorePattern struct {
+	regexp *regexp.Regexp
+	negate bool // 以 ! 开头的规则，重新包含之前忽略的文件
+}
+
+// Ignore .dockerignore 中的规则，按顺序匹配，最后一条匹配的规则生效
+type Ignore struct {
+	patterns []ignorePattern
+}
+
+// ReadIgnore 读取构建上下文中的 .dockerignore，文件不存在时不忽略任何文件
+func ReadIgnore(contextDir string) (*Ignore, error) {
+	file, err := os.Open(filepath.Join(contextDir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return &Ignore{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "open %s failed", IgnoreFile)
+	}
+	defer file.Close()
+	return ParseIgnore(file)
+}
+
+// ParseIgnore 解析 .dockerignore 的规则
+/*
+1.每行一条规则，以 # 开头的行是注释，规则相对于构建上下文的根目录，开头的 / 可以省略
+2.* 匹配路径中一级内的任意字符，? 匹配一个字符，** 匹配任意多级目录
+3.规则匹配一个目录时，目录下的所有文件都被忽略
+*/
+func ParseIgnore(r io.Reader) (*Ignore, error) {
+	ignore := &Ignore{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern := ignorePattern{}
+		if negated, ok := strings.CutPrefix(line, "!"); ok {
+			pattern.negate, line = true, strings.TrimSpace(negated)
+		}
+		line = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(line)), "/")
+		if line == "" {
+			continue
+		}
+		re, err := compilePattern(line)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid %s pattern %s", IgnoreFile, line)
+		}
+		pattern.regexp = re
+		ignore.patterns = append(ignore.patterns, pattern)
+	}
+	return ignore, errors.WithMessagef(scanner.Err(), "read %s failed", IgnoreFile)
+}
+
+// compilePattern 将规则转换为正则表达式
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && strings.HasPrefix(pattern[i:], "**/"):
+			// **/ 匹配零级或多级目录
+			re.WriteString("(.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case c == '[':
+			// 字符类原样保留，e.g. [a-z]
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, errors.New("missing ']'")
+			}
+			re.WriteString(pattern[i : i+end+1])
+			i += end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// Match 判断相对于构建上下文的路径是否被忽略，规则匹配路径本身或它的某一级父目录时都算匹配
+func (ig *Ignore) Match(rel string) bool {
+	rel = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(rel)), "/")
+	if rel == "" {
+		return false
+	}
+	ignored := false
+	for _, pattern := range ig.patterns {
+		if pattern.negate == ignored && pattern.matchPathOrParent(rel) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// HasExceptions 是否有 ! 规则，有时被忽略的目录下仍可能有需要包含的文件，不能跳过整个目录
+func (ig *Ignore) HasExceptions() bool {
+	for _, pattern := range ig.patterns {
+		if pattern.negate {
+			return true
+		}
+	}
+	return false
+}
+
+func (p ignorePattern) matchPathOrParent(rel string) bool {
+	for {
+		if p.regexp.MatchString(rel) {
+			return true
+		}
+		i := strings.LastIndex(rel, "/")
+		if i < 0 {
+			return false
+		}
+		rel = rel[:i]
+	}
+}
@@ -0,0 +1,53 @@
+package build
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnore(t *testing.T) {
+	ignore, err := ParseIgnore(strings.NewReader(`
+# comment
+/logs
+*.tmp
+**/*.bak
+docs/*
+!docs/README.md
+build?
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"logs":            true,
+		"logs/app.log":    true,
+		"src/logs":        false,
+		"a.tmp":           true,
+		"src/a.tmp":       false,
+		"a.bak":           true,
+		"src/deep/a.bak":  true,
+		"docs/guide.md":   true,
+		"docs/README.md":  false,
+		"docs":            false,
+		"build1/out":      true,
+		"build":           false,
+		"src/main.go":     false,
+		"./logs/../a.tmp": true,
+	}
+	for rel, want := range tests {
+		if got := ignore.Match(rel); got != want {
+			t.Errorf("Match(%s) = %v, expected %v", rel, got, want)
+		}
+	}
+	if !ignore.HasExceptions() {
+		t.Fatal("expected exceptions")
+	}
+
+	if _, err = ParseIgnore(strings.NewReader("[abc\n")); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+	empty, err := ReadIgnore(t.TempDir())
+	if err != nil || empty.Match("anything") || empty.HasExceptions() {
+		t.Fatalf("missing %s should not ignore anything, %v", IgnoreFile, err)
+	}
+}
@@ -0,0 +1,175 @@
+package build
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Lookup 查找构建时的变量，ENV 设置的环境变量优先于 ARG
+type Lookup func(name string) (string, bool)
+
+// ProcessWords 按空白拆分参数，去掉引号并替换变量，e.g. COPY "a b" $DIR/ 拆分为 [a b, /app/]
+/*
+1.单引号中的内容原样保留，双引号和引号之外的 $NAME、${NAME} 替换为变量的值
+2.支持 ${NAME:-default}（变量为空时使用默认值）和 ${NAME:+value}（变量不为空时使用 value）
+3.反斜杠转义下一个字符，e.g. \$HOME 不替换
+4.变量的值不会再按空白拆分
+*/
+func ProcessWords(s string, lookup Lookup) ([]string, error) {
+	return process(s, lookup, true)
+}
+
+// ProcessWord 与 ProcessWords 相同，但空白不拆分，用于 ENV key value 这类整行为一个值的参数
+func ProcessWord(s string, lookup Lookup) (string, error) {
+	words, err := process(s, lookup, false)
+	if err != nil || len(words) == 0 {
+		return "", err
+	}
+	return words[0], nil
+}
+
+func process(s string, lookup Lookup, split bool) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && quote != '\'':
+			if i+1 == len(runes) {
+				return nil, errors.Errorf("unterminated escape in %s", s)
+			}
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == '$' && quote != '\'':
+			value, n, err := expandVariable(runes[i+1:], lookup)
+			if err != nil {
+				return nil, errors.WithMessage(err, s)
+			}
+			word.WriteString(value)
+			i += n
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case split && (r == ' ' || r == '\t' || r == '\n'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.Errorf("unterminated quote in %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// expandVariable 替换 $ 之后的变量，返回变量的值和变量名称占用的字符数，$ 之后不是变量名称时 $ 原样保留
+func expandVariable(runes []rune, lookup Lookup) (string, int, error) {
+	if len(runes) == 0 {
+		return "$", 0, nil
+	}
+	if runes[0] != '{' {
+		n := 0
+		for n < len(runes) && isNameRune(runes[n], n == 0) {
+			n++
+		}
+		if n == 0 {
+			return "$", 0, nil
+		}
+		value, _ := lookup(string(runes[:n]))
+		return value, n, nil
+	}
+
+	end := -1
+	for i, depth := 1, 0; i < len(runes); i++ {
+		if runes[i] == '{' {
+			depth++
+		} else if runes[i] == '}' {
+			if depth == 0 {
+				end = i
+				break
+			}
+			depth--
+		}
+	}
+	if end < 0 {
+		return "", 0, errors.New("missing '}' in variable")
+	}
+	expr := string(runes[1:end])
+	name, modifier := expr, ""
+	if i := strings.Index(expr, ":"); i >= 0 {
+		name, modifier = expr[:i], expr[i:]
+	}
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isNameRune(r, false) }) >= 0 || !isNameRune([]rune(name)[0], true) {
+		return "", 0, errors.Errorf("invalid variable ${%s}", expr)
+	}
+	value, _ := lookup(name)
+	var err error
+	switch {
+	case modifier == "":
+	case strings.HasPrefix(modifier, ":-"):
+		if value == "" {
+			value, err = ProcessWord(modifier[2:], lookup)
+		}
+	case strings.HasPrefix(modifier, ":+"):
+		if value != "" {
+			value, err = ProcessWord(modifier[2:], lookup)
+		}
+	default:
+		return "", 0, errors.Errorf("unsupported modifier in ${%s}", expr)
+	}
+	return value, end + 1, err
+}
+
+// isNameRune 判断字符能否出现在变量名称中，first 表示名称的第一个字符
+func isNameRune(r rune, first bool) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (!first && r >= '0' && r <= '9')
+}
+
+// ParseKeyValues 解析 ENV、LABEL、ARG 这类 key=value 参数并替换变量，支持旧的 key value 形式（第一个空白之后都是值）
+func ParseKeyValues(args string, lookup Lookup) ([][2]string, error) {
+	words, err := ProcessWords(args, lookup)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, errors.New("missing key")
+	}
+	if !strings.Contains(words[0], "=") {
+		key, value := strings.TrimSpace(args), ""
+		if i := strings.IndexAny(key, " \t"); i >= 0 {
+			key, value = key[:i], strings.TrimSpace(key[i+1:])
+		}
+		if value, err = ProcessWord(value, lookup); err != nil {
+			return nil, err
+		}
+		return [][2]string{{words[0], value}}, nil
+	}
+	pairs := make([][2]string, 0, len(words))
+	for _, word := range words {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok || key == "" {
+			return nil, errors.Errorf("invalid key=value %s", word)
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, nil
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"io"
+	"strconv"
+
 	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/image"
 	"github.com/aspirshar/myContainer/utils"
-	"os/exec"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -11,31 +15,150 @@ import (
 
 var ErrImageAlreadyExists = errors.New("Image Already Exists")
 
-func commitContainer(containerIDOrName, imageName string) error {
+// commitOptions commit 命令的参数
+type commitOptions struct {
+	Labels  map[string]string // 镜像标签，覆盖父镜像中的同名标签
+	Message string            // 提交说明，记录在镜像的构建记录中
+	Author  string            // 镜像作者
+	Changes []string          // 按 Dockerfile 指令修改镜像配置，e.g. CMD ["sh"]
+	Pause   bool              // 提交期间暂停运行中的容器，保证文件系统一致
+}
+
+// commitContainer 将容器相对于镜像的修改提交为新的一层，在父镜像之上生成新镜像并打上 imageName 标签
+/*
+1.镜像名称已经存在时返回 ErrImageAlreadyExists
+2.找到容器使用的父镜像，新镜像继承父镜像的所有层和配置
+3.将容器 upper 目录打包成层（overlay 的 whiteout 转换为 .wh. 文件），gzip 压缩后写入镜像仓库
+4.在父镜像配置的基础上应用标签和 --change，追加新层的 diff_id 和构建记录，再写入 manifest 并打上标签
+*/
+func commitContainer(containerIDOrName, imageName string, opts *commitOptions) error {
 	// 首先尝试通过容器名称获取容器ID
 	containerID := containerIDOrName
 	containerInfo, err := container.GetContainerInfoByName(containerIDOrName)
 	if err != nil {
 		// 如果通过名称查找失败，假设输入的是容器ID，直接使用
 		log.Infof("Container name '%s' not found, treating as container ID", containerIDOrName)
+		if containerInfo, err = getInfoByContainerId(containerID); err != nil {
+			return errors.WithMessagef(err, "container %s not found", containerIDOrName)
+		}
 	} else {
 		// 如果通过名称找到了容器，使用其ID
 		containerID = containerInfo.Id
 		log.Infof("Found container '%s' with ID: %s", containerIDOrName, containerID)
 	}
 
-	mntPath := utils.GetMerged(containerID)
-	imageTar := utils.GetImage(imageName)
-	exists, err := utils.PathExists(imageTar)
+	if _, err = image.Resolve(imageName); err == nil {
+		return ErrImageAlreadyExists
+	} else if !errors.Is(err, image.ErrImageNotFound) {
+		return errors.WithMessagef(err, "check is image %s exist failed", imageName)
+	}
+
+	parent, err := parentImage(containerInfo)
 	if err != nil {
-		return errors.WithMessagef(err, "check is image [%s/%s] exist failed", imageName, imageTar)
+		return err
 	}
-	if exists {
-		return ErrImageAlreadyExists
+	imageConfig, err := container.ImageConfigOf(parent)
+	if err != nil {
+		return err
 	}
-	log.Infof("commitContainer imageTar:%s", imageTar)
-	if _, err = exec.Command("tar", "-czf", imageTar, "-C", mntPath, ".").CombinedOutput(); err != nil {
-		return errors.WithMessagef(err, "tar folder %s failed", mntPath)
+	for key, value := range opts.Labels {
+		if imageConfig.Labels == nil {
+			imageConfig.Labels = make(map[string]string)
+		}
+		imageConfig.Labels[key] = value
 	}
+	for _, change := range opts.Changes {
+		if err = imageConfig.ApplyChange(change); err != nil {
+			return errors.WithMessagef(err, "apply change %q failed", change)
+		}
+	}
+
+	layer, diffID, err := writeContainerLayer(containerInfo, opts.Pause)
+	if err != nil {
+		return err
+	}
+
+	configFile := newCommitConfig(parent, containerID, opts)
+	if configFile.Config, err = json.Marshal(imageConfig); err != nil {
+		return errors.WithMessage(err, "image config marshal failed")
+	}
+	configFile.RootFS.DiffIDs = append(configFile.RootFS.DiffIDs, diffID)
+	layers := append(append([]image.Descriptor{}, parent.Manifest.Layers...), layer)
+	img, err := image.WriteImage(configFile, layers, imageName)
+	if err != nil {
+		return err
+	}
+	log.Infof("Committed container %s to image %s", containerID, img.ID())
 	return nil
-}
\ No newline at end of file
+}
+
+// parentImage 返回容器使用的镜像，优先按镜像ID查找，镜像的 tag 被移走后仍能找到
+func parentImage(info *container.Info) (*image.Image, error) {
+	if info.ImageId != "" {
+		if digest, err := image.Resolve(info.ImageId); err == nil {
+			return image.GetByDigest(digest)
+		}
+	}
+	if info.Image == "" {
+		return nil, errors.Errorf("image of container %s is unknown", info.Id)
+	}
+	img, err := image.Get(info.Image)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "get image %s of container %s failed", info.Image, info.Id)
+	}
+	if info.ImageId != "" && img.ID() != info.ImageId {
+		return nil, errors.Errorf("image %s of container %s has been removed", info.ImageId, info.Id)
+	}
+	return img, nil
+}
+
+// writeContainerLayer 将容器的 upper 目录打包为层写入镜像仓库，pause 为 true 时打包期间暂停运行中的容器
+func writeContainerLayer(info *container.Info, pause bool) (image.Descriptor, string, error) {
+	if pause && info.Status == container.RUNNING {
+		pid, err := strconv.Atoi(info.Pid)
+		if err != nil {
+			return image.Descriptor{}, "", errors.WithMessagef(err, "invalid pid %s", info.Pid)
+		}
+		resume, err := container.Pause(pid)
+		if err != nil {
+			return image.Descriptor{}, "", err
+		}
+		defer resume()
+	}
+	upperDir := utils.GetUpper(info.Id)
+	log.Infof("commitContainer %s", upperDir)
+	return writeDiffLayer(upperDir)
+}
+
+// writeDiffLayer 将 upper 目录打包为层，gzip 压缩后写入镜像仓库，返回层的描述和 diff_id
+func writeDiffLayer(upperDir string) (image.Descriptor, string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(image.WriteDiff(pw, upperDir))
+	}()
+	layer, diffID, err := image.WriteLayer(pr)
+	pr.CloseWithError(err)
+	return layer, diffID, err
+}
+
+// newCommitConfig 以父镜像的配置为基础生成新镜像的配置，追加本次提交的构建记录
+func newCommitConfig(parent *image.Image, containerID string, opts *commitOptions) *image.ConfigFile {
+	configFile := image.NewConfigFile()
+	configFile.Author = opts.Author
+	if parent.Config.Architecture != "" {
+		configFile.Architecture, configFile.OS = parent.Config.Architecture, parent.Config.OS
+	}
+	configFile.RootFS.DiffIDs = append([]string{}, parent.Config.RootFS.DiffIDs...)
+	history := append([]image.History{}, parent.Config.History...)
+	if len(history) == 0 {
+		// 父镜像没有构建记录时为每一层补一条空记录，保持构建记录与层一一对应
+		history = make([]image.History, len(parent.Manifest.Layers))
+	}
+	configFile.History = append(history, image.History{
+		Created:   configFile.Created,
+		CreatedBy: "mycontainer commit " + containerID,
+		Author:    opts.Author,
+		Comment:   opts.Message,
+	})
+	return configFile
+}
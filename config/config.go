@@ -16,9 +16,13 @@ var (
 	ImagePath  string
 	RootPath   string
 	ImagesPath string // 用于存储容器镜像的路径
+	LayersPath string // 镜像层目录，每一层只解压一次，按 digest 存放，供所有容器共享
 	
 	// 容器信息存储路径
 	ContainerInfoPath string
+
+	// registry 登录信息，login 写入，pull 和 push 时读取
+	AuthFile string
 )
 
 // Init 初始化配置
@@ -38,6 +42,8 @@ func Init() error {
 	ImagePath = filepath.Join(RootDir, "images") + "/"
 	RootPath = filepath.Join(RootDir, "overlay2") + "/"
 	ImagesPath = filepath.Join(RootDir, "images") + "/"  // 使用与ImagePath相同的路径
+	LayersPath = filepath.Join(RootDir, "layers") + "/"
+	AuthFile = filepath.Join(RootDir, "auth.json")
 
 	// 创建必要的目录
 	if err := ensureDirectories(); err != nil {
@@ -67,6 +73,12 @@ func ensureDirectories() error {
 		return err
 	}
 
-	log.Infof("Container directories created successfully: %s, %s, %s", ImagePath, RootPath, ImagesPath)
+	// 创建镜像层目录
+	if err := os.MkdirAll(LayersPath, 0755); err != nil {
+		log.Errorf("Failed to create layers directory: %v", err)
+		return err
+	}
+
+	log.Infof("Container directories created successfully: %s, %s, %s, %s", ImagePath, RootPath, ImagesPath, LayersPath)
 	return nil
 }
\ No newline at end of file
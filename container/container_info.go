@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"math/rand"
 	"github.com/aspirshar/myContainer/constant"
+	"github.com/aspirshar/myContainer/image"
+	"github.com/aspirshar/myContainer/logger"
 	"os"
 	"path"
 	"strconv"
@@ -14,16 +16,18 @@ import (
 	"github.com/pkg/errors"
 )
 
-func RecordContainerInfo(containerPID int, commandArray []string, containerName, containerId, volume, networkName, ip string, portMapping []string) (*Info, error) {
+func RecordContainerInfo(containerPID int, initConf *InitConfig, containerName, containerId, imageName, volume, networkName, ip string, portMapping []string,
+	health *HealthConfig, labels map[string]string, imageConfig *ImageConfig, logConfig *logger.Config) (*Info, error) {
 	// 如果未指定容器名，则使用随机生成的containerID
 	if containerName == "" {
 		containerName = containerId
 	}
-	command := strings.Join(commandArray, "")
+	command := strings.Join(initConf.Args, "")
 	containerInfo := &Info{
 		Pid:         strconv.Itoa(containerPID),
 		Id:          containerId,
 		Name:        containerName,
+		Image:       imageName,
 		Command:     command,
 		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
 		Status:      RUNNING,
@@ -31,29 +35,47 @@ func RecordContainerInfo(containerPID int, commandArray []string, containerName,
 		NetworkName: networkName,
 		PortMapping: portMapping,
 		IP:          ip,
+		Labels:      labels,
+		User:        initConf.User,
+		WorkingDir:  initConf.Cwd,
+		Mounts:      initConf.Mounts,
+		LogConfig:   logConfig,
 	}
+	if img, err := image.Get(imageName); err == nil {
+		containerInfo.ImageId = img.ID()
+	}
+	if imageConfig != nil {
+		containerInfo.ExposedPorts = imageConfig.SortedExposedPorts()
+		containerInfo.StopSignal = imageConfig.StopSignal
+	}
+	// 配置了健康检查的容器，在第一次探测成功之前处于 starting 状态
+	if !health.Disabled() {
+		containerInfo.Health = health
+		containerInfo.HealthState = &Health{Status: HealthStarting}
+	}
+
+	return containerInfo, SaveContainerInfo(containerInfo)
+}
 
+// SaveContainerInfo 将容器信息写入容器目录下的 config.json
+func SaveContainerInfo(containerInfo *Info) error {
 	jsonBytes, err := json.Marshal(containerInfo)
 	if err != nil {
-		return containerInfo, errors.WithMessage(err, "container info marshal failed")
+		return errors.WithMessage(err, "container info marshal failed")
 	}
 	jsonStr := string(jsonBytes)
 	// 拼接出存储容器信息文件的路径，如果目录不存在则级联创建
-	dirPath := fmt.Sprintf(InfoLocFormat, containerId)
+	dirPath := fmt.Sprintf(InfoLocFormat, containerInfo.Id)
 	if err = os.MkdirAll(dirPath, constant.Perm0622); err != nil {
-		return containerInfo, errors.WithMessagef(err, "mkdir %s failed", dirPath)
+		return errors.WithMessagef(err, "mkdir %s failed", dirPath)
 	}
-	// 将容器信息写入文件
+	// 将容器信息写入文件，先写临时文件再 rename，读取方不会读到写了一半的文件
 	fileName := path.Join(dirPath, ConfigName)
-	file, err := os.Create(fileName)
-	if err != nil {
-		return containerInfo, errors.WithMessagef(err, "create file %s failed", fileName)
-	}
-	defer file.Close()
-	if _, err = file.WriteString(jsonStr); err != nil {
-		return containerInfo, errors.WithMessagef(err, "write container info to  file %s failed", fileName)
+	tmpName := fileName + ".tmp"
+	if err = os.WriteFile(tmpName, []byte(jsonStr), constant.Perm0622); err != nil {
+		return errors.WithMessagef(err, "write container info to  file %s failed", tmpName)
 	}
-	return containerInfo, nil
+	return errors.WithMessagef(os.Rename(tmpName, fileName), "rename %s failed", tmpName)
 }
 
 // GetContainerInfoByName 通过容器名称获取容器信息
@@ -82,6 +104,7 @@ func GetContainerInfoByName(containerName string) (*Info, error) {
 		
 		// 如果找到匹配的容器名称，返回容器信息
 		if info.Name == containerName {
+			LoadHealthState(info)
 			return info, nil
 		}
 	}
@@ -121,4 +144,27 @@ func randStringBytes(n int) string {
 
 func GetLogfile(containerId string) string {
 	return fmt.Sprintf(LogFile, containerId)
-}
\ No newline at end of file
+}
+
+// GetLogPath 返回容器日志文件的完整路径
+func GetLogPath(containerId string) string {
+	return fmt.Sprintf(InfoLocFormat, containerId) + GetLogfile(containerId)
+}
+
+// NewLogInfo 返回日志驱动需要的容器信息
+func NewLogInfo(containerId, containerName, imageName string, labels map[string]string, logConfig *logger.Config) *logger.Info {
+	if containerName == "" {
+		containerName = containerId
+	}
+	logInfo := &logger.Info{
+		ContainerID:   containerId,
+		ContainerName: containerName,
+		ImageName:     imageName,
+		Labels:        labels,
+		LogPath:       GetLogPath(containerId),
+	}
+	if logConfig != nil {
+		logInfo.Config = *logConfig
+	}
+	return logInfo
+}
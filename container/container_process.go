@@ -1,13 +1,17 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/aspirshar/myContainer/constant"
+	"github.com/aspirshar/myContainer/logger"
 	"github.com/aspirshar/myContainer/utils"
+	"io"
 	"os"
 	"os/exec"
 	"syscall"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -23,19 +27,30 @@ const (
 )
 
 type Info struct {
-	Pid         string   `json:"pid"`         // 容器的init进程在宿主机上的 PID
-	Id          string   `json:"id"`          // 容器Id
-	Name        string   `json:"name"`        // 容器名
-	Command     string   `json:"command"`     // 容器内init运行命令
-	CreatedTime string   `json:"createTime"`  // 创建时间
-	Status      string   `json:"status"`      // 容器的状态
-	Volume      string   `json:"volume"`      // 容器挂载的 volume
-	NetworkName string   `json:"networkName"` // 容器所在的网络
-	PortMapping []string `json:"portmapping"` // 端口映射
-	IP          string   `json:"ip"`
+	Pid          string            `json:"pid"`         // 容器的init进程在宿主机上的 PID
+	Id           string            `json:"id"`          // 容器Id
+	Name         string            `json:"name"`        // 容器名
+	Command      string            `json:"command"`     // 容器内init运行命令
+	CreatedTime  string            `json:"createTime"`  // 创建时间
+	Status       string            `json:"status"`      // 容器的状态
+	Volume       string            `json:"volume"`      // 容器挂载的 volume
+	NetworkName  string            `json:"networkName"` // 容器所在的网络
+	PortMapping  []string          `json:"portmapping"` // 端口映射
+	IP           string            `json:"ip"`
+	Health       *HealthConfig     `json:"healthConfig,omitempty"` // 健康检查配置
+	HealthState  *Health           `json:"health,omitempty"`       // 健康检查状态
+	Labels       map[string]string `json:"labels,omitempty"`       // 容器标签
+	User         string            `json:"user,omitempty"`         // 容器进程的用户，exec 时默认使用
+	WorkingDir   string            `json:"workingDir,omitempty"`   // 容器进程的工作目录，exec 时默认使用
+	Mounts       []Mount           `json:"mounts,omitempty"`       // 镜像声明的匿名数据卷
+	ExposedPorts []string          `json:"exposedPorts,omitempty"` // 镜像暴露的端口
+	StopSignal   string            `json:"stopSignal,omitempty"`   // stop 时发送的信号，默认为 SIGTERM
+	LogConfig    *logger.Config    `json:"logConfig,omitempty"`    // 日志配置
+	Image        string            `json:"image,omitempty"`        // 容器使用的镜像
+	ImageId      string            `json:"imageId,omitempty"`      // 容器使用的镜像ID，镜像的 tag 改变后仍能找到容器使用的镜像
 }
 
-func NewParentProcess(tty bool, volume, containerId, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty bool, volume, containerId, imageName string, envSlice []string, logInfo *logger.Info) (*exec.Cmd, *os.File) {
 	readPipe, writePipe, err := os.Pipe()
 	if err != nil {
 		log.Errorf("New pipe error %v", err)
@@ -49,26 +64,94 @@ func NewParentProcess(tty bool, volume, containerId, imageName string, envSlice
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
-	} else {
-		// 对于后台运行容器，将 stdout、stderr 重定向到日志文件中，便于后续查看
+	} else if logInfo.Config.DriverName() != logger.NoneDriver {
+		// 对于后台运行容器，stdout、stderr 分别通过管道交给后台的日志进程，由它交给日志驱动处理
+		// 使用 none 日志驱动时不启动日志进程，输出直接丢弃
 		dirPath := fmt.Sprintf(InfoLocFormat, containerId)
 		if err = os.MkdirAll(dirPath, constant.Perm0622); err != nil {
 			log.Errorf("NewParentProcess mkdir %s error %v", dirPath, err)
 			return nil, nil
 		}
-		stdLogFilePath := dirPath + GetLogfile(containerId)
-		stdLogFile, err := os.Create(stdLogFilePath)
+		stdout, stderr, err := startLogger(logInfo)
 		if err != nil {
-			log.Errorf("NewParentProcess create file %s error %v", stdLogFilePath, err)
+			log.Errorf("NewParentProcess start logger error %v", err)
 			return nil, nil
 		}
-		cmd.Stdout = stdLogFile
-		cmd.Stderr = stdLogFile
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
 	}
-	cmd.Env = append(os.Environ(), envSlice...)
-	cmd.Env = append(cmd.Env, "MYCONTAINER_ROOT="+os.Getenv("MYCONTAINER_ROOT"))
+	// 容器只使用显式指定的环境变量，不继承宿主机的环境变量
+	cmd.Env = envSlice
 	cmd.ExtraFiles = []*os.File{readPipe}
-	NewWorkSpace(containerId, imageName, volume)
+	if err = NewWorkSpace(containerId, imageName, volume); err != nil {
+		log.Errorf("NewParentProcess new workspace error %v", err)
+		return nil, nil
+	}
 	cmd.Dir = utils.GetMerged(containerId)
 	return cmd, writePipe
-}
\ No newline at end of file
+}
+
+// LoggerReady 日志进程创建日志驱动成功后通过 fd 6 返回的内容，失败时返回错误信息
+const LoggerReady = "ready"
+
+// startLogger 启动后台日志进程，返回容器 stdout、stderr 使用的管道写端
+/*
+日志进程通过 fd 3、fd 4 读取容器的 stdout、stderr，通过 fd 5 读取 JSON 格式的日志配置，
+创建日志驱动后通过 fd 6 返回结果，syslog 连接失败等错误在启动容器之前就能发现，
+容器内所有进程都退出、管道写端全部关闭后，日志进程读到 EOF 自动退出
+*/
+func startLogger(logInfo *logger.Info) (*os.File, *os.File, error) {
+	stdoutRead, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "new stdout pipe failed")
+	}
+	stderrRead, stderrWrite, err := os.Pipe()
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "new stderr pipe failed")
+	}
+	configRead, configWrite, err := os.Pipe()
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "new config pipe failed")
+	}
+	defer configWrite.Close()
+	statusRead, statusWrite, err := os.Pipe()
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "new status pipe failed")
+	}
+	defer statusRead.Close()
+	cmd := exec.Command("/proc/self/exe", "logger")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.ExtraFiles = []*os.File{stdoutRead, stderrRead, configRead, statusWrite}
+	err = cmd.Start()
+	// 读端和 status 的写端已经交给日志进程，当前进程不再需要
+	stdoutRead.Close()
+	stderrRead.Close()
+	configRead.Close()
+	statusWrite.Close()
+	if err != nil {
+		stdoutWrite.Close()
+		stderrWrite.Close()
+		return nil, nil, errors.WithMessage(err, "start logger failed")
+	}
+	// 不等待后台进程，退出后由 init 进程回收
+	_ = cmd.Process.Release()
+	if err = json.NewEncoder(configWrite).Encode(logInfo); err != nil {
+		stdoutWrite.Close()
+		stderrWrite.Close()
+		return nil, nil, errors.WithMessage(err, "send log config failed")
+	}
+	// 等待日志进程创建日志驱动，日志进程异常退出时读到 EOF
+	status, err := io.ReadAll(statusRead)
+	if err == nil && string(status) != LoggerReady {
+		if len(status) == 0 {
+			status = []byte("logger exited")
+		}
+		err = errors.New(string(status))
+	}
+	if err != nil {
+		stdoutWrite.Close()
+		stderrWrite.Close()
+		return nil, nil, errors.WithMessage(err, "create log driver failed")
+	}
+	return stdoutWrite, stderrWrite, nil
+}
@@ -0,0 +1,17 @@
+package container
+
+// DefaultPath 容器内默认的 PATH 环境变量
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// DefaultEnv 容器的默认环境变量，不再继承宿主机的环境变量，避免泄露宿主机上的凭证等敏感信息
+// HOME 需要根据容器内的 /etc/passwd 解析，由 init 进程在切换用户前设置，exec 时按 exec 用户重新解析
+func DefaultEnv(hostname string, tty bool) []string {
+	envs := []string{
+		"PATH=" + DefaultPath,
+		"HOSTNAME=" + hostname,
+	}
+	if tty {
+		envs = append(envs, "TERM=xterm")
+	}
+	return envs
+}
@@ -0,0 +1,49 @@
+package container
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aspirshar/myContainer/constant"
+	"github.com/pkg/errors"
+)
+
+// EventsFile 事件日志文件，每行一个 JSON 格式的事件
+var EventsFile = path.Join(path.Dir(path.Clean(InfoLoc)), "events.log")
+
+// Event 容器事件
+type Event struct {
+	Time   string `json:"time"`
+	Type   string `json:"type"`   // 事件对象类型，比如 container
+	Action string `json:"action"` // 事件内容，比如 health_status: healthy
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+}
+
+// RecordEvent 向事件日志中追加一条容器事件
+func RecordEvent(info *Info, action string) error {
+	event := Event{
+		Time:   time.Now().Format(time.RFC3339Nano),
+		Type:   "container",
+		Action: action,
+		ID:     info.Id,
+		Name:   info.Name,
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return errors.WithMessage(err, "event marshal failed")
+	}
+	if err = os.MkdirAll(path.Dir(EventsFile), constant.Perm0755); err != nil {
+		return errors.WithMessagef(err, "mkdir %s failed", path.Dir(EventsFile))
+	}
+	file, err := os.OpenFile(EventsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, constant.Perm0644)
+	if err != nil {
+		return errors.WithMessagef(err, "open %s failed", EventsFile)
+	}
+	defer file.Close()
+	// O_APPEND 保证多个进程同时写入时每行事件不会交错
+	_, err = file.Write(append(line, '\n'))
+	return errors.WithMessagef(err, "write %s failed", EventsFile)
+}
@@ -0,0 +1,104 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aspirshar/myContainer/constant"
+	"github.com/pkg/errors"
+)
+
+// ExecSessionDir 容器信息目录下保存 exec 会话的子目录，每个会话一个 JSON 文件
+// 会话单独保存而不是写进 config.json，避免与健康检查等后台进程同时改写容器信息
+const ExecSessionDir = "exec"
+
+// ExecSession 一次 exec 的记录
+type ExecSession struct {
+	ID         string   `json:"id"`
+	Command    []string `json:"command"`
+	User       string   `json:"user,omitempty"`
+	WorkingDir string   `json:"workingDir,omitempty"`
+	GroupAdd   []string `json:"groupAdd,omitempty"`
+	Env        []string `json:"env,omitempty"` // 通过 -e 额外指定的环境变量
+	Tty        bool     `json:"tty"`
+	Detach     bool     `json:"detach"`
+	Pid        int      `json:"pid,omitempty"` // 宿主机上进入容器执行命令的进程 PID，它会把收到的信号转发给命令
+	Running    bool     `json:"running"`
+	ExitCode   *int     `json:"exitCode,omitempty"` // 命令结束后才有退出码
+	StartedAt  string   `json:"startedAt,omitempty"`
+	FinishedAt string   `json:"finishedAt,omitempty"`
+}
+
+// GenerateExecID 生成 exec 会话ID
+func GenerateExecID() string {
+	return randStringBytes(IDLength)
+}
+
+func execSessionPath(containerId, execId string) string {
+	return path.Join(fmt.Sprintf(InfoLocFormat, containerId), ExecSessionDir, execId+".json")
+}
+
+// SaveExecSession 保存 exec 会话，先写临时文件再 rename，读取方不会读到写了一半的文件
+func SaveExecSession(containerId string, session *ExecSession) error {
+	sessionPath := execSessionPath(containerId, session.ID)
+	if err := os.MkdirAll(path.Dir(sessionPath), constant.Perm0755); err != nil {
+		return errors.WithMessagef(err, "mkdir %s failed", path.Dir(sessionPath))
+	}
+	content, err := json.Marshal(session)
+	if err != nil {
+		return errors.WithMessage(err, "exec session marshal failed")
+	}
+	tmpPath := sessionPath + ".tmp"
+	if err = os.WriteFile(tmpPath, content, constant.Perm0644); err != nil {
+		return errors.WithMessagef(err, "write %s failed", tmpPath)
+	}
+	return errors.WithMessagef(os.Rename(tmpPath, sessionPath), "rename %s failed", tmpPath)
+}
+
+// GetExecSession 读取指定的 exec 会话
+func GetExecSession(containerId, execId string) (*ExecSession, error) {
+	sessionPath := execSessionPath(containerId, execId)
+	content, err := os.ReadFile(sessionPath)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read %s failed", sessionPath)
+	}
+	session := new(ExecSession)
+	if err = json.Unmarshal(content, session); err != nil {
+		return nil, errors.WithMessagef(err, "parse %s failed", sessionPath)
+	}
+	return session, nil
+}
+
+// ListExecSessions 按开始时间列出容器的所有 exec 会话
+func ListExecSessions(containerId string) ([]*ExecSession, error) {
+	dir := path.Join(fmt.Sprintf(InfoLocFormat, containerId), ExecSessionDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithMessagef(err, "read dir %s failed", dir)
+	}
+	sessions := make([]*ExecSession, 0, len(files))
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		session, err := GetExecSession(containerId, strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			continue // 跳过无法读取的会话
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339Nano, sessions[i].StartedAt)
+		tj, _ := time.Parse(time.RFC3339Nano, sessions[j].StartedAt)
+		return ti.Before(tj)
+	})
+	return sessions, nil
+}
@@ -0,0 +1,143 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aspirshar/myContainer/constant"
+	"github.com/pkg/errors"
+)
+
+// 容器健康状态
+const (
+	HealthNone      = ""
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+
+	// MaxHealthLogEntries 保留的最近探测结果条数
+	MaxHealthLogEntries = 5
+	// maxHealthOutput 每次探测保留的输出长度上限
+	maxHealthOutput = 4096
+	// HealthStateName 健康状态单独保存在容器目录下的文件中，健康检查进程不需要改写 config.json
+	HealthStateName = "health.json"
+)
+
+// 健康检查的默认参数，与 docker 保持一致
+const (
+	DefaultHealthInterval = 30 * time.Second
+	DefaultHealthTimeout  = 30 * time.Second
+	DefaultHealthRetries  = 3
+)
+
+// HealthConfig 健康检查配置
+/*
+Test 的格式与镜像配置中的 Healthcheck.Test 一致：
+[]              继承镜像配置
+["NONE"]        禁用健康检查
+["CMD", args...]      直接执行命令
+["CMD-SHELL", command] 通过 /bin/sh -c 执行命令
+*/
+type HealthConfig struct {
+	Test        []string      `json:"test"`
+	Interval    time.Duration `json:"interval"`
+	Timeout     time.Duration `json:"timeout"`
+	StartPeriod time.Duration `json:"startPeriod"`
+	Retries     int           `json:"retries"`
+}
+
+// HealthResult 单次探测的结果
+type HealthResult struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exitCode"`
+	Output   string    `json:"output"`
+}
+
+// Health 容器当前的健康状态
+type Health struct {
+	Status        string         `json:"status"`
+	FailingStreak int            `json:"failingStreak"`
+	Log           []HealthResult `json:"log"`
+}
+
+// Disabled 判断健康检查是否被禁用
+func (c *HealthConfig) Disabled() bool {
+	return c == nil || len(c.Test) == 0 || c.Test[0] == "NONE"
+}
+
+// SetDefaults 为未设置的参数填充默认值
+func (c *HealthConfig) SetDefaults() {
+	if c.Interval <= 0 {
+		c.Interval = DefaultHealthInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultHealthTimeout
+	}
+	if c.Retries <= 0 {
+		c.Retries = DefaultHealthRetries
+	}
+}
+
+// Update 根据一次探测结果更新健康状态，返回状态是否发生了变化
+/*
+1.探测成功则状态置为 healthy，并清空连续失败次数
+2.探测失败时，如果仍处于启动等待期，则不计入失败次数
+3.连续失败次数达到 retries 后状态置为 unhealthy
+*/
+func (h *Health) Update(result HealthResult, config *HealthConfig, inStartPeriod bool) bool {
+	if len(result.Output) > maxHealthOutput {
+		// 退回到字符的起始字节再截断，不会截断多字节的 UTF-8 字符
+		end := maxHealthOutput
+		for end > 0 && !utf8.RuneStart(result.Output[end]) {
+			end--
+		}
+		result.Output = result.Output[:end]
+	}
+	h.Log = append(h.Log, result)
+	if len(h.Log) > MaxHealthLogEntries {
+		h.Log = h.Log[len(h.Log)-MaxHealthLogEntries:]
+	}
+
+	oldStatus := h.Status
+	if result.ExitCode == 0 {
+		h.FailingStreak = 0
+		h.Status = HealthHealthy
+	} else if !inStartPeriod {
+		h.FailingStreak++
+		if h.FailingStreak >= config.Retries {
+			h.Status = HealthUnhealthy
+		}
+	}
+	return oldStatus != h.Status
+}
+
+// SaveHealthState 保存容器的健康状态，先写临时文件再 rename，读取方不会读到写了一半的文件
+func SaveHealthState(containerId string, health *Health) error {
+	content, err := json.Marshal(health)
+	if err != nil {
+		return errors.WithMessage(err, "health state marshal failed")
+	}
+	statePath := path.Join(fmt.Sprintf(InfoLocFormat, containerId), HealthStateName)
+	tmpPath := statePath + ".tmp"
+	if err = os.WriteFile(tmpPath, content, constant.Perm0644); err != nil {
+		return errors.WithMessagef(err, "write %s failed", tmpPath)
+	}
+	return errors.WithMessagef(os.Rename(tmpPath, statePath), "rename %s failed", tmpPath)
+}
+
+// LoadHealthState 用健康检查进程保存的健康状态替换容器信息中的初始状态，还没有探测结果时保持不变
+func LoadHealthState(info *Info) {
+	content, err := os.ReadFile(path.Join(fmt.Sprintf(InfoLocFormat, info.Id), HealthStateName))
+	if err != nil {
+		return
+	}
+	health := new(Health)
+	if err = json.Unmarshal(content, health); err == nil {
+		info.HealthState = health
+	}
+}
@@ -0,0 +1,55 @@
+package container
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestHealthUpdate(t *testing.T) {
+	config := &HealthConfig{Retries: 2}
+	health := &Health{Status: HealthStarting}
+
+	// 启动等待期内的失败不计入连续失败次数
+	health.Update(HealthResult{ExitCode: 1}, config, true)
+	if health.Status != HealthStarting || health.FailingStreak != 0 {
+		t.Fatalf("failure in start period should be ignored, got %+v", health)
+	}
+
+	if changed := health.Update(HealthResult{ExitCode: 0}, config, true); !changed || health.Status != HealthHealthy {
+		t.Fatalf("expect healthy, got %+v", health)
+	}
+
+	health.Update(HealthResult{ExitCode: 1}, config, false)
+	if health.Status != HealthHealthy || health.FailingStreak != 1 {
+		t.Fatalf("expect still healthy with 1 failure, got %+v", health)
+	}
+	if changed := health.Update(HealthResult{ExitCode: 1}, config, false); !changed || health.Status != HealthUnhealthy {
+		t.Fatalf("expect unhealthy, got %+v", health)
+	}
+}
+
+func TestHealthOutputLimit(t *testing.T) {
+	config := &HealthConfig{Retries: 3}
+	health := &Health{}
+	// 第 maxHealthOutput 个字节落在 3 字节的字符中间
+	health.Update(HealthResult{Output: "ab" + strings.Repeat("健", maxHealthOutput)}, config, false)
+	output := health.Log[0].Output
+	if !utf8.ValidString(output) || len(output) != maxHealthOutput-2 {
+		t.Fatalf("expect output truncated at a rune boundary, got %d bytes", len(output))
+	}
+}
+
+func TestHealthLogLimit(t *testing.T) {
+	config := &HealthConfig{Retries: 3}
+	health := &Health{}
+	for i := 0; i < MaxHealthLogEntries+3; i++ {
+		health.Update(HealthResult{ExitCode: i}, config, false)
+	}
+	if len(health.Log) != MaxHealthLogEntries {
+		t.Fatalf("expect %d log entries, got %d", MaxHealthLogEntries, len(health.Log))
+	}
+	if health.Log[0].ExitCode != 3 {
+		t.Fatalf("expect oldest entries dropped, got first exit code %d", health.Log[0].ExitCode)
+	}
+}
@@ -0,0 +1,177 @@
+package container
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ApplyChange 按 Dockerfile 指令的语法修改镜像配置，e.g. CMD ["sh"]、ENV A=1、EXPOSE 80
+/*
+支持 CMD、ENTRYPOINT、ENV、LABEL、EXPOSE、VOLUME、USER、WORKDIR、STOPSIGNAL，
+CMD、ENTRYPOINT 的 shell 形式转换为 /bin/sh -c 执行，与 docker commit --change 一致
+*/
+func (c *ImageConfig) ApplyChange(change string) error {
+	instruction, args, _ := strings.Cut(strings.TrimSpace(change), " ")
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return errors.Errorf("%s requires at least one argument", strings.ToUpper(instruction))
+	}
+	switch strings.ToUpper(instruction) {
+	case "CMD":
+		cmd, err := parseCommandArgs(args)
+		if err != nil {
+			return err
+		}
+		c.Cmd = cmd
+	case "ENTRYPOINT":
+		entrypoint, err := parseCommandArgs(args)
+		if err != nil {
+			return err
+		}
+		c.Entrypoint = entrypoint
+		// 与 docker 一致，修改 ENTRYPOINT 时清空原有的 CMD
+		c.Cmd = nil
+	case "ENV":
+		pairs, err := parseKeyValues(args)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			c.Env = setEnv(c.Env, pair[0], pair[1])
+		}
+	case "LABEL":
+		pairs, err := parseKeyValues(args)
+		if err != nil {
+			return err
+		}
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		for _, pair := range pairs {
+			c.Labels[pair[0]] = pair[1]
+		}
+	case "EXPOSE":
+		if c.ExposedPorts == nil {
+			c.ExposedPorts = make(map[string]struct{})
+		}
+		for _, port := range strings.Fields(args) {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			c.ExposedPorts[port] = struct{}{}
+		}
+	case "VOLUME":
+		volumes := strings.Fields(args)
+		if strings.HasPrefix(args, "[") {
+			if err := json.Unmarshal([]byte(args), &volumes); err != nil {
+				return errors.WithMessagef(err, "parse VOLUME %s failed", args)
+			}
+		}
+		if c.Volumes == nil {
+			c.Volumes = make(map[string]struct{})
+		}
+		for _, volume := range volumes {
+			c.Volumes[volume] = struct{}{}
+		}
+	case "USER":
+		c.User = args
+	case "WORKDIR":
+		c.WorkingDir = args
+	case "STOPSIGNAL":
+		if _, err := ParseSignal(args); err != nil {
+			return err
+		}
+		c.StopSignal = args
+	default:
+		return errors.Errorf("unsupported instruction %s", instruction)
+	}
+	return nil
+}
+
+// parseCommandArgs 解析 CMD、ENTRYPOINT 的参数，JSON 数组为 exec 形式，否则为 shell 形式
+func parseCommandArgs(args string) ([]string, error) {
+	if strings.HasPrefix(args, "[") {
+		var command []string
+		if err := json.Unmarshal([]byte(args), &command); err == nil {
+			return command, nil
+		}
+	}
+	return []string{"/bin/sh", "-c", args}, nil
+}
+
+// parseKeyValues 解析 ENV、LABEL 的参数，支持 key=value key2="value 2" 和旧的 key value 两种形式
+func parseKeyValues(args string) ([][2]string, error) {
+	words, err := splitWords(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) > 0 && !strings.Contains(words[0], "=") {
+		// key value 形式中第一个空格之后的内容都是值
+		key, value, _ := strings.Cut(args, " ")
+		return [][2]string{{key, strings.TrimSpace(value)}}, nil
+	}
+	pairs := make([][2]string, 0, len(words))
+	for _, word := range words {
+		key, value, ok := strings.Cut(word, "=")
+		if !ok || key == "" {
+			return nil, errors.Errorf("invalid key=value %s", word)
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, nil
+}
+
+// splitWords 按空白拆分参数，单引号、双引号中的空白不拆分，反斜杠转义下一个字符
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.Errorf("unterminated quote or escape in %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// setEnv 设置环境变量，已有的同名变量被替换
+func setEnv(env []string, key, value string) []string {
+	for i, kv := range env {
+		if k, _, _ := strings.Cut(kv, "="); k == key {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
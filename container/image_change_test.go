@@ -0,0 +1,54 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyChange(t *testing.T) {
+	c := &ImageConfig{Cmd: []string{"sh"}, Env: []string{"PATH=/bin", "A=1"}}
+	changes := []string{
+		`CMD ["/bin/app", "--port", "80"]`,
+		`ENV A=2 B="hello world"`,
+		`ENV C some value`,
+		`LABEL version=1.0 "maintainer"='a b'`,
+		`EXPOSE 80 53/udp`,
+		`VOLUME ["/data", "/logs"]`,
+		`WORKDIR /app`,
+		`USER www`,
+		`STOPSIGNAL SIGQUIT`,
+	}
+	for _, change := range changes {
+		if err := c.ApplyChange(change); err != nil {
+			t.Fatalf("apply %s: %v", change, err)
+		}
+	}
+	if !reflect.DeepEqual(c.Cmd, []string{"/bin/app", "--port", "80"}) {
+		t.Fatalf("unexpected cmd %v", c.Cmd)
+	}
+	if !reflect.DeepEqual(c.Env, []string{"PATH=/bin", "A=2", "B=hello world", "C=some value"}) {
+		t.Fatalf("unexpected env %v", c.Env)
+	}
+	if !reflect.DeepEqual(c.Labels, map[string]string{"version": "1.0", "maintainer": "a b"}) {
+		t.Fatalf("unexpected labels %v", c.Labels)
+	}
+	if !reflect.DeepEqual(c.SortedExposedPorts(), []string{"53/udp", "80/tcp"}) || !reflect.DeepEqual(c.SortedVolumes(), []string{"/data", "/logs"}) {
+		t.Fatalf("unexpected ports or volumes %+v", c)
+	}
+	if c.WorkingDir != "/app" || c.User != "www" || c.StopSignal != "SIGQUIT" {
+		t.Fatalf("unexpected config %+v", c)
+	}
+
+	// shell 形式的 ENTRYPOINT 清空 CMD
+	if err := c.ApplyChange("ENTRYPOINT exec app"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Entrypoint, []string{"/bin/sh", "-c", "exec app"}) || c.Cmd != nil {
+		t.Fatalf("unexpected entrypoint %v cmd %v", c.Entrypoint, c.Cmd)
+	}
+	for _, change := range []string{"RUN echo", "CMD", `ENV A="unterminated`, "STOPSIGNAL SIGNOPE"} {
+		if err := c.ApplyChange(change); err == nil {
+			t.Fatalf("expect error for %s", change)
+		}
+	}
+}
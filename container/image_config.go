@@ -0,0 +1,66 @@
+package container
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/aspirshar/myContainer/image"
+	"github.com/pkg/errors"
+)
+
+// ImageConfig 镜像配置文件（manifest.json 中 Config 指向的文件）中容器运行相关的部分
+type ImageConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Healthcheck  *HealthConfig       `json:"Healthcheck,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// LoadImageConfig 从镜像仓库读取镜像配置
+func LoadImageConfig(imageName string) (*ImageConfig, error) {
+	img, err := image.Get(imageName)
+	if err != nil {
+		return nil, err
+	}
+	return ImageConfigOf(img)
+}
+
+// ImageConfigOf 解析镜像配置中 config 字段的内容
+func ImageConfigOf(img *image.Image) (*ImageConfig, error) {
+	imageConfig := new(ImageConfig)
+	if len(img.Config.Config) == 0 {
+		return imageConfig, nil
+	}
+	if err := json.Unmarshal(img.Config.Config, imageConfig); err != nil {
+		return nil, errors.WithMessagef(err, "parse config of image %s failed", img.ID())
+	}
+	return imageConfig, nil
+}
+
+// SortedExposedPorts 返回排序后的镜像暴露端口列表，比如 80/tcp
+func (c *ImageConfig) SortedExposedPorts() []string {
+	return sortedKeys(c.ExposedPorts)
+}
+
+// SortedVolumes 返回排序后的镜像声明的数据卷路径列表
+func (c *ImageConfig) SortedVolumes() []string {
+	return sortedKeys(c.Volumes)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
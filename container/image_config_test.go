@@ -0,0 +1,71 @@
+package container
+
+import (
+	"archive/tar"
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/aspirshar/myContainer/config"
+)
+
+func TestLoadImageConfig(t *testing.T) {
+	config.ImagesPath = t.TempDir() + "/"
+	imageFile, err := os.Create(path.Join(config.ImagesPath, "test.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"abc.json", `{"config":{"Entrypoint":["/bin/app"],"Cmd":["--port","80"],"Env":["A=1"],` +
+			`"WorkingDir":"/app","User":"www","ExposedPorts":{"80/tcp":{}},"Volumes":{"/data":{}},"StopSignal":"SIGQUIT",` +
+			`"Healthcheck":{"Test":["CMD-SHELL","true"],"Interval":5000000000,"Retries":2}}}`},
+		{"manifest.json", `[{"Config":"abc.json","RepoTags":["test:latest"],"Layers":[]}]`},
+	}
+	tw := tar.NewWriter(imageFile)
+	for _, f := range files {
+		if err = tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	imageFile.Close()
+
+	imageConfig, err := LoadImageConfig("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imageConfig.Entrypoint, []string{"/bin/app"}) || !reflect.DeepEqual(imageConfig.Cmd, []string{"--port", "80"}) {
+		t.Fatalf("unexpected command %v %v", imageConfig.Entrypoint, imageConfig.Cmd)
+	}
+	if imageConfig.WorkingDir != "/app" || imageConfig.User != "www" || imageConfig.StopSignal != "SIGQUIT" {
+		t.Fatalf("unexpected config %+v", imageConfig)
+	}
+	if !reflect.DeepEqual(imageConfig.SortedExposedPorts(), []string{"80/tcp"}) || !reflect.DeepEqual(imageConfig.SortedVolumes(), []string{"/data"}) {
+		t.Fatalf("unexpected ports or volumes %+v", imageConfig)
+	}
+	if imageConfig.Healthcheck == nil || imageConfig.Healthcheck.Retries != 2 || imageConfig.Healthcheck.Interval.Seconds() != 5 {
+		t.Fatalf("unexpected healthcheck %+v", imageConfig.Healthcheck)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for _, s := range []string{"SIGQUIT", "quit", "3"} {
+		sig, err := ParseSignal(s)
+		if err != nil || sig != syscall.SIGQUIT {
+			t.Fatalf("parse %s expect SIGQUIT, got %v %v", s, sig, err)
+		}
+	}
+	if _, err := ParseSignal("SIGNOPE"); err == nil {
+		t.Fatal("expect error for invalid signal")
+	}
+}
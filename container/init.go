@@ -8,21 +8,51 @@ import (
 	"path/filepath"
 	"syscall"
 
+	"github.com/aspirshar/myContainer/constant"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
+// InitConfig 由父进程通过 pipe 发送给容器 init 进程的启动参数
+type InitConfig struct {
+	Args             []string `json:"args"`                       // 用户命令
+	Init             bool     `json:"init"`                       // 为 true 时 init 进程常驻 PID 1，负责回收僵尸进程并转发信号
+	Cwd              string   `json:"cwd,omitempty"`              // 用户命令的工作目录，不存在时自动创建
+	User             string   `json:"user,omitempty"`             // 用户，格式为 name|uid[:group|gid]
+	AdditionalGroups []string `json:"additionalGroups,omitempty"` // 附加组
+	Hostname         string   `json:"hostname,omitempty"`
+	Domainname       string   `json:"domainname,omitempty"`
+	Mounts           []Mount  `json:"mounts,omitempty"` // 在容器的 mount namespace 中 bind mount 的数据卷
+}
+
 func RunContainerInitProcess() error {
 	// 根据参数获取命令的完整路径 此时不需要再输入完整命令了
 
 	// 从 pipe 中读取命令
-	cmdArray := readUserCommand()
-	if len(cmdArray) == 0 {
+	initConf := readInitConfig()
+	if initConf == nil || len(initConf.Args) == 0 {
 		return errors.New("run container get user command error, cmdArray is nil")
 	}
+	cmdArray := initConf.Args
 
 	// 挂载文件系统
-	setUpMount()
+	setUpMount(initConf.Mounts)
+
+	// 设置主机名、工作目录，并根据容器内的 /etc/passwd 和 /etc/group 解析用户
+	if err := setUpProcess(initConf); err != nil {
+		log.Errorf("set up process error %v", err)
+		return err
+	}
+	execUser, err := ResolveUser("/", initConf.User, initConf.AdditionalGroups)
+	if err != nil {
+		log.Errorf("resolve user %s error %v", initConf.User, err)
+		return err
+	}
+	// 没有指定 HOME 时使用用户在容器内的 home 目录
+	if _, ok := os.LookupEnv("HOME"); !ok {
+		_ = os.Setenv("HOME", execUser.Home)
+	}
 
 	path, err := exec.LookPath(cmdArray[0])
 	if err != nil {
@@ -30,15 +60,56 @@ func RunContainerInitProcess() error {
 		return err
 	}
 	log.Infof("Find path %s", path)
+	// --init 模式下由当前进程继续作为 PID 1，fork 出用户进程
+	if initConf.Init {
+		return runAsInit(path, cmdArray, os.Environ(), execUser)
+	}
+	if err = switchUser(execUser); err != nil {
+		log.Errorf("switch user error %v", err)
+		return err
+	}
 	if err = syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
 		log.Errorf("RunContainerInitProcess exec :" + err.Error())
 	}
 	return nil
 }
 
+// setUpProcess 在执行用户命令前设置主机名、域名和工作目录
+func setUpProcess(initConf *InitConfig) error {
+	if initConf.Hostname != "" {
+		if err := unix.Sethostname([]byte(initConf.Hostname)); err != nil {
+			return errors.Wrapf(err, "set hostname %s", initConf.Hostname)
+		}
+	}
+	if initConf.Domainname != "" {
+		if err := unix.Setdomainname([]byte(initConf.Domainname)); err != nil {
+			return errors.Wrapf(err, "set domainname %s", initConf.Domainname)
+		}
+	}
+	if initConf.Cwd == "" {
+		return nil
+	}
+	cwd := filepath.Join("/", initConf.Cwd)
+	if err := os.MkdirAll(cwd, constant.Perm0755); err != nil {
+		return errors.Wrapf(err, "mkdir workdir %s", cwd)
+	}
+	return errors.Wrapf(syscall.Chdir(cwd), "chdir to workdir %s", cwd)
+}
+
+// switchUser 切换当前进程的附加组、组和用户，必须按照这个顺序，切换用户后就没有权限再修改组了
+func switchUser(execUser *ExecUser) error {
+	if err := syscall.Setgroups(execUser.Groups); err != nil {
+		return errors.Wrap(err, "setgroups")
+	}
+	if err := syscall.Setgid(execUser.Gid); err != nil {
+		return errors.Wrapf(err, "setgid %d", execUser.Gid)
+	}
+	return errors.Wrapf(syscall.Setuid(execUser.Uid), "setuid %d", execUser.Uid)
+}
+
 const fdIndex = 3
 
-func readUserCommand() []string {
+func readInitConfig() *InitConfig {
 	pipe := os.NewFile(uintptr(fdIndex), "pipe")
 	defer pipe.Close()
 	msg, err := io.ReadAll(pipe)
@@ -46,20 +117,20 @@ func readUserCommand() []string {
 		log.Errorf("init read pipe error %v", err)
 		return nil
 	}
-	var comArray []string
-	err = json.Unmarshal(msg, &comArray)
+	initConf := new(InitConfig)
+	err = json.Unmarshal(msg, initConf)
 	if err != nil {
 		log.Errorf("unmarshal command failed, err: %v", err)
 		return nil
 	}
-	return comArray
+	return initConf
 }
 
 /*
 *
 Init 挂载点
 */
-func setUpMount() {
+func setUpMount(mounts []Mount) {
 	pwd, err := os.Getwd()
 	if err != nil {
 		log.Errorf("Get current location error %v", err)
@@ -69,6 +140,13 @@ func setUpMount() {
 
 	err = syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
 
+	// 数据卷挂载在容器的 mount namespace 中，容器退出后自动消失，不需要在宿主机上卸载
+	for _, m := range mounts {
+		if err = bindMount(pwd, m); err != nil {
+			log.Errorf("mount volume %s to %s failed,detail: %v", m.Source, m.Destination, err)
+		}
+	}
+
 	err = pivotRoot(pwd)
 	if err != nil {
 		log.Errorf("pivotRoot failed,detail: %v", err)
@@ -0,0 +1,105 @@
+package container
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// pauseRetries 暂停期间仍可能有进程 fork 出新进程，重复扫描直到没有新的进程
+const pauseRetries = 10
+
+// Pause 向容器 PID namespace 中的所有进程发送 SIGSTOP，返回恢复这些进程的函数
+/*
+所有容器共用同一个 cgroup，不能用 cgroup freezer 只冻结一个容器，
+这里根据 /proc/<pid>/ns/pid 找到与容器 init 进程处于同一 PID namespace 的进程，
+暂停前已经处于停止状态的进程不发送信号，恢复时也不会让它们继续运行
+*/
+func Pause(pid int) (func(), error) {
+	nsPath := "/proc/" + strconv.Itoa(pid) + "/ns/pid"
+	namespace, err := os.Readlink(nsPath)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read pid namespace of %d failed", pid)
+	}
+	// 与当前进程处于同一 PID namespace 时会暂停宿主机上的所有进程
+	if self, _ := os.Readlink("/proc/self/ns/pid"); self == namespace {
+		return nil, errors.Errorf("process %d is not in a separate pid namespace", pid)
+	}
+	// seen 记录扫描过的进程，stopped 只包括由这里暂停的进程
+	seen := make(map[int]bool)
+	stopped := make(map[int]bool)
+	resume := func() {
+		for p := range stopped {
+			if err := syscall.Kill(p, syscall.SIGCONT); err != nil && err != syscall.ESRCH {
+				log.Errorf("resume process %d error %v", p, err)
+			}
+		}
+	}
+	for i := 0; i < pauseRetries; i++ {
+		pids, err := namespacePids(namespace)
+		if err != nil {
+			resume()
+			return nil, err
+		}
+		found := false
+		for _, p := range pids {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			found = true
+			if processState(p) == 'T' {
+				continue
+			}
+			if err = syscall.Kill(p, syscall.SIGSTOP); err != nil && err != syscall.ESRCH {
+				resume()
+				return nil, errors.WithMessagef(err, "pause process %d failed", p)
+			}
+			stopped[p] = true
+		}
+		if !found {
+			return resume, nil
+		}
+	}
+	resume()
+	return nil, errors.Errorf("processes of %d keep forking, pause failed", pid)
+}
+
+// processState 返回 /proc/<pid>/stat 中的进程状态，e.g. R、S、T，读取失败时返回 0
+func processState(pid int) byte {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0
+	}
+	// 进程名可能包含空格和括号，状态在最后一个右括号之后
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 || i+2 >= len(stat) {
+		return 0
+	}
+	return stat[i+2]
+}
+
+// namespacePids 返回处于指定 PID namespace 中的所有进程
+func namespacePids(namespace string) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, errors.WithMessage(err, "read /proc failed")
+	}
+	var pids []int
+	for _, entry := range entries {
+		p, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// 进程可能已经退出，读取失败时跳过
+		if ns, err := os.Readlink("/proc/" + entry.Name() + "/ns/pid"); err == nil && ns == namespace {
+			pids = append(pids, p)
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,36 @@
+package container
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProcessState(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	pid := cmd.Process.Pid
+	if state := processState(pid); state != 'S' && state != 'R' {
+		t.Fatalf("unexpected state %q of running process", state)
+	}
+	if err := syscall.Kill(pid, syscall.SIGSTOP); err != nil {
+		t.Fatal(err)
+	}
+	// 信号是异步处理的，等待进程进入停止状态
+	for i := 0; i < 100 && processState(pid) != 'T'; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := processState(pid); state != 'T' {
+		t.Fatalf("expect stopped process, got state %q", state)
+	}
+	if state := processState(-1); state != 0 {
+		t.Fatalf("expect 0 for missing process, got %q", state)
+	}
+}
@@ -0,0 +1,94 @@
+package container
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// runAsInit 以最小 init 的方式运行用户命令
+/*
+当前进程保持为容器内的 PID 1：
+1.fork 出用户进程
+2.回收所有退出的子进程，包括被托管到 PID 1 的孤儿进程，避免产生僵尸进程
+3.将收到的信号转发给用户进程
+4.用户进程退出后，以其退出码退出（被信号杀死时为 128+signal）
+*/
+func runAsInit(path string, args, env []string, execUser *ExecUser) error {
+	// 必须在 fork 之前注册，避免子进程过早退出导致 SIGCHLD 丢失
+	sigCh := make(chan os.Signal, 128)
+	signal.Notify(sigCh)
+
+	// init 进程保持 root 身份，只有用户进程切换用户
+	groups := make([]uint32, 0, len(execUser.Groups))
+	for _, gid := range execUser.Groups {
+		groups = append(groups, uint32(gid))
+	}
+	process, err := os.StartProcess(path, args, &os.ProcAttr{
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys: &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid:    uint32(execUser.Uid),
+				Gid:    uint32(execUser.Gid),
+				Groups: groups,
+			},
+		},
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "start %s failed", path)
+	}
+	childPid := process.Pid
+	log.Infof("init forked user process %d", childPid)
+
+	for sig := range sigCh {
+		sysSig, ok := sig.(syscall.Signal)
+		if !ok {
+			continue
+		}
+		switch sysSig {
+		case syscall.SIGCHLD:
+			if exitCode, exited := reapChildren(childPid); exited {
+				os.Exit(exitCode)
+			}
+		case syscall.SIGURG:
+			// Go 运行时用 SIGURG 做抢占调度，不属于外部发来的信号
+		default:
+			if err = syscall.Kill(childPid, sysSig); err != nil && err != syscall.ESRCH {
+				log.Errorf("forward signal %v to %d error %v", sysSig, childPid, err)
+			}
+		}
+	}
+	return nil
+}
+
+// reapChildren 回收所有已经退出的子进程，如果用户进程已退出则返回其退出码
+func reapChildren(childPid int) (exitCode int, exited bool) {
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if pid <= 0 || err != nil {
+			return exitCode, exited
+		}
+		if pid != childPid {
+			log.Debugf("reaped orphan process %d", pid)
+			continue
+		}
+		exited = true
+		exitCode = ExitCodeFromStatus(status)
+	}
+}
+
+// ExitCodeFromStatus 将进程的 wait 状态转换为 shell 风格的退出码，被信号杀死时为 128+signal
+func ExitCodeFromStatus(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
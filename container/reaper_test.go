@@ -0,0 +1,24 @@
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestExitCodeFromStatus(t *testing.T) {
+	cases := []struct {
+		status syscall.WaitStatus
+		expect int
+	}{
+		{0, 0},
+		{3 << 8, 3},
+		{255 << 8, 255},
+		{syscall.WaitStatus(syscall.SIGTERM), 143},
+		{syscall.WaitStatus(syscall.SIGKILL), 137},
+	}
+	for _, c := range cases {
+		if code := ExitCodeFromStatus(c.status); code != c.expect {
+			t.Fatalf("status %#x: expect exit code %d, got %d", uint32(c.status), c.expect, code)
+		}
+	}
+}
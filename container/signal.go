@@ -0,0 +1,29 @@
+package container
+
+import (
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// ParseSignal 解析信号，支持 SIGTERM、TERM 和 15 三种写法
+func ParseSignal(signal string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(signal); err == nil {
+		if num <= 0 || unix.SignalName(syscall.Signal(num)) == "" {
+			return 0, errors.Errorf("invalid signal: %s", signal)
+		}
+		return syscall.Signal(num), nil
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, errors.Errorf("invalid signal: %s", signal)
+	}
+	return sig, nil
+}
@@ -0,0 +1,187 @@
+package container
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aspirshar/myContainer/image"
+	"github.com/pkg/errors"
+)
+
+// ExecUser 解析后的用户身份，用于在执行用户命令前切换用户
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int  // 附加组
+	Home   string // 用户的 home 目录
+}
+
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// ResolveUser 根据容器内的 /etc/passwd 和 /etc/group 解析用户
+/*
+rootDir 为容器的根目录，在容器 init 进程中为 /，在宿主机上可以是 /proc/<pid>/root
+userSpec 的格式为 name|uid[:group|gid]，为空时为 root
+1.用户名必须能在 /etc/passwd 中找到，uid 可以不存在于 /etc/passwd 中
+2.未指定组时使用 /etc/passwd 中用户的主组，找不到时为 0
+3.附加组包括 /etc/group 中包含该用户的组以及 groupAdd 指定的组
+4./etc/passwd 和 /etc/group 中的符号链接以 rootDir 为根目录解析，不会读到宿主机上的文件
+*/
+func ResolveUser(rootDir, userSpec string, groupAdd []string) (*ExecUser, error) {
+	passwdPath, err := image.ResolveInRoot(rootDir, "etc/passwd", true)
+	if err != nil {
+		return nil, err
+	}
+	users, err := readPasswd(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	groupPath, err := image.ResolveInRoot(rootDir, "etc/group", true)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(groupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	userPart, groupPart, hasGroup := strings.Cut(userSpec, ":")
+	if userPart == "" {
+		userPart = "0"
+	}
+	execUser := &ExecUser{Home: "/"}
+	var matched *passwdEntry
+	if uid, err := strconv.Atoi(userPart); err == nil {
+		execUser.Uid = uid
+		for i := range users {
+			if users[i].uid == uid {
+				matched = &users[i]
+				break
+			}
+		}
+	} else {
+		for i := range users {
+			if users[i].name == userPart {
+				matched = &users[i]
+				break
+			}
+		}
+		if matched == nil {
+			return nil, errors.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		execUser.Uid = matched.uid
+	}
+	if matched != nil {
+		execUser.Gid = matched.gid
+		execUser.Home = matched.home
+	}
+
+	if hasGroup {
+		if execUser.Gid, err = lookupGroup(groups, groupPart); err != nil {
+			return nil, err
+		}
+	} else if matched != nil {
+		// 只指定了用户时，附加组为 /etc/group 中包含该用户的组
+		for _, group := range groups {
+			for _, member := range group.members {
+				if member == matched.name && group.gid != execUser.Gid {
+					execUser.Groups = append(execUser.Groups, group.gid)
+				}
+			}
+		}
+	}
+	for _, group := range groupAdd {
+		gid, err := lookupGroup(groups, group)
+		if err != nil {
+			return nil, err
+		}
+		execUser.Groups = append(execUser.Groups, gid)
+	}
+	return execUser, nil
+}
+
+// lookupGroup 将组名或者 gid 转换为 gid
+func lookupGroup(groups []groupEntry, group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	for _, entry := range groups {
+		if entry.name == group {
+			return entry.gid, nil
+		}
+	}
+	return 0, errors.Errorf("unable to find group %s: no matching entries in group file", group)
+}
+
+// readPasswd 读取 passwd 文件，文件不存在时返回空列表
+func readPasswd(filePath string) ([]passwdEntry, error) {
+	var users []passwdEntry
+	err := readColonFile(filePath, func(fields []string) {
+		// name:password:uid:gid:gecos:home:shell
+		if len(fields) < 6 {
+			return
+		}
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			return
+		}
+		users = append(users, passwdEntry{name: fields[0], uid: uid, gid: gid, home: fields[5]})
+	})
+	return users, err
+}
+
+// readGroup 读取 group 文件，文件不存在时返回空列表
+func readGroup(filePath string) ([]groupEntry, error) {
+	var groups []groupEntry
+	err := readColonFile(filePath, func(fields []string) {
+		// name:password:gid:member1,member2
+		if len(fields) < 4 {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		entry := groupEntry{name: fields[0], gid: gid}
+		if fields[3] != "" {
+			entry.members = strings.Split(fields[3], ",")
+		}
+		groups = append(groups, entry)
+	})
+	return groups, err
+}
+
+func readColonFile(filePath string, handle func(fields []string)) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WithMessagef(err, "open %s failed", filePath)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		handle(strings.Split(line, ":"))
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,62 @@
+package container
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestResolveUser(t *testing.T) {
+	rootDir := t.TempDir()
+	if err := os.MkdirAll(path.Join(rootDir, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/sh\nwww:x:33:33:www:/var/www:/bin/false\n"
+	group := "root:x:0:\nwww:x:33:\naudio:x:29:www\nvideo:x:44:\n"
+	if err := os.WriteFile(path.Join(rootDir, "etc/passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(rootDir, "etc/group"), []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		spec     string
+		groupAdd []string
+		expect   ExecUser
+	}{
+		{"", nil, ExecUser{Uid: 0, Gid: 0, Home: "/root"}},
+		{"www", nil, ExecUser{Uid: 33, Gid: 33, Groups: []int{29}, Home: "/var/www"}},
+		{"www:video", nil, ExecUser{Uid: 33, Gid: 44, Home: "/var/www"}},
+		{"1000:1000", []string{"audio"}, ExecUser{Uid: 1000, Gid: 1000, Groups: []int{29}, Home: "/"}},
+	}
+	for _, c := range cases {
+		execUser, err := ResolveUser(rootDir, c.spec, c.groupAdd)
+		if err != nil {
+			t.Fatalf("resolve %s error %v", c.spec, err)
+		}
+		if !reflect.DeepEqual(*execUser, c.expect) {
+			t.Fatalf("resolve %s expect %+v, got %+v", c.spec, c.expect, *execUser)
+		}
+	}
+
+	if _, err := ResolveUser(rootDir, "nobody", nil); err == nil {
+		t.Fatal("expect error for unknown user")
+	}
+
+	// 容器内 /etc/passwd 是指向绝对路径的符号链接时，在容器的根目录下解析
+	hostPasswd := path.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(hostPasswd, []byte("host:x:7:7:host:/host:/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(rootDir, "etc/passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(hostPasswd, path.Join(rootDir, "etc/passwd")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveUser(rootDir, "host", nil); err == nil {
+		t.Fatal("passwd symlink resolved on the host")
+	}
+}
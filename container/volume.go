@@ -3,12 +3,20 @@ package container
 import (
 	"fmt"
 	"github.com/aspirshar/myContainer/constant"
+	"github.com/aspirshar/myContainer/image"
+	"github.com/aspirshar/myContainer/utils"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
+	"syscall"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 // volume 相关工具
@@ -47,6 +55,165 @@ func umountVolume(mntPath, containerPath string) {
 	}
 }
 
+// Mount 在容器 init 进程中 bind mount 的目录
+type Mount struct {
+	Source      string `json:"source"`      // 宿主机上的目录
+	Destination string `json:"destination"` // 容器内的目录
+}
+
+// PrepareImageVolumes 为镜像配置中声明的数据卷在容器目录下创建匿名数据卷目录
+// 匿名数据卷随容器目录一起删除
+func PrepareImageVolumes(containerID string, volumes []string) ([]Mount, error) {
+	mounts := make([]Mount, 0, len(volumes))
+	for _, volume := range volumes {
+		name := strings.ReplaceAll(strings.Trim(path.Clean(volume), "/"), "/", "_")
+		source := path.Join(utils.GetRoot(containerID), "volumes", name)
+		if err := os.MkdirAll(source, constant.Perm0755); err != nil {
+			return nil, errors.WithMessagef(err, "mkdir volume %s failed", source)
+		}
+		mounts = append(mounts, Mount{Source: source, Destination: volume})
+	}
+	return mounts, nil
+}
+
+// bindMount 将数据卷 bind mount 到 rootfs 中的对应目录
+/*
+1.目标目录按 rootfs 为根目录解析符号链接，镜像中指向宿主机目录的符号链接不会让数据卷挂载到 rootfs 之外
+2.数据卷为空而镜像中对应目录有内容时，先将镜像中的内容复制到数据卷中，复制时不跟随符号链接
+*/
+func bindMount(rootfs string, m Mount) error {
+	target, err := volumeTarget(rootfs, m.Destination)
+	if err != nil {
+		return err
+	}
+	sourceEntries, _ := os.ReadDir(m.Source)
+	targetEntries, _ := os.ReadDir(target)
+	if len(sourceEntries) == 0 && len(targetEntries) > 0 {
+		if err = copyTree(target, m.Source); err != nil {
+			return errors.WithMessagef(err, "copy %s to volume failed", m.Destination)
+		}
+	}
+	return syscall.Mount(m.Source, target, "bind", syscall.MS_BIND|syscall.MS_REC, "")
+}
+
+// volumeTarget 返回数据卷在 rootfs 中的挂载点并创建目录，挂载点必须在 rootfs 之内
+func volumeTarget(rootfs, destination string) (string, error) {
+	target, err := image.ResolveInRoot(rootfs, destination, true)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(rootfs, target); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", errors.Errorf("volume destination %s is outside the rootfs", destination)
+	}
+	if err = os.MkdirAll(target, constant.Perm0755); err != nil {
+		return "", err
+	}
+	if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+		return "", errors.Errorf("volume destination %s is not a directory", destination)
+	}
+	return target, nil
+}
+
+// copyTree 将 src 目录中的内容复制到 dst 目录，保留属主、权限和修改时间
+// 符号链接原样复制为符号链接，不会跟随符号链接读写 src、dst 之外的文件
+func copyTree(src, dst string) error {
+	type dirTime struct {
+		path string
+		info os.FileInfo
+	}
+	var dirs []dirTime
+	err := filepath.WalkDir(src, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, filePath)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return errors.Errorf("unsupported file %s", filePath)
+		}
+		target := filepath.Join(dst, rel)
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err = os.Mkdir(target, mode.Perm()); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTime{target, info})
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			if err = os.Symlink(link, target); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err = copyRegularFile(filePath, target); err != nil {
+				return err
+			}
+		default:
+			// 设备文件、管道和 socket 按原来的类型和设备号创建
+			if err = unix.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+				return errors.WithMessagef(err, "mknod %s failed", target)
+			}
+		}
+		if err = os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			// chown 会清除 setuid、setgid，需要在 chown 之后设置权限
+			if err = unix.Fchmodat(unix.AT_FDCWD, target, stat.Mode&07777, 0); err != nil {
+				return err
+			}
+		}
+		if info.Mode().IsDir() {
+			return nil
+		}
+		return setFileTime(target, info)
+	})
+	if err != nil {
+		return err
+	}
+	// 目录的修改时间在目录中的文件复制完成后再设置
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err = setFileTime(dirs[i].path, dirs[i].info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyRegularFile 复制普通文件的内容，不跟随源文件和目标文件的符号链接
+func copyRegularFile(src, dst string) error {
+	in, err := os.OpenFile(src, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY|unix.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// setFileTime 设置文件的访问时间和修改时间，不跟随符号链接
+func setFileTime(filePath string, info os.FileInfo) error {
+	stat := info.Sys().(*syscall.Stat_t)
+	times := []unix.Timespec{unix.NsecToTimespec(syscall.TimespecToNsec(stat.Atim)), unix.NsecToTimespec(info.ModTime().UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, filePath, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
 // volumeExtract 通过冒号分割解析volume目录，比如 -v /tmp:/tmp
 func volumeExtract(volume string) (sourcePath, destinationPath string, err error) {
 	parts := strings.Split(volume, ":")
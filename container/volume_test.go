@@ -0,0 +1,93 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVolumeTarget(t *testing.T) {
+	rootfs := t.TempDir()
+	outside := t.TempDir()
+	// 镜像中的 /data 指向 /etc，/escape 指向宿主机上的目录，都要在 rootfs 内解析
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(rootfs, "data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(rootfs, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../..", filepath.Join(rootfs, "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"/data":        filepath.Join(rootfs, "etc"),
+		"/escape/logs": filepath.Join(rootfs, outside, "logs"),
+		"/up/var/lib":  filepath.Join(rootfs, "var/lib"),
+	}
+	for destination, expect := range cases {
+		target, err := volumeTarget(rootfs, destination)
+		if err != nil {
+			t.Fatalf("%s: %v", destination, err)
+		}
+		if target != expect {
+			t.Fatalf("%s: expected %s, got %s", destination, expect, target)
+		}
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			t.Fatalf("%s: target %s is not a directory", destination, target)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Fatal("volume target escaped the rootfs")
+	}
+
+	if err := os.WriteFile(filepath.Join(rootfs, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := volumeTarget(rootfs, "/file"); err == nil {
+		t.Fatal("expected error for a file destination")
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	outside := t.TempDir()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub/a.conf"), []byte("a"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sub/a.conf", "sub"} {
+		if err := os.Chtimes(filepath.Join(src, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := copyTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "sub/a.conf")); err != nil || string(data) != "a" {
+		t.Fatalf("unexpected content %q, %v", data, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != outside {
+		t.Fatalf("symlink should be copied as is, got %s, %v", link, err)
+	}
+	for name, perm := range map[string]os.FileMode{"sub": 0750, "sub/a.conf": 0640} {
+		info, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil || info.Mode().Perm() != perm || !info.ModTime().Equal(mtime) {
+			t.Fatalf("unexpected %s: %v, %v", name, info, err)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Fatal("copy wrote through the symlink")
+	}
+}
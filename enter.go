@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/aspirshar/myContainer/container"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// enterNamespaces enter 命令支持的 namespace，key 为命令行参数名，value 为 /proc/<pid>/ns 下的文件名
+var enterNamespaces = []struct {
+	Flag string
+	Name string
+}{
+	{"ipc", "ipc"},
+	{"uts", "uts"},
+	{"net", "net"},
+	{"pid", "pid"},
+	{"mount", "mnt"},
+}
+
+// EnterContainer 只进入容器 init 进程的指定 namespace 执行命令，返回命令的退出码
+/*
+与 exec 不同，enter 用于调试：
+1. 不加入容器的 cgroup，也不切换用户和工作目录
+2. 使用宿主机的环境变量，没有进入 mount namespace 时可以直接使用宿主机上的 tcpdump、ss 等工具
+*/
+func EnterContainer(containerIdOrName string, namespaces []string, comArray []string) int {
+	containerInfo, err := container.GetContainerInfoByName(containerIdOrName)
+	if err != nil {
+		containerInfo, err = getInfoByContainerId(containerIdOrName)
+		if err != nil {
+			log.Errorf("Enter container %s error %v", containerIdOrName, err)
+			return execErrorExitCode
+		}
+	}
+	if len(comArray) == 0 {
+		comArray = []string{defaultEnterShell(namespaces)}
+	}
+
+	cmd := exec.Command("/proc/self/exe", "exec")
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env,
+		EnvExecPid+"="+containerInfo.Pid,
+		EnvExecNamespaces+"="+strings.Join(namespaces, ","),
+		EnvExecArgc+"="+strconv.Itoa(len(comArray)),
+	)
+	for i, arg := range comArray {
+		cmd.Env = append(cmd.Env, EnvExecArgvPrefix+strconv.Itoa(i)+"="+arg)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		// 命令被信号杀死时 ExitCode 为 -1，与 exec 一样返回 128+信号值
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return container.ExitCodeFromStatus(exitErr.Sys().(syscall.WaitStatus))
+		}
+		log.Errorf("Enter container %s error %v", containerIdOrName, err)
+		return execErrorExitCode
+	}
+	return 0
+}
+
+// defaultEnterShell 没有指定命令时运行的 shell，进入 mount namespace 后只能使用容器内的 /bin/sh
+func defaultEnterShell(namespaces []string) string {
+	for _, ns := range namespaces {
+		if ns == "mnt" {
+			return "/bin/sh"
+		}
+	}
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
@@ -1,81 +1,340 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"path"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/logger"
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
 	// 需要导入nsenter包，以触发C代码
 	_ "github.com/aspirshar/myContainer/nsenter"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// nsenter里的C代码里已经出现mydocker_pid和mydocker_cmd这两个Key,主要是为了控制是否执行C代码里面的setns.
+// nsenter里的C代码里已经出现mydocker_pid和mydocker_argc这两个Key,主要是为了控制是否执行C代码里面的setns.
 const (
-	EnvExecPid = "mydocker_pid"
-	EnvExecCmd = "mydocker_cmd"
+	EnvExecPid  = "mydocker_pid"
+	EnvExecArgc = "mydocker_argc"
+	// 命令的第 i 个参数保存在 mydocker_argv_<i> 中，C代码原样传给 execvp，不经过 shell 解析
+	EnvExecArgvPrefix = "mydocker_argv_"
+	// 以下环境变量由C代码在执行命令前用于切换工作目录和用户
+	EnvExecCwd    = "mydocker_cwd"
+	EnvExecUid    = "mydocker_uid"
+	EnvExecGid    = "mydocker_gid"
+	EnvExecGroups = "mydocker_groups"
+	// enter 命令通过该变量指定要进入的 namespace 列表，逗号分隔
+	EnvExecNamespaces = "mydocker_ns"
 )
 
-func ExecContainer(containerIdOrName string, comArray []string) {
+// execErrorExitCode 进入容器失败时的退出码，与C代码中的 EXIT_SETUP_FAILED 一致
+const execErrorExitCode = 125
+
+// execOptions exec 进入容器时的进程参数，未指定时使用容器 run 时的配置
+type execOptions struct {
+	Cwd         string   // 工作目录
+	User        string   // 用户，格式为 name|uid[:group|gid]
+	GroupAdd    []string // 附加组
+	Env         []string // 额外的环境变量，覆盖容器中同名的变量
+	Interactive bool     // 是否连接标准输入
+	Tty         bool     // 是否分配伪终端
+	Detach      bool     // 是否在后台运行，输出写入容器日志
+}
+
+// ExecContainer 在容器中执行命令，返回命令的退出码，后台运行时打印 exec 会话ID并返回 0
+func ExecContainer(containerIdOrName string, comArray []string, opts *execOptions) int {
 	// 首先尝试通过容器名称获取容器ID
 	containerId := containerIdOrName
 	containerInfo, err := container.GetContainerInfoByName(containerIdOrName)
 	if err != nil {
 		// 如果通过名称查找失败，假设输入的是容器ID，直接使用
 		log.Infof("Container name '%s' not found, treating as container ID", containerIdOrName)
+		containerInfo, err = getInfoByContainerId(containerIdOrName)
+		if err != nil {
+			log.Errorf("Exec container getInfoByContainerId %s error %v", containerId, err)
+			return execErrorExitCode
+		}
 	} else {
 		// 如果通过名称找到了容器，使用其ID
 		containerId = containerInfo.Id
 		log.Infof("Found container '%s' with ID: %s", containerIdOrName, containerId)
 	}
 
-	// 根据传进来的容器ID获取对应的PID
-	pid, err := getPidByContainerId(containerId)
-	if err != nil {
-		log.Errorf("Exec container getContainerPidByName %s error %v", containerId, err)
-		return
+	log.Infof("container pid: %s command: %v", containerInfo.Pid, comArray)
+	session := &container.ExecSession{
+		ID:         container.GenerateExecID(),
+		Command:    comArray,
+		User:       opts.User,
+		WorkingDir: opts.Cwd,
+		GroupAdd:   opts.GroupAdd,
+		Env:        opts.Env,
+		Tty:        opts.Tty,
+		Detach:     opts.Detach,
+		StartedAt:  time.Now().Format(time.RFC3339Nano),
+	}
+	if opts.Detach {
+		if err = startExecShim(containerId, session); err != nil {
+			log.Errorf("Exec container %s error %v", containerId, err)
+			return execErrorExitCode
+		}
+		fmt.Println(session.ID)
+		return 0
 	}
 
-	cmd := exec.Command("/proc/self/exe", "exec")
-	cmd.Stdin = os.Stdin
+	cmd, err := newExecProcess(containerInfo, comArray, opts)
+	if err != nil {
+		log.Errorf("Exec container %s error %v", containerId, err)
+		return execErrorExitCode
+	}
+	if opts.Tty {
+		return runExecWithPty(containerId, session, cmd, opts.Interactive)
+	}
+	if opts.Interactive {
+		cmd.Stdin = os.Stdin
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	if err = startExecSession(containerId, session, cmd); err != nil {
+		log.Errorf("Exec container %s error %v", containerId, err)
+		return execErrorExitCode
+	}
+	return finishExecSession(containerId, session, cmd.Wait())
+}
 
-	// 把命令拼接成字符串，便于传递
-	cmdStr := strings.Join(comArray, " ")
-	log.Infof("container pid: %s command: %s", pid, cmdStr)
-	_ = os.Setenv(EnvExecPid, pid)
-	_ = os.Setenv(EnvExecCmd, cmdStr)
-	// 把指定PID进程的环境变量传递给新启动的进程，实现通过exec命令也能查询到容器的环境变量
-	containerEnvs := getEnvsByPid(pid)
-	cmd.Env = append(os.Environ(), containerEnvs...)
-	// 设置 MYCONTAINER_ROOT 环境变量，保证子进程路径一致
-	cmd.Env = append(cmd.Env, "MYCONTAINER_ROOT="+os.Getenv("MYCONTAINER_ROOT"))
-
-	if err = cmd.Run(); err != nil {
+// runExecWithPty 为命令分配伪终端，在宿主机终端和伪终端之间转发输入输出
+func runExecWithPty(containerId string, session *container.ExecSession, cmd *exec.Cmd, interactive bool) int {
+	master, slave, err := utils.OpenPty()
+	if err != nil {
 		log.Errorf("Exec container %s error %v", containerId, err)
+		return execErrorExitCode
 	}
+	defer master.Close()
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	// 命令在新的会话中运行，伪终端成为它的控制终端
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	err = startExecSession(containerId, session, cmd)
+	slave.Close()
+	if err != nil {
+		log.Errorf("Exec container %s error %v", containerId, err)
+		return execErrorExitCode
+	}
+
+	if utils.IsTerminal(os.Stdout) {
+		_ = utils.ResizePty(master, os.Stdout)
+		winch := make(chan os.Signal, 1)
+		signal.Notify(winch, syscall.SIGWINCH)
+		defer signal.Stop(winch)
+		go func() {
+			for range winch {
+				_ = utils.ResizePty(master, os.Stdout)
+			}
+		}()
+	}
+	if interactive {
+		// raw 模式下 Ctrl-C 等按键原样传给容器内的终端处理
+		if utils.IsTerminal(os.Stdin) {
+			restore, err := utils.SetRawTerminal(os.Stdin)
+			if err != nil {
+				log.Warnf("set raw terminal error %v", err)
+			} else {
+				defer restore()
+			}
+		}
+		go func() { _, _ = io.Copy(master, os.Stdin) }()
+	}
+	// 所有持有从设备的进程退出后，读主设备会返回 EIO，输出转发随之结束
+	outputDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(os.Stdout, master)
+		close(outputDone)
+	}()
+	exitCode := finishExecSession(containerId, session, cmd.Wait())
+	// 命令留在后台的子进程可能仍持有从设备，不一直等待输出结束
+	select {
+	case <-outputDone:
+	case <-time.After(time.Second):
+	}
+	return exitCode
+}
+
+// startExecShim 保存 exec 会话并启动后台进程执行命令，后台进程负责等待命令结束并记录退出码
+func startExecShim(containerId string, session *container.ExecSession) error {
+	if err := container.SaveExecSession(containerId, session); err != nil {
+		return err
+	}
+	cmd := exec.Command("/proc/self/exe", "exec-shim", containerId, session.ID)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return errors.WithMessage(err, "start exec shim failed")
+	}
+	// 不等待后台进程，退出后由 init 进程回收
+	return cmd.Process.Release()
 }
 
-func getPidByContainerId(containerId string) (string, error) {
-	// 拼接出记录容器信息的文件路径
-	dirPath := fmt.Sprintf(container.InfoLocFormat, containerId)
-	configFilePath := path.Join(dirPath, container.ConfigName)
-	// 读取内容并解析
-	contentBytes, err := os.ReadFile(configFilePath)
+// runExecShim 在后台执行 exec -d 的命令，输出追加到容器日志
+func runExecShim(containerId, execId string) error {
+	containerInfo, err := getInfoByContainerId(containerId)
+	if err != nil {
+		return err
+	}
+	session, err := container.GetExecSession(containerId, execId)
+	if err != nil {
+		return err
+	}
+	opts := &execOptions{
+		Cwd:      session.WorkingDir,
+		User:     session.User,
+		GroupAdd: session.GroupAdd,
+		Env:      session.Env,
+	}
+	cmd, err := newExecProcess(containerInfo, session.Command, opts)
 	if err != nil {
-		return "", err
+		finishExecSession(containerId, session, err)
+		return err
 	}
-	var containerInfo container.Info
-	if err = json.Unmarshal(contentBytes, &containerInfo); err != nil {
-		return "", err
+	driver, err := newExecLogDriver(containerInfo)
+	if err != nil {
+		finishExecSession(containerId, session, err)
+		return err
 	}
-	return containerInfo.Pid, nil
+	defer driver.Close()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		finishExecSession(containerId, session, err)
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		finishExecSession(containerId, session, err)
+		return err
+	}
+	if err = startExecSession(containerId, session, cmd); err != nil {
+		finishExecSession(containerId, session, err)
+		return err
+	}
+	// 必须先读完输出再 Wait，Wait 会关闭管道
+	copyStreams(driver, map[string]io.Reader{logger.Stdout: stdout, logger.Stderr: stderr})
+	finishExecSession(containerId, session, cmd.Wait())
+	return nil
+}
+
+// newExecLogDriver 使用容器的日志驱动记录 exec -d 命令的输出
+// json-file 日志由容器的日志进程负责轮转，这里只追加写入
+func newExecLogDriver(containerInfo *container.Info) (logger.Driver, error) {
+	logInfo := container.NewLogInfo(containerInfo.Id, containerInfo.Name, containerInfo.Image, containerInfo.Labels, containerInfo.LogConfig)
+	if logInfo.Config.DriverName() == logger.JSONFileDriver {
+		return logger.NewJSONFile(logInfo.LogPath, nil)
+	}
+	return logger.New(logInfo)
+}
+
+// startExecSession 启动 exec 进程并记录会话的 PID
+func startExecSession(containerId string, session *container.ExecSession, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return errors.WithMessage(err, "start exec process failed")
+	}
+	session.Pid = cmd.Process.Pid
+	session.Running = true
+	session.StartedAt = time.Now().Format(time.RFC3339Nano)
+	if err := container.SaveExecSession(containerId, session); err != nil {
+		log.Warnf("save exec session %s error %v", session.ID, err)
+	}
+	return nil
+}
+
+// finishExecSession 根据 exec 进程的结束状态记录退出码并返回
+func finishExecSession(containerId string, session *container.ExecSession, err error) int {
+	exitCode := 0
+	if err != nil {
+		exitCode = execErrorExitCode
+		// C代码以命令的退出码退出，被信号杀死时为 128+信号值
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = container.ExitCodeFromStatus(exitErr.Sys().(syscall.WaitStatus))
+		} else {
+			log.Errorf("Exec container %s error %v", containerId, err)
+		}
+	}
+	session.Running = false
+	session.ExitCode = &exitCode
+	session.FinishedAt = time.Now().Format(time.RFC3339Nano)
+	if err = container.SaveExecSession(containerId, session); err != nil {
+		log.Warnf("save exec session %s error %v", session.ID, err)
+	}
+	return exitCode
+}
+
+// newExecProcess 构造一个进入容器 init 进程所在 cgroup 和 namespace 执行 argv 的进程
+// 进程启动时由 nsenter 包中的C代码完成 cgroup 加入、setns、切换工作目录和用户并直接 exec 命令，进程的退出码即为命令的退出码
+func newExecProcess(containerInfo *container.Info, argv []string, opts *execOptions) (*exec.Cmd, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("missing command")
+	}
+	pid := containerInfo.Pid
+	cwd, userSpec := opts.Cwd, opts.User
+	if cwd == "" {
+		cwd = containerInfo.WorkingDir
+	}
+	if cwd == "" {
+		cwd = "/"
+	}
+	if userSpec == "" {
+		userSpec = containerInfo.User
+	}
+	// 通过 /proc/<pid>/root 读取容器内的 /etc/passwd 和 /etc/group
+	rootDir := fmt.Sprintf("/proc/%s/root", pid)
+	execUser, err := container.ResolveUser(rootDir, userSpec, opts.GroupAdd)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(execUser.Groups))
+	for _, gid := range execUser.Groups {
+		groups = append(groups, strconv.Itoa(gid))
+	}
+
+	cmd := exec.Command("/proc/self/exe", "exec")
+	// 只使用容器 init 进程的环境变量，不继承宿主机的环境变量
+	// 下面 mydocker_ 开头的变量由C代码读取后从环境中删除，不会传给容器内的命令
+	containerEnv := getEnvsByPid(pid)
+	cmd.Env = utils.MergeEnv(containerEnv, execHomeEnv(rootDir, containerEnv, containerInfo.User, execUser), opts.Env)
+	cmd.Env = append(cmd.Env, EnvExecPid+"="+pid, EnvExecArgc+"="+strconv.Itoa(len(argv)))
+	for i, arg := range argv {
+		cmd.Env = append(cmd.Env, EnvExecArgvPrefix+strconv.Itoa(i)+"="+arg)
+	}
+	cmd.Env = append(cmd.Env,
+		EnvExecCwd+"="+cwd,
+		EnvExecUid+"="+strconv.Itoa(execUser.Uid),
+		EnvExecGid+"="+strconv.Itoa(execUser.Gid),
+		EnvExecGroups+"="+strings.Join(groups, ","),
+	)
+	return cmd, nil
+}
+
+// execHomeEnv 返回 exec 用户的 HOME
+/*
+init 进程的 HOME 在没有指定时为容器用户在 /etc/passwd 中的 home 目录，
+1.容器的 HOME 是默认值时，使用 exec 用户在容器内的 home 目录
+2.运行容器时通过 -e 或 --env-file 指定了其他 HOME 时保持不变
+*/
+func execHomeEnv(rootDir string, containerEnv []string, containerUser string, execUser *container.ExecUser) []string {
+	home, ok := utils.LookupEnv(containerEnv, "HOME")
+	if ok {
+		defaultUser, err := container.ResolveUser(rootDir, containerUser, nil)
+		if err != nil || home != defaultUser.Home {
+			return nil
+		}
+	}
+	return []string{"HOME=" + execUser.Home}
 }
 
 // getEnvsByPid 读取指定PID进程的环境变量
@@ -87,6 +346,11 @@ func getEnvsByPid(pid string) []string {
 		return nil
 	}
 	// env split by \u0000
-	envs := strings.Split(string(contentBytes), "\u0000")
+	var envs []string
+	for _, env := range strings.Split(string(contentBytes), "\u0000") {
+		if env != "" {
+			envs = append(envs, env)
+		}
+	}
 	return envs
 }
\ No newline at end of file
@@ -3,6 +3,7 @@ module github.com/aspirshar/myContainer
 go 1.23.0
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.9.3
 	github.com/urfave/cli v1.22.17
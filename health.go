@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aspirshar/myContainer/container"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// healthConfigFromContext 根据命令行参数生成健康检查配置，未指定的参数使用镜像配置中的值
+func healthConfigFromContext(context *cli.Context, imageConfig *container.ImageConfig) *container.HealthConfig {
+	health := &container.HealthConfig{}
+	if imageConfig != nil && imageConfig.Healthcheck != nil {
+		*health = *imageConfig.Healthcheck
+	}
+	if context.IsSet("health-cmd") {
+		health.Test = []string{"CMD-SHELL", context.String("health-cmd")}
+	}
+	if context.IsSet("health-interval") {
+		health.Interval = context.Duration("health-interval")
+	}
+	if context.IsSet("health-timeout") {
+		health.Timeout = context.Duration("health-timeout")
+	}
+	if context.IsSet("health-retries") {
+		health.Retries = context.Int("health-retries")
+	}
+	if context.IsSet("health-start-period") {
+		health.StartPeriod = context.Duration("health-start-period")
+	}
+	if health.Disabled() {
+		return nil
+	}
+	health.SetDefaults()
+	return health
+}
+
+// startHealthMonitor 启动一个脱离当前会话的后台进程，周期性地对容器进行健康检查
+// 后台进程在容器退出后自行结束
+func startHealthMonitor(containerId string) error {
+	cmd := exec.Command("/proc/self/exe", "health-monitor", containerId)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return errors.WithMessage(err, "start health monitor failed")
+	}
+	// 不等待后台进程，退出后由 init 进程回收
+	return cmd.Process.Release()
+}
+
+// monitorHealth 按照容器的健康检查配置周期性地执行探测，并将结果写入单独的健康状态文件
+// 只有健康检查进程写健康状态文件，不会与 stop、ps 等改写 config.json 的操作冲突
+func monitorHealth(containerId string) error {
+	containerInfo, err := getInfoByContainerId(containerId)
+	if err != nil {
+		return err
+	}
+	health := containerInfo.Health
+	if health.Disabled() {
+		return nil
+	}
+	state := containerInfo.HealthState
+	if state == nil {
+		state = &container.Health{Status: container.HealthStarting}
+	}
+	startTime := time.Now()
+	ticker := time.NewTicker(health.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !isContainerRunning(containerInfo) {
+			return nil
+		}
+		result := runHealthProbe(containerInfo, health)
+
+		// 探测期间容器可能已被 stop、rm，这里重新读取后再更新
+		containerInfo, err = getInfoByContainerId(containerId)
+		if err != nil || !isContainerRunning(containerInfo) {
+			return nil
+		}
+		inStartPeriod := time.Since(startTime) < health.StartPeriod
+		changed := state.Update(result, health, inStartPeriod)
+		if err = container.SaveHealthState(containerId, state); err != nil {
+			log.Errorf("save container %s health error %v", containerId, err)
+			continue
+		}
+		if changed {
+			containerInfo.HealthState = state
+			action := "health_status: " + state.Status
+			if err = container.RecordEvent(containerInfo, action); err != nil {
+				log.Errorf("record container %s event error %v", containerId, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runHealthProbe 以 exec 的方式在容器中执行一次健康检查命令
+func runHealthProbe(containerInfo *container.Info, health *container.HealthConfig) container.HealthResult {
+	result := container.HealthResult{Start: time.Now()}
+
+	// CMD 直接执行参数，CMD-SHELL 通过容器内的 /bin/sh -c 执行
+	argv := health.Test[1:]
+	if health.Test[0] == "CMD-SHELL" {
+		argv = []string{"/bin/sh", "-c", strings.Join(health.Test[1:], " ")}
+	}
+	cmd, err := newExecProcess(containerInfo, argv, &execOptions{})
+	if err != nil {
+		result.End = time.Now()
+		result.ExitCode = -1
+		result.Output = err.Error()
+		return result
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// 超时被杀死后，容器内残留的子进程可能仍持有输出管道，避免 Wait 一直阻塞
+	cmd.WaitDelay = time.Second
+	if err := cmd.Start(); err != nil {
+		result.End = time.Now()
+		result.ExitCode = -1
+		result.Output = err.Error()
+		return result
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	timer := time.NewTimer(health.Timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		result.ExitCode = 0
+		if err != nil {
+			result.ExitCode = -1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				result.ExitCode = exitErr.ExitCode()
+			}
+		}
+		result.Output = output.String()
+	case <-timer.C:
+		_ = cmd.Process.Kill()
+		<-done
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("Health check exceeded timeout (%v)", health.Timeout)
+	}
+	result.End = time.Now()
+	return result
+}
+
+// isContainerRunning 判断容器的 init 进程是否仍然存在
+func isContainerRunning(containerInfo *container.Info) bool {
+	if containerInfo.Status != container.RUNNING {
+		return false
+	}
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return false
+	}
+	return syscall.Kill(pid, 0) != syscall.ESRCH
+}
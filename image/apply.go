@@ -0,0 +1,251 @@
+package image
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// overlayOpaqueXattr overlay 用这个 xattr 标记被替换的目录，下层中同名目录的内容不可见
+const overlayOpaqueXattr = "trusted.overlay.opaque"
+
+// ApplyLayer 按 OCI 镜像规范将一层解压到 root 目录，root 作为 overlay 的一个 lower 目录与其他层叠加
+/*
+1.每一层解压到单独的目录，删除下层文件的 .wh.<name> 转换为 overlay 的 whiteout，即 0/0 字符设备
+2..wh..wh..opq 转换为所在目录的 trusted.overlay.opaque=y xattr，下层目录中的内容都不可见
+3.还原硬链接、符号链接、设备文件、FIFO、属主、权限和修改时间，xattr 只还原 user.* 和 security.capability
+4.所有文件都限制在 root 之内，路径中的 .. 直接拒绝，符号链接按 root 为根目录解析
+5.目录的修改时间在所有文件解压完后再设置，避免解压目录中的文件时被改变
+*/
+func ApplyLayer(root string, r io.Reader) error {
+	return extractTar(root, r, true)
+}
+
+// extractTar 将 tar 流解压到 root，whiteout 为 false 时 .wh. 文件作为普通文件解压
+func extractTar(root string, r io.Reader, whiteout bool) error {
+	tr := tar.NewReader(r)
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []dirTime
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.WithMessage(err, "read layer failed")
+		}
+		name, err := layerPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if whiteout && strings.HasPrefix(path.Base(name), WhiteoutPrefix) {
+			if err = applyWhiteout(root, name); err != nil {
+				return err
+			}
+			continue
+		}
+		// 路径中的符号链接在层目录内解析，恶意的层不能通过符号链接写到层目录之外
+		target, err := ResolveInRoot(root, name, false)
+		if err != nil {
+			return err
+		}
+		if err = applyEntry(root, target, hdr, tr); err != nil {
+			return errors.WithMessagef(err, "apply %s failed", hdr.Name)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirTime{target, hdr.ModTime})
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setModTime(dirs[i].path, dirs[i].modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractArchive 将 tar 包原样解压到 root，tar 包可以是未压缩、gzip 或 zstd 格式，用于 build 的 ADD
+// 与 ApplyLayer 相同，所有文件限制在 root 之内，但 .wh. 文件作为普通文件解压，不表示删除
+func ExtractArchive(root string, r io.Reader) error {
+	reader, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return extractTar(root, reader, false)
+}
+
+// IsArchive 判断文件是否是 tar 包，tar 包可以经过 gzip 或 zstd 压缩
+func IsArchive(filePath string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	reader, err := decompress(file)
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+	_, err = tar.NewReader(reader).Next()
+	return err == nil
+}
+
+// layerPath 将 tar 中的文件名转换为相对于层根目录的路径，不允许通过 .. 跳出层目录
+func layerPath(name string) (string, error) {
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.Errorf("invalid path %s in layer", name)
+	}
+	cleaned = strings.TrimPrefix(cleaned, "/")
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// applyWhiteout 将 .wh. 文件转换为 overlay 的 whiteout 或 opaque 目录
+func applyWhiteout(root, name string) error {
+	dir, base := path.Dir(name), path.Base(name)
+	dirPath, err := ResolveInRoot(root, dir, true)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dirPath, 0755); err != nil {
+		return errors.WithMessagef(err, "mkdir %s failed", dirPath)
+	}
+	if base == WhiteoutOpaque {
+		err = unix.Lsetxattr(dirPath, overlayOpaqueXattr, []byte("y"), 0)
+		return errors.WithMessagef(err, "set opaque xattr on %s failed", dirPath)
+	}
+	if strings.HasPrefix(base, WhiteoutPrefix+WhiteoutPrefix) {
+		// .wh..wh.plnk 等是 aufs 的元数据，不表示删除
+		return nil
+	}
+	target := filepath.Join(dirPath, strings.TrimPrefix(base, WhiteoutPrefix))
+	if err = os.RemoveAll(target); err != nil {
+		return errors.WithMessagef(err, "remove %s failed", target)
+	}
+	err = unix.Mknod(target, unix.S_IFCHR, 0)
+	return errors.WithMessagef(err, "create whiteout %s failed", target)
+}
+
+// applyEntry 在层目录中创建 tar 中的一项，已经存在的同名文件被替换，同名目录保留并更新属性
+func applyEntry(root, target string, hdr *tar.Header, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(target); err == nil && !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err = os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, os.FileMode(mode)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, r)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		linkName, err := layerPath(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		source, err := ResolveInRoot(root, linkName, false)
+		if err != nil {
+			return err
+		}
+		// 硬链接指向同一层中已经解压的文件，属性与目标文件相同
+		return os.Link(source, target)
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		fileType := map[byte]uint32{tar.TypeChar: unix.S_IFCHR, tar.TypeBlock: unix.S_IFBLK, tar.TypeFifo: unix.S_IFIFO}[hdr.Typeflag]
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(target, fileType|mode, int(dev)); err != nil {
+			return err
+		}
+	case tar.TypeXGlobalHeader:
+		return nil
+	default:
+		return errors.Errorf("unsupported type %c", hdr.Typeflag)
+	}
+
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(key, "SCHILY.xattr."); ok && allowedXattr(name) {
+			if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil && err != unix.ENOTSUP {
+				return errors.WithMessagef(err, "set xattr %s failed", name)
+			}
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return setModTime(target, hdr.ModTime)
+	}
+	// chown 会清除 setuid、setgid 位，最后再设置权限；umask 也可能去掉部分权限位
+	if err := os.Chmod(target, fileMode(mode)); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return setModTime(target, hdr.ModTime)
+}
+
+// allowedXattr 判断层中的 xattr 能否还原，只允许 user.* 和文件 capability
+// trusted.overlay.* 等 overlay 的元数据不能来自层，否则可以伪造 opaque、redirect 隐藏或替换下层的内容，
+// opaque 目录只能由 .wh..wh..opq 生成
+func allowedXattr(name string) bool {
+	return strings.HasPrefix(name, "user.") || name == "security.capability"
+}
+
+// fileMode 将 unix 权限位转换为 os.FileMode，保留 setuid、setgid 和 sticky 位
+func fileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+	if mode&unix.S_ISUID != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&unix.S_ISGID != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&unix.S_ISVTX != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
+
+// setModTime 设置文件的访问时间和修改时间，不跟随符号链接
+func setModTime(filePath string, modTime time.Time) error {
+	if modTime.IsZero() {
+		return nil
+	}
+	ts := unix.NsecToTimespec(modTime.UnixNano())
+	err := unix.UtimesNanoAt(unix.AT_FDCWD, filePath, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+	return errors.WithMessagef(err, "set mtime of %s failed", filePath)
+}
@@ -0,0 +1,248 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// buildLayer 按顺序生成层的 tar 流，普通文件的内容取自 contents
+func buildLayer(t *testing.T, entries []*tar.Header, contents map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range entries {
+		content := contents[hdr.Name]
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(content))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestApplyLayer(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	base := buildLayer(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime},
+		{Name: "etc/passwd", Typeflag: tar.TypeReg},
+		{Name: "etc/shadow", Typeflag: tar.TypeReg, Mode: 0640, Uid: 0, Gid: 42},
+		{Name: "bin/su", Typeflag: tar.TypeReg, Mode: 04755, ModTime: mtime},
+		{Name: "bin/su-link", Typeflag: tar.TypeLink, Linkname: "bin/su"},
+		{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+		{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+		{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0600},
+		{Name: "home/app/", Typeflag: tar.TypeDir, Mode: 0700, Uid: 1000, Gid: 1000},
+		{Name: "var/cache/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "var/cache/old", Typeflag: tar.TypeReg},
+	}, map[string]string{"etc/passwd": "root", "etc/shadow": "secret", "bin/su": "su"})
+	top := buildLayer(t, []*tar.Header{
+		{Name: "etc/.wh.shadow", Typeflag: tar.TypeReg},
+		{Name: "var/cache/.wh..wh..opq", Typeflag: tar.TypeReg},
+		{Name: "var/cache/new", Typeflag: tar.TypeReg},
+	}, nil)
+
+	root := t.TempDir()
+	baseDir, topDir := path.Join(root, "base"), path.Join(root, "top")
+	os.Mkdir(baseDir, 0755)
+	os.Mkdir(topDir, 0755)
+	if err := ApplyLayer(baseDir, bytes.NewReader(base)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyLayer(topDir, bytes.NewReader(top)); err != nil {
+		t.Fatal(err)
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Lstat(path.Join(baseDir, "bin/su"), &st); err != nil || st.Mode&07777 != 04755 || st.Nlink != 2 {
+		t.Fatalf("unexpected bin/su mode %o nlink %d %v", st.Mode&07777, st.Nlink, err)
+	}
+	if err := syscall.Lstat(path.Join(baseDir, "etc/shadow"), &st); err != nil || st.Gid != 42 || st.Mode&0777 != 0640 {
+		t.Fatalf("unexpected etc/shadow gid %d mode %o %v", st.Gid, st.Mode&0777, err)
+	}
+	if err := syscall.Lstat(path.Join(baseDir, "dev/null"), &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFCHR || st.Rdev != unix.Mkdev(1, 3) {
+		t.Fatalf("unexpected dev/null %o %d %v", st.Mode, st.Rdev, err)
+	}
+	if err := syscall.Lstat(path.Join(baseDir, "home/app"), &st); err != nil || st.Uid != 1000 || st.Mode&0777 != 0700 {
+		t.Fatalf("unexpected home/app uid %d mode %o %v", st.Uid, st.Mode&0777, err)
+	}
+	if info, err := os.Stat(path.Join(baseDir, "etc")); err != nil || !info.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected etc mtime %v %v", info.ModTime(), err)
+	}
+	if target, err := os.Readlink(path.Join(baseDir, "bin/sh")); err != nil || target != "busybox" {
+		t.Fatalf("unexpected bin/sh %s %v", target, err)
+	}
+
+	// .wh. 文件转换为 overlay 的 whiteout 和 opaque 目录
+	if err := syscall.Lstat(path.Join(topDir, "etc/shadow"), &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFCHR || st.Rdev != 0 {
+		t.Fatalf("etc/shadow is not a whiteout %o %d %v", st.Mode, st.Rdev, err)
+	}
+	opaque := make([]byte, 1)
+	if _, err := unix.Lgetxattr(path.Join(topDir, "var/cache"), overlayOpaqueXattr, opaque); err != nil || opaque[0] != 'y' {
+		t.Fatalf("var/cache is not opaque %v", err)
+	}
+	for _, name := range []string{"etc/.wh.shadow", "var/cache/.wh..wh..opq"} {
+		if _, err := os.Lstat(path.Join(topDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s should not exist", name)
+		}
+	}
+
+	// 两层叠加后被删除的文件不可见
+	merged := path.Join(root, "merged")
+	os.Mkdir(merged, 0755)
+	if err := unix.Mount("overlay", merged, "overlay", 0, "lowerdir="+topDir+":"+baseDir); err != nil {
+		t.Skipf("mount overlay: %v", err)
+	}
+	defer unix.Unmount(merged, 0)
+	if _, err := os.Lstat(path.Join(merged, "etc/shadow")); !os.IsNotExist(err) {
+		t.Fatal("deleted etc/shadow is visible")
+	}
+	entries, err := os.ReadDir(path.Join(merged, "var/cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "new" {
+		t.Fatalf("unexpected var/cache entries %v", names)
+	}
+}
+
+func TestApplyLayerInvalidPath(t *testing.T) {
+	layer := buildLayer(t, []*tar.Header{{Name: "../escape", Typeflag: tar.TypeReg}}, nil)
+	if err := ApplyLayer(t.TempDir(), bytes.NewReader(layer)); err == nil {
+		t.Fatal("expect error for path outside the layer")
+	}
+	loop := buildLayer(t, []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b"},
+		{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "/a"},
+		{Name: "a/file", Typeflag: tar.TypeReg},
+	}, nil)
+	if err := ApplyLayer(t.TempDir(), bytes.NewReader(loop)); err == nil {
+		t.Fatal("expect error for symlink loop")
+	}
+}
+
+// TestApplyLayerSymlinkEscape 恶意的层通过符号链接写入层目录之外的文件
+func TestApplyLayerSymlinkEscape(t *testing.T) {
+	dir := t.TempDir()
+	root, outside := path.Join(dir, "root"), path.Join(dir, "outside")
+	os.Mkdir(root, 0755)
+	os.Mkdir(outside, 0755)
+	os.WriteFile(path.Join(outside, "secret"), []byte("secret"), 0644)
+
+	layer := buildLayer(t, []*tar.Header{
+		{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "abs/pwned", Typeflag: tar.TypeReg},
+		{Name: "rel", Typeflag: tar.TypeSymlink, Linkname: "../../../../outside"},
+		{Name: "rel/pwned", Typeflag: tar.TypeReg},
+		{Name: "loop", Typeflag: tar.TypeSymlink, Linkname: "rel/../abs/.."},
+		{Name: "loop/outside/pwned2", Typeflag: tar.TypeReg},
+		{Name: "abs/.wh.secret", Typeflag: tar.TypeReg},
+	}, map[string]string{"abs/pwned": "x", "rel/pwned": "x", "loop/outside/pwned2": "x"})
+	if err := ApplyLayer(root, bytes.NewReader(layer)); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 1 || entries[0].Name() != "secret" {
+		t.Fatalf("layer wrote outside the root: %v", entries)
+	}
+	if data, err := os.ReadFile(path.Join(outside, "secret")); err != nil || string(data) != "secret" {
+		t.Fatalf("file outside the root changed: %q %v", data, err)
+	}
+	// 符号链接按层目录为根解析，文件落在层目录之内
+	for _, name := range []string{path.Join(outside, "pwned"), path.Join(outside, "pwned2"), "outside/pwned"} {
+		if _, err := os.Lstat(path.Join(root, name)); err != nil {
+			t.Fatalf("expect %s inside the root: %v", name, err)
+		}
+	}
+
+	// 硬链接不能指向层目录之外的文件
+	link := buildLayer(t, []*tar.Header{
+		{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "stolen", Typeflag: tar.TypeLink, Linkname: "abs/secret"},
+	}, nil)
+	if err := ApplyLayer(t.TempDir(), bytes.NewReader(link)); err == nil {
+		t.Fatal("expect error for hard link outside the root")
+	}
+}
+
+// TestExtractArchive ADD 的 tar 包原样解压，.wh. 文件不表示删除
+func TestExtractArchive(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(path.Join(root, "foo"), []byte("foo"), 0644)
+	os.Mkdir(path.Join(root, "dir"), 0755)
+	os.WriteFile(path.Join(root, "dir/keep"), []byte("keep"), 0644)
+
+	archive := buildLayer(t, []*tar.Header{
+		{Name: ".wh.foo", Typeflag: tar.TypeReg},
+		{Name: "dir/.wh..wh..opq", Typeflag: tar.TypeReg},
+		{Name: "dir/new", Typeflag: tar.TypeReg},
+	}, map[string]string{".wh.foo": "literal", "dir/new": "new"})
+	if err := ExtractArchive(root, bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"foo": "foo", ".wh.foo": "literal", "dir/keep": "keep", "dir/new": "new", "dir/.wh..wh..opq": ""} {
+		if data, err := os.ReadFile(path.Join(root, name)); err != nil || string(data) != want {
+			t.Fatalf("unexpected content of %s: %q %v", name, data, err)
+		}
+	}
+	if _, err := unix.Lgetxattr(path.Join(root, "dir"), overlayOpaqueXattr, make([]byte, 8)); err == nil {
+		t.Fatal("archive should not create opaque directories")
+	}
+	escape := buildLayer(t, []*tar.Header{{Name: "../escape", Typeflag: tar.TypeReg}}, nil)
+	if err := ExtractArchive(root, bytes.NewReader(escape)); err == nil {
+		t.Fatal("expect error for path outside the root")
+	}
+}
+
+// TestApplyLayerXattrs 层中只有 user.* 和 security.capability 会被还原，overlay 的元数据不能来自层
+func TestApplyLayerXattrs(t *testing.T) {
+	root := t.TempDir()
+	layer := buildLayer(t, []*tar.Header{{
+		Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755,
+		PAXRecords: map[string]string{
+			"SCHILY.xattr." + overlayOpaqueXattr:      "y",
+			"SCHILY.xattr.trusted.overlay.redirect":   "/etc",
+			"SCHILY.xattr.trusted.overlay.metacopy":   "",
+			"SCHILY.xattr.security.selinux":           "system_u:object_r:etc_t:s0",
+			"SCHILY.xattr.user.mycontainer.applytest": "ok",
+		},
+	}}, nil)
+	if err := ApplyLayer(root, bytes.NewReader(layer)); err != nil {
+		t.Fatal(err)
+	}
+	dir := path.Join(root, "dir")
+	for _, name := range []string{overlayOpaqueXattr, "trusted.overlay.redirect", "trusted.overlay.metacopy"} {
+		if _, err := unix.Lgetxattr(dir, name, make([]byte, 64)); err == nil {
+			t.Fatalf("xattr %s from the layer should be dropped", name)
+		}
+	}
+	value := make([]byte, 64)
+	if n, err := unix.Lgetxattr(dir, "user.mycontainer.applytest", value); err == nil && string(value[:n]) != "ok" {
+		t.Fatalf("unexpected user xattr %q", value[:n])
+	} else if err != nil && err != unix.ENOTSUP {
+		t.Fatalf("user xattr should be kept: %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxMetadataSize 第一遍遍历时读入内存的文件大小上限，manifest、index 和镜像配置都远小于这个大小
+	maxMetadataSize = 4 << 20
+	ociLayoutFile   = "oci-layout"
+	ociIndexFile    = "index.json"
+	dockerManifest  = "manifest.json"
+	// maxLinkDepth 解析 tar 包中链接时最多跟随的次数
+	maxLinkDepth = 16
+)
+
+// ArchiveManifest docker save 生成的镜像 tar 包中 manifest.json 的一项
+type ArchiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// LoadedImage load 导入的一个镜像
+type LoadedImage struct {
+	Digest string   // manifest digest
+	Tags   []string // 镜像 tar 包中记录的镜像名称
+}
+
+// importLegacy 导入手动放在 images 目录下的镜像 tar 包，e.g. busybox 对应 images/busybox.tar
+func importLegacy(ref string) (string, error) {
+	name := ref
+	if repo, tag := SplitReference(ref); tag == defaultTag {
+		name = repo
+	}
+	archivePath := utils.GetImage(name)
+	if exists, _ := utils.PathExists(archivePath); !exists {
+		return "", errors.WithMessage(ErrImageNotFound, ref)
+	}
+	log.Infof("Importing image %s from %s", ref, archivePath)
+	loaded, err := Load(archivePath)
+	if err != nil {
+		return "", err
+	}
+	if err = Tag(ref, loaded[0].Digest); err != nil {
+		return "", err
+	}
+	return loaded[0].Digest, nil
+}
+
+// archive 第一遍遍历镜像 tar 包得到的内容
+type archive struct {
+	path  string
+	files map[string][]byte // 不超过 maxMetadataSize 的文件内容
+	names map[string]bool   // 所有普通文件的名称
+	links map[string]string // 符号链接和硬链接的名称对应的目标
+}
+
+// Load 导入镜像 tar 包中的所有镜像，支持 docker save 生成的格式和 OCI image layout，返回导入的镜像
+/*
+1.第一遍遍历 tar 包，将较小的文件读入内存，根据是否有 oci-layout 文件判断格式
+2.解析 manifest 后，第二遍只将需要的层写入镜像仓库，并校验层的 digest
+3.镜像 tar 包本身和其中的层都可以是未压缩、gzip 或 zstd 格式
+4.tar 包中没有记录镜像名称时以 tar 包的文件名作为镜像名称
+5.docker save 用符号链接或硬链接去重多个镜像共享的层，链接解析为 tar 包中的普通文件
+*/
+func Load(archivePath string) ([]LoadedImage, error) {
+	a := &archive{path: archivePath, files: make(map[string][]byte), names: make(map[string]bool), links: make(map[string]string)}
+	err := walkArchive(archivePath, func(hdr *tar.Header, r io.Reader) error {
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg {
+			a.links[name] = linkTarget(hdr)
+			return nil
+		}
+		a.names[name] = true
+		if hdr.Size > maxMetadataSize {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		a.files[name] = data
+		return errors.WithMessagef(err, "read %s failed", name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case a.names[ociLayoutFile] && a.names[ociIndexFile]:
+		return a.loadOCILayout()
+	case a.names[dockerManifest]:
+		return a.loadDockerArchive()
+	default:
+		return nil, errors.Errorf("unknown image archive %s, expect docker save or OCI image layout format", archivePath)
+	}
+}
+
+// defaultName 以 tar 包的文件名作为镜像名称，e.g. /tmp/busybox.tar.gz 对应 busybox:latest
+func (a *archive) defaultName() string {
+	name := strings.ToLower(path.Base(a.path))
+	for _, ext := range []string{".gz", ".tgz", ".zst", ".tar"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return NormalizeReference(name)
+}
+
+// resolve 将链接解析为 tar 包中的普通文件
+func (a *archive) resolve(name string) (string, error) {
+	for i := 0; i < maxLinkDepth; i++ {
+		if a.names[name] {
+			return name, nil
+		}
+		target, ok := a.links[name]
+		if !ok {
+			return "", errors.Errorf("%s not found in image %s", name, a.path)
+		}
+		name = target
+	}
+	return "", errors.Errorf("too many levels of links for %s in image %s", name, a.path)
+}
+
+// writeBlobs 将 tar 包中的文件作为 blob 写入镜像仓库，expected 为文件名对应的期望 digest，为空时不校验
+/*
+多个文件名可能是指向同一个普通文件的链接，普通文件只写入一次，
+得到的 descriptor 对应到每个文件名，期望的 digest 不同时报错
+*/
+func (a *archive) writeBlobs(expected map[string]string) (map[string]Descriptor, error) {
+	targets := make(map[string][]string)
+	for name := range expected {
+		target, err := a.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		targets[target] = append(targets[target], name)
+	}
+	descriptors := make(map[string]Descriptor, len(expected))
+	written := make(map[string]bool, len(targets))
+	write := func(target string, r io.Reader) error {
+		names := targets[target]
+		reader := bufio.NewReader(r)
+		magic, _ := reader.Peek(len(zstdMagic))
+		digest, size, err := WriteBlob(reader, expected[names[0]])
+		if err != nil {
+			return errors.WithMessagef(err, "import %s failed", names[0])
+		}
+		for _, name := range names {
+			if expected[name] != "" && expected[name] != digest {
+				return errors.Errorf("import %s failed, content digest is %s, expect %s", name, digest, expected[name])
+			}
+			descriptors[name] = Descriptor{MediaType: layerMediaType(magic), Digest: digest, Size: size}
+		}
+		written[target] = true
+		return nil
+	}
+	streamed := false
+	for target := range targets {
+		if data, ok := a.files[target]; ok {
+			if err := write(target, bytes.NewReader(data)); err != nil {
+				return nil, err
+			}
+		} else {
+			streamed = true
+		}
+	}
+	if !streamed {
+		return descriptors, nil
+	}
+	// 较大的文件在第二遍遍历时写入镜像仓库
+	err := walkArchive(a.path, func(hdr *tar.Header, r io.Reader) error {
+		if _, ok := targets[hdr.Name]; !ok || hdr.Typeflag != tar.TypeReg || written[hdr.Name] {
+			return nil
+		}
+		return write(hdr.Name, r)
+	})
+	return descriptors, err
+}
+
+// loadDockerArchive 导入 docker save 生成的镜像 tar 包，manifest.json 中的每一项是一个镜像
+func (a *archive) loadDockerArchive() ([]LoadedImage, error) {
+	var manifests []ArchiveManifest
+	if err := json.Unmarshal(a.files[dockerManifest], &manifests); err != nil {
+		return nil, errors.WithMessage(err, "parse manifest.json failed")
+	}
+	if len(manifests) == 0 {
+		return nil, errors.Errorf("no manifests found in image %s", a.path)
+	}
+	layerNames := make(map[string]string)
+	for _, manifest := range manifests {
+		for _, layer := range manifest.Layers {
+			layerNames[path.Clean(layer)] = ""
+		}
+	}
+	descriptors, err := a.writeBlobs(layerNames)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make([]LoadedImage, 0, len(manifests))
+	for _, manifest := range manifests {
+		configName, err := a.resolve(path.Clean(manifest.Config))
+		if err != nil {
+			return nil, err
+		}
+		configData, ok := a.files[configName]
+		if !ok {
+			return nil, errors.Errorf("image config %s not found in image %s", manifest.Config, a.path)
+		}
+		layers := make([]Descriptor, 0, len(manifest.Layers))
+		for _, layer := range manifest.Layers {
+			layers = append(layers, descriptors[path.Clean(layer)])
+		}
+		tags := make([]string, 0, len(manifest.RepoTags))
+		for _, tag := range manifest.RepoTags {
+			tags = append(tags, FamiliarName(tag))
+		}
+		if len(tags) == 0 && len(manifests) == 1 {
+			tags = append(tags, a.defaultName())
+		}
+		img, err := writeManifest(configData, layers, tags...)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, LoadedImage{Digest: img.Digest, Tags: tags})
+	}
+	return loaded, nil
+}
+
+// loadOCILayout 导入 OCI image layout 格式的镜像 tar 包
+/*
+index.json 中的每一项是一个 manifest 或 image index，image index 中选择当前平台的 manifest，
+镜像名称取自 io.containerd.image.name 或 org.opencontainers.image.ref.name annotation，
+ref.name 只有 tag 时以 tar 包的文件名作为仓库名称
+*/
+func (a *archive) loadOCILayout() ([]LoadedImage, error) {
+	index := new(IndexManifest)
+	if err := json.Unmarshal(a.files[ociIndexFile], index); err != nil {
+		return nil, errors.WithMessage(err, "parse index.json failed")
+	}
+	if len(index.Manifests) == 0 {
+		return nil, errors.Errorf("no manifests found in image %s", a.path)
+	}
+	loaded := make([]LoadedImage, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		tag := a.ociName(desc.Annotations, len(index.Manifests) == 1)
+		manifestDesc, manifest, err := a.resolveManifest(desc)
+		if err != nil {
+			return nil, err
+		}
+		// manifest、镜像配置和层都按原样写入镜像仓库，保持 digest 不变
+		expected := map[string]string{
+			a.blobName(manifestDesc.Digest):    manifestDesc.Digest,
+			a.blobName(manifest.Config.Digest): manifest.Config.Digest,
+		}
+		for _, layer := range manifest.Layers {
+			expected[a.blobName(layer.Digest)] = layer.Digest
+		}
+		if _, err = a.writeBlobs(expected); err != nil {
+			return nil, err
+		}
+		var tags []string
+		if tag != "" {
+			if err = Tag(tag, manifestDesc.Digest); err != nil {
+				return nil, err
+			}
+			tags = append(tags, tag)
+		}
+		loaded = append(loaded, LoadedImage{Digest: manifestDesc.Digest, Tags: tags})
+	}
+	return loaded, nil
+}
+
+// ociName 根据 annotation 得到镜像名称，only 表示 tar 包中只有这一个镜像
+func (a *archive) ociName(annotations map[string]string, only bool) string {
+	if name := annotations[AnnotationContainerName]; name != "" {
+		return FamiliarName(NormalizeReference(name))
+	}
+	refName := annotations[AnnotationRefName]
+	// ref.name 中有冒号或斜杠时是完整的镜像名称，否则只是 tag
+	if strings.ContainsAny(refName, ":/") {
+		return FamiliarName(NormalizeReference(refName))
+	}
+	repo, _ := SplitReference(a.defaultName())
+	if refName != "" {
+		return repo + ":" + refName
+	}
+	if only {
+		return a.defaultName()
+	}
+	return ""
+}
+
+// blobName 返回 OCI image layout 中 blob 的文件名
+func (a *archive) blobName(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// readBlob 读取已经读入内存的 blob 并校验 digest
+func (a *archive) readBlob(digest string) ([]byte, error) {
+	if err := ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+	data, ok := a.files[a.blobName(digest)]
+	if !ok {
+		return nil, errors.Errorf("blob %s not found in image %s", digest, a.path)
+	}
+	if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); actual != digest {
+		return nil, errors.Errorf("blob %s is corrupted, content digest is %s", digest, actual)
+	}
+	return data, nil
+}
+
+// resolveManifest 解析 index.json 中的一项，是 image index 时选择当前平台的 manifest
+func (a *archive) resolveManifest(desc Descriptor) (Descriptor, *Manifest, error) {
+	data, err := a.readBlob(desc.Digest)
+	if err != nil {
+		return desc, nil, err
+	}
+	if IsIndex(desc.MediaType) {
+		index := new(IndexManifest)
+		if err = json.Unmarshal(data, index); err != nil {
+			return desc, nil, errors.WithMessagef(err, "parse image index %s failed", desc.Digest)
+		}
+		selected, err := SelectManifest(index, DefaultPlatform())
+		if err != nil {
+			return desc, nil, err
+		}
+		return a.resolveManifest(*selected)
+	}
+	manifest := new(Manifest)
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return desc, nil, errors.WithMessagef(err, "parse manifest %s failed", desc.Digest)
+	}
+	return desc, manifest, nil
+}
+
+// linkTarget 返回链接指向的文件在 tar 包中的名称
+/*
+符号链接的相对路径相对于链接所在的目录，硬链接的目标是 tar 包中的名称，
+都以 tar 包的根目录为 /，清理后的路径不会指向 tar 包之外
+*/
+func linkTarget(hdr *tar.Header) string {
+	target := hdr.Linkname
+	if hdr.Typeflag == tar.TypeSymlink && !path.IsAbs(target) {
+		target = path.Join(path.Dir(hdr.Name), target)
+	}
+	return strings.TrimPrefix(path.Join("/", target), "/")
+}
+
+// walkArchive 遍历 tar 包中的普通文件、符号链接和硬链接，hdr.Name 为清理后的名称
+func walkArchive(archivePath string, handle func(hdr *tar.Header, r io.Reader) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return errors.WithMessagef(err, "open image %s failed", archivePath)
+	}
+	defer file.Close()
+	// 整个 tar 包也可能经过 gzip 或 zstd 压缩
+	reader, err := decompress(file)
+	if err != nil {
+		return errors.WithMessagef(err, "open image %s failed", archivePath)
+	}
+	defer reader.Close()
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.WithMessagef(err, "read image %s failed", archivePath)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeLink {
+			continue
+		}
+		hdr.Name = path.Clean(hdr.Name)
+		if err = handle(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
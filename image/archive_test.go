@@ -0,0 +1,293 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/aspirshar/myContainer/config"
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/klauspost/compress/zstd"
+)
+
+// setupStore 使用临时目录作为镜像仓库
+func setupStore(t *testing.T) string {
+	root := t.TempDir()
+	config.ImagesPath = root + "/images/"
+	config.LayersPath = root + "/layers/"
+	os.MkdirAll(config.ImagesPath, 0755)
+	return root
+}
+
+// writeTar 按顺序将文件写入 tar 包
+func writeTar(t *testing.T, archivePath string, names []string, files map[string][]byte) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sha(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func zstdCompress(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// TestLoadOCILayout OCI image layout 中是多平台的 image index，层是 zstd 压缩的
+func TestLoadOCILayout(t *testing.T) {
+	root := setupStore(t)
+	files := make(map[string][]byte)
+	add := func(data []byte) string {
+		digest := sha(data)
+		files["blobs/"+digest[:6]+"/"+digest[7:]] = data
+		return digest
+	}
+	layer := zstdCompress(t, layerTar(t, "hello.txt", "hello", false))
+	configData := []byte(`{"architecture":"` + runtime.GOARCH + `","os":"linux","config":{"Cmd":["sh"]},"rootfs":{"type":"layers"}}`)
+	manifest, _ := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        Descriptor{MediaType: MediaTypeConfig, Digest: add(configData), Size: int64(len(configData))},
+		Layers:        []Descriptor{{MediaType: MediaTypeLayerZstd, Digest: add(layer), Size: int64(len(layer))}},
+	})
+	manifestDigest := add(manifest)
+	other, _ := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeManifest})
+	platformIndex, _ := json.Marshal(IndexManifest{SchemaVersion: 2, MediaType: MediaTypeIndex, Manifests: []Descriptor{
+		{MediaType: MediaTypeManifest, Digest: add(other), Size: int64(len(other)), Platform: &Platform{OS: "windows", Architecture: runtime.GOARCH}},
+		{MediaType: MediaTypeManifest, Digest: manifestDigest, Size: int64(len(manifest)), Platform: &Platform{OS: "linux", Architecture: runtime.GOARCH}},
+	}})
+	indexDigest := add(platformIndex)
+	index, _ := json.Marshal(IndexManifest{SchemaVersion: 2, Manifests: []Descriptor{
+		{MediaType: MediaTypeIndex, Digest: indexDigest, Size: int64(len(platformIndex)), Annotations: map[string]string{AnnotationRefName: "v1"}},
+	}})
+	files[ociLayoutFile] = []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	files[ociIndexFile] = index
+	names := []string{ociLayoutFile, ociIndexFile}
+	for name := range files {
+		if name != ociLayoutFile && name != ociIndexFile {
+			names = append(names, name)
+		}
+	}
+	archivePath := path.Join(root, "hello.tar")
+	writeTar(t, archivePath, names, files)
+
+	loaded, err := Load(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ref.name 只有 tag，仓库名称取自 tar 包的文件名
+	want := []LoadedImage{{Digest: manifestDigest, Tags: []string{"hello:v1"}}}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Fatalf("loaded %v, want %v", loaded, want)
+	}
+	layers, err := PrepareLayers("hello:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path.Join(utils.GetLayer(layers[0]), "hello.txt"))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected layer content %q %v", data, err)
+	}
+}
+
+// TestLoadDockerArchive docker save 格式的 tar 包中有两个镜像，整个 tar 包经过 gzip 压缩
+func TestLoadDockerArchive(t *testing.T) {
+	root := setupStore(t)
+	base := layerTar(t, "base.txt", "base", false)
+	manifest, _ := json.Marshal([]ArchiveManifest{
+		{Config: "a.json", RepoTags: []string{"docker.io/library/app:v1", "app:latest"}, Layers: []string{"base/layer.tar"}},
+		{Config: "b.json", RepoTags: []string{"registry:5000/tool:v2"}, Layers: []string{"base/layer.tar", "tool/layer.tar"}},
+	})
+	files := map[string][]byte{
+		"base/layer.tar": base,
+		"tool/layer.tar": layerTar(t, "tool.txt", "tool", true),
+		"a.json":         []byte(`{"config":{}}`),
+		"b.json":         []byte(`{"config":{"Cmd":["tool"]}}`),
+		"manifest.json":  manifest,
+	}
+	archivePath := path.Join(root, "apps.tar")
+	writeTar(t, archivePath, []string{"base/layer.tar", "tool/layer.tar", "a.json", "b.json", "manifest.json"}, files)
+	data, _ := os.ReadFile(archivePath)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	os.WriteFile(archivePath+".gz", gz.Bytes(), 0644)
+
+	loaded, err := Load(archivePath + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || !reflect.DeepEqual(loaded[0].Tags, []string{"app:v1", "app:latest"}) || !reflect.DeepEqual(loaded[1].Tags, []string{"registry:5000/tool:v2"}) {
+		t.Fatalf("unexpected loaded images %v", loaded)
+	}
+	tool, err := Get("registry:5000/tool:v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tool.Manifest.Layers) != 2 || tool.Manifest.Layers[0].MediaType != MediaTypeLayer || tool.Manifest.Layers[1].MediaType != MediaTypeLayerGzip {
+		t.Fatalf("unexpected layers %v", tool.Manifest.Layers)
+	}
+	app, err := Get("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Manifest.Layers[0].Digest != tool.Manifest.Layers[0].Digest {
+		t.Fatal("shared layer stored twice")
+	}
+}
+
+// TestLoadDockerArchiveLinkedLayers docker save 用符号链接和硬链接去重共享的层，链接不能指向 tar 包之外
+func TestLoadDockerArchiveLinkedLayers(t *testing.T) {
+	root := setupStore(t)
+	base := layerTar(t, "base.txt", "base", false)
+	manifest, _ := json.Marshal([]ArchiveManifest{
+		{Config: "a.json", RepoTags: []string{"app:v1"}, Layers: []string{"aaa/layer.tar"}},
+		{Config: "b.json", RepoTags: []string{"tool:v1"}, Layers: []string{"bbb/layer.tar"}},
+		{Config: "c.json", RepoTags: []string{"other:v1"}, Layers: []string{"ccc/layer.tar"}},
+	})
+	entries := []tar.Header{
+		{Name: "aaa/layer.tar", Typeflag: tar.TypeReg, Size: int64(len(base))},
+		{Name: "bbb/layer.tar", Typeflag: tar.TypeSymlink, Linkname: "../aaa/layer.tar"},
+		{Name: "ccc/layer.tar", Typeflag: tar.TypeLink, Linkname: "aaa/layer.tar"},
+		{Name: "a.json", Typeflag: tar.TypeReg, Size: 13},
+		{Name: "b.json", Typeflag: tar.TypeSymlink, Linkname: "a.json"},
+		{Name: "c.json", Typeflag: tar.TypeReg, Size: 13},
+		{Name: "manifest.json", Typeflag: tar.TypeReg, Size: int64(len(manifest))},
+	}
+	contents := map[string][]byte{"aaa/layer.tar": base, "a.json": []byte(`{"config":{}}`), "c.json": []byte(`{"config":{}}`), "manifest.json": manifest}
+	writeLinkedTar := func(archivePath string, entries []tar.Header) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for i := range entries {
+			entries[i].Mode = 0644
+			if err := tw.WriteHeader(&entries[i]); err != nil {
+				t.Fatal(err)
+			}
+			tw.Write(contents[entries[i].Name])
+		}
+		tw.Close()
+		if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archivePath := path.Join(root, "linked.tar")
+	writeLinkedTar(archivePath, entries)
+	loaded, err := Load(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("unexpected loaded images %v", loaded)
+	}
+	app, err := Get("app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tool:v1", "other:v1"} {
+		img, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(img.Manifest.Layers, app.Manifest.Layers) {
+			t.Fatalf("%s layers %v, want %v", name, img.Manifest.Layers, app.Manifest.Layers)
+		}
+	}
+
+	// 链接中超出根目录的 .. 停在 tar 包的根目录，不会指向 tar 包之外的文件
+	entries[1].Linkname = "../../aaa/layer.tar"
+	entries[2].Linkname = "/../aaa/layer.tar"
+	writeLinkedTar(archivePath, entries)
+	if _, err = Load(archivePath); err != nil {
+		t.Fatalf("links confined to the archive should resolve, got %v", err)
+	}
+	entries[1].Linkname = "../../../etc/passwd"
+	writeLinkedTar(archivePath, entries)
+	if _, err = Load(archivePath); err == nil {
+		t.Fatal("expect error for link outside the archive")
+	}
+}
+
+// TestSaveLoad 导出后删除镜像，再导入得到相同的镜像
+func TestSaveLoad(t *testing.T) {
+	for _, format := range []string{FormatDocker, FormatOCI} {
+		t.Run(format, func(t *testing.T) {
+			root := setupStore(t)
+			layer, diffID, err := WriteLayer(bytes.NewReader(layerTar(t, "a.txt", "a", false)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			configFile := NewConfigFile()
+			configFile.RootFS.DiffIDs = []string{diffID}
+			img, err := WriteImage(configFile, []Descriptor{layer}, "app:v1", "app:v2")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			archivePath := path.Join(root, "app.tar")
+			file, err := os.Create(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 按镜像ID导出时包含镜像的所有名称
+			if err = Save(file, []string{shortHex(img.ID())}, format); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+			if _, _, err = Delete(img.Digest, false); err != nil {
+				t.Fatal(err)
+			}
+			if BlobExists(layer.Digest) {
+				t.Fatal("layer not deleted")
+			}
+
+			loaded, err := Load(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tags []string
+			for _, l := range loaded {
+				tags = append(tags, l.Tags...)
+			}
+			if !reflect.DeepEqual(tags, []string{"app:v1", "app:v2"}) {
+				t.Fatalf("unexpected tags %v", tags)
+			}
+			again, err := Get("app:v2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.ID() != img.ID() || again.Manifest.Layers[0].Digest != layer.Digest {
+				t.Fatalf("image changed after save and load: %s %s", again.ID(), img.ID())
+			}
+		})
+	}
+}
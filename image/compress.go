@@ -0,0 +1,47 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// layerMediaType 根据文件头判断层的压缩格式
+func layerMediaType(magic []byte) string {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return MediaTypeLayerGzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return MediaTypeLayerZstd
+	default:
+		return MediaTypeLayer
+	}
+}
+
+// decompress 根据文件头判断 gzip、zstd 压缩格式并返回解压后的内容，未压缩时原样返回
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	reader := bufio.NewReader(r)
+	magic, _ := reader.Peek(len(zstdMagic))
+	switch layerMediaType(magic) {
+	case MediaTypeLayerGzip:
+		gz, err := gzip.NewReader(reader)
+		return gz, errors.WithMessage(err, "open gzip stream failed")
+	case MediaTypeLayerZstd:
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, errors.WithMessage(err, "open zstd stream failed")
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(reader), nil
+	}
+}
@@ -0,0 +1,188 @@
+package image
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// OCI 层中表示删除的文件名前缀，.wh..wh..opq 表示目录被替换，下层目录中的内容都不可见
+const (
+	WhiteoutPrefix = ".wh."
+	WhiteoutOpaque = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+)
+
+// overlay 在 upper 目录中用 trusted.overlay.opaque=y 标记被替换的目录，无特权挂载时使用 user.overlay.* xattr
+var overlayXattrPrefixes = []string{"trusted.overlay.", "user.overlay."}
+
+// WriteDiff 将 overlay 的 upper 目录打包为 OCI 层的 tar 流，写入 w
+/*
+1.upper 目录中记录的是容器相对于下层的修改，打包后作为新的一层叠加在原有的层之上
+2.overlay 用 0/0 字符设备表示删除的文件，转换为同目录下的 .wh.<name> 空文件
+3.带有 opaque xattr 的目录在目录项之后增加 .wh..wh..opq 文件
+4.保留文件的属主、权限、修改时间和 xattr，同一 inode 的多个路径打包为硬链接
+*/
+func WriteDiff(w io.Writer, upperDir string) error {
+	tw := tar.NewWriter(w)
+	// 已经打包的 inode，再次遇到时作为硬链接指向第一次打包的路径
+	inodes := make(map[uint64]string)
+	err := filepath.WalkDir(upperDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(upperDir, filePath)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return errors.Errorf("unsupported file %s", filePath)
+		}
+		if info.Mode()&fs.ModeCharDevice != 0 && stat.Rdev == 0 {
+			whiteout := path.Join(path.Dir(rel), WhiteoutPrefix+path.Base(rel))
+			return writeWhiteout(tw, whiteout, info.ModTime())
+		}
+
+		hdr, err := diffHeader(filePath, rel, info, stat)
+		if err != nil || hdr == nil {
+			return err
+		}
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			if target, ok := inodes[stat.Ino]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, target, 0
+			} else {
+				inodes[stat.Ino] = hdr.Name
+			}
+		}
+		opaque := false
+		for key, value := range hdr.PAXRecords {
+			name := strings.TrimPrefix(key, "SCHILY.xattr.")
+			for _, prefix := range overlayXattrPrefixes {
+				if strings.HasPrefix(name, prefix) {
+					// overlay 自己使用的 xattr 不属于文件内容
+					opaque = opaque || (strings.HasSuffix(name, ".opaque") && value == "y")
+					delete(hdr.PAXRecords, key)
+				}
+			}
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return errors.WithMessagef(err, "write header of %s failed", rel)
+		}
+		if opaque {
+			if err = writeWhiteout(tw, path.Join(rel, WhiteoutOpaque), info.ModTime()); err != nil {
+				return err
+			}
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return errors.WithMessagef(err, "write %s failed", rel)
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "archive %s failed", upperDir)
+	}
+	return errors.WithMessage(tw.Close(), "write layer failed")
+}
+
+// diffHeader 根据文件信息生成 tar 头，不查询用户名，只记录数字形式的 uid、gid，不能打包的文件返回 nil
+func diffHeader(filePath, rel string, info fs.FileInfo, stat *syscall.Stat_t) (*tar.Header, error) {
+	hdr := &tar.Header{
+		Name:    rel,
+		Mode:    int64(stat.Mode & 07777),
+		Uid:     int(stat.Uid),
+		Gid:     int(stat.Gid),
+		ModTime: info.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		hdr.Typeflag, hdr.Size = tar.TypeReg, info.Size()
+	case mode.IsDir():
+		hdr.Typeflag, hdr.Name = tar.TypeDir, rel+"/"
+	case mode&fs.ModeSymlink != 0:
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return nil, err
+		}
+		hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, target
+	case mode&fs.ModeCharDevice != 0:
+		hdr.Typeflag = tar.TypeChar
+		hdr.Devmajor, hdr.Devminor = int64(unix.Major(stat.Rdev)), int64(unix.Minor(stat.Rdev))
+	case mode&fs.ModeDevice != 0:
+		hdr.Typeflag = tar.TypeBlock
+		hdr.Devmajor, hdr.Devminor = int64(unix.Major(stat.Rdev)), int64(unix.Minor(stat.Rdev))
+	case mode&fs.ModeNamedPipe != 0:
+		hdr.Typeflag = tar.TypeFifo
+	default:
+		// socket 不能打包，与 docker 一致直接跳过
+		return nil, nil
+	}
+	xattrs, err := readXattrs(filePath)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords["SCHILY.xattr."+name] = value
+	}
+	return hdr, nil
+}
+
+// readXattrs 读取文件（不跟随符号链接）的所有扩展属性，文件系统不支持 xattr 时返回空
+func readXattrs(filePath string) (map[string]string, error) {
+	size, err := unix.Llistxattr(filePath, nil)
+	if err == unix.ENOTSUP {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "list xattrs of %s failed", filePath)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(filePath, buf); err != nil {
+		return nil, errors.WithMessagef(err, "list xattrs of %s failed", filePath)
+	}
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		valueSize, err := unix.Lgetxattr(filePath, name, nil)
+		if err != nil {
+			// 没有权限读取的 xattr（如非 root 读取 trusted.*）直接跳过
+			continue
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = unix.Lgetxattr(filePath, name, value); err != nil {
+			continue
+		}
+		xattrs[name] = string(value[:valueSize])
+	}
+	return xattrs, nil
+}
+
+// writeWhiteout 写入表示删除的空文件
+func writeWhiteout(tw *tar.Writer, name string, modTime time.Time) error {
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0600, ModTime: modTime, Format: tar.FormatPAX}
+	return errors.WithMessagef(tw.WriteHeader(hdr), "write whiteout %s failed", name)
+}
@@ -0,0 +1,65 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWriteDiff(t *testing.T) {
+	upper := t.TempDir()
+	os.MkdirAll(path.Join(upper, "etc"), 0755)
+	os.WriteFile(path.Join(upper, "etc", "hosts"), []byte("127.0.0.1"), 0644)
+	os.Link(path.Join(upper, "etc", "hosts"), path.Join(upper, "etc", "hosts.bak"))
+	os.Symlink("hosts", path.Join(upper, "etc", "hosts.link"))
+	os.MkdirAll(path.Join(upper, "var", "cache"), 0755)
+	// overlay 的 whiteout 和 opaque 目录需要 root 权限才能创建
+	if err := syscall.Mknod(path.Join(upper, "etc", "passwd"), syscall.S_IFCHR, 0); err != nil {
+		t.Skipf("mknod whiteout: %v", err)
+	}
+	if err := unix.Setxattr(path.Join(upper, "var", "cache"), "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("set opaque xattr: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDiff(&buf, upper); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		switch hdr.Name {
+		case "etc/hosts.bak":
+			if hdr.Typeflag != tar.TypeLink || hdr.Linkname != "etc/hosts" {
+				t.Fatalf("expect hard link to etc/hosts, got %c %s", hdr.Typeflag, hdr.Linkname)
+			}
+		case "etc/hosts.link":
+			if hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "hosts" {
+				t.Fatalf("expect symlink to hosts, got %c %s", hdr.Typeflag, hdr.Linkname)
+			}
+		case "var/cache/":
+			if _, ok := hdr.PAXRecords["SCHILY.xattr.trusted.overlay.opaque"]; ok {
+				t.Fatal("overlay xattr should not be archived")
+			}
+		}
+	}
+	want := []string{"etc/", "etc/hosts", "etc/hosts.bak", "etc/hosts.link", "etc/.wh.passwd", "var/", "var/cache/", "var/cache/.wh..wh..opq"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected entries %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,266 @@
+package image
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// OCI 镜像规范和 docker 镜像规范中的 mediaType
+const (
+	MediaTypeIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeConfig    = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer     = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeLayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	DockerMediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	DockerMediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// OCI image layout 中 index.json 的 annotation，记录 manifest 对应的镜像名称
+const (
+	AnnotationRefName       = "org.opencontainers.image.ref.name"
+	AnnotationContainerName = "io.containerd.image.name"
+)
+
+// Descriptor 指向一个 blob
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"` // 只在 index 中使用
+}
+
+// Platform 镜像适用的平台
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// IndexManifest OCI image index 或 docker manifest list，列出多个平台的 manifest
+type IndexManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// IsIndex 判断 mediaType 是否为 image index 或 manifest list
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeIndex || mediaType == DockerMediaTypeManifestList
+}
+
+// DefaultPlatform 返回当前平台，即 linux 和当前 CPU 架构
+func DefaultPlatform() Platform {
+	return Platform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// ParsePlatform 解析 os/arch[/variant] 形式的平台，e.g. linux/arm64/v8
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, errors.Errorf("invalid platform %s, expect os/arch[/variant]", s)
+	}
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// String 返回 os/arch[/variant] 形式的平台
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// Match 判断镜像的平台 p 是否满足要求的平台 want，没有要求 variant 时匹配任意 variant
+func (p Platform) Match(want Platform) bool {
+	if p.OS != want.OS || p.Architecture != want.Architecture {
+		return false
+	}
+	// arm64 只有 v8 一个 variant，有的镜像不写 variant
+	variant, wantVariant := p.Variant, want.Variant
+	if p.Architecture == "arm64" {
+		variant, wantVariant = strings.TrimPrefix(variant, "v8"), strings.TrimPrefix(wantVariant, "v8")
+	}
+	return wantVariant == "" || variant == wantVariant
+}
+
+// SelectManifest 从 index 中选出适用于指定平台的 manifest
+func SelectManifest(index *IndexManifest, platform Platform) (*Descriptor, error) {
+	for i, desc := range index.Manifests {
+		// 没有 platform 的 manifest 适用于所有平台，unknown/unknown 是构建证明，不是镜像
+		if desc.Platform == nil {
+			if len(index.Manifests) == 1 {
+				return &index.Manifests[i], nil
+			}
+			continue
+		}
+		if desc.Platform.Match(platform) {
+			return &index.Manifests[i], nil
+		}
+	}
+	return nil, errors.Errorf("no manifest for platform %s", platform)
+}
+
+// Manifest OCI 镜像 manifest，描述镜像的配置和各个层
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// RootFS 镜像各层解压后内容的 digest
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History 镜像每一步构建的记录
+type History struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"empty_layer,omitempty"`
+}
+
+// ConfigFile OCI 镜像配置，config 字段的内容由 container.ImageConfig 解析
+type ConfigFile struct {
+	Created      *time.Time      `json:"created,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       json.RawMessage `json:"config,omitempty"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+}
+
+// NewConfigFile 创建当前平台的镜像配置
+func NewConfigFile() *ConfigFile {
+	now := time.Now().UTC()
+	return &ConfigFile{Created: &now, Architecture: runtime.GOARCH, OS: "linux", RootFS: RootFS{Type: "layers"}}
+}
+
+// Image 镜像仓库中的一个镜像
+type Image struct {
+	Digest   string // manifest 的 digest
+	Manifest *Manifest
+	Config   *ConfigFile
+}
+
+// ID 返回镜像ID，与 docker 一致为镜像配置的 digest
+func (img *Image) ID() string {
+	return img.Manifest.Config.Digest
+}
+
+// Size 返回镜像各层的大小之和
+func (img *Image) Size() int64 {
+	var size int64
+	for _, layer := range img.Manifest.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// Blobs 返回镜像引用的所有 blob：manifest、镜像配置和各个层
+func (img *Image) Blobs() []string {
+	blobs := []string{img.Digest, img.Manifest.Config.Digest}
+	for _, layer := range img.Manifest.Layers {
+		blobs = append(blobs, layer.Digest)
+	}
+	return blobs
+}
+
+// Get 从镜像仓库读取镜像，镜像仓库中没有该镜像时尝试导入 images 目录下同名的镜像 tar 包
+func Get(ref string) (*Image, error) {
+	digest, err := Resolve(ref)
+	if errors.Is(err, ErrImageNotFound) {
+		if digest, err = importLegacy(ref); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return GetByDigest(digest)
+}
+
+// GetByDigest 根据 manifest digest 读取镜像，读取时校验 manifest 和配置的 digest
+func GetByDigest(digest string) (*Image, error) {
+	data, err := ReadBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(Manifest)
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.WithMessagef(err, "parse manifest %s failed", digest)
+	}
+	if data, err = ReadBlob(manifest.Config.Digest); err != nil {
+		return nil, err
+	}
+	configFile := new(ConfigFile)
+	if err = json.Unmarshal(data, configFile); err != nil {
+		return nil, errors.WithMessagef(err, "parse image config %s failed", manifest.Config.Digest)
+	}
+	return &Image{Digest: digest, Manifest: manifest, Config: configFile}, nil
+}
+
+// WriteImage 写入镜像配置和 manifest，并将 tags 指向新的镜像，层需要事先写入镜像仓库
+func WriteImage(configFile *ConfigFile, layers []Descriptor, tags ...string) (*Image, error) {
+	configData, err := json.Marshal(configFile)
+	if err != nil {
+		return nil, errors.WithMessage(err, "image config marshal failed")
+	}
+	img, err := writeManifest(configData, layers, tags...)
+	if err != nil {
+		return nil, err
+	}
+	img.Config = configFile
+	return img, nil
+}
+
+// writeManifest 写入原始的镜像配置和 manifest，保留镜像配置原有的内容，镜像ID不会改变
+func writeManifest(configData []byte, layers []Descriptor, tags ...string) (*Image, error) {
+	configDigest, err := WriteBlobBytes(configData)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        Descriptor{MediaType: MediaTypeConfig, Digest: configDigest, Size: int64(len(configData))},
+		Layers:        layers,
+	}
+	if manifest.Layers == nil {
+		manifest.Layers = []Descriptor{}
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, errors.WithMessage(err, "manifest marshal failed")
+	}
+	digest, err := WriteBlobBytes(manifestData)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		if err = Tag(tag, digest); err != nil {
+			return nil, err
+		}
+		log.Infof("Tagged image %s as %s", digest, NormalizeReference(tag))
+	}
+	return &Image{Digest: digest, Manifest: manifest}, nil
+}
@@ -0,0 +1,264 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/aspirshar/myContainer/constant"
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
+)
+
+const defaultTag = "latest"
+
+// ErrImageNotFound 镜像仓库中没有该镜像
+var ErrImageNotFound = errors.New("image not found")
+
+// Index 镜像仓库索引，记录 repo:tag 对应的 manifest digest
+type Index struct {
+	Tags map[string]string `json:"tags"`
+}
+
+// NormalizeReference 补全镜像名称中省略的 tag，e.g. busybox 对应 busybox:latest，repo@sha256:... 保持不变
+func NormalizeReference(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	// 冒号出现在最后一个斜杠之后时才是 tag，registry:5000/busybox 中的冒号是端口
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":" + defaultTag
+}
+
+// FamiliarName 去掉 docker hub 的默认前缀，e.g. docker.io/library/busybox:latest 对应 busybox:latest
+func FamiliarName(ref string) string {
+	for _, prefix := range []string{"docker.io/library/", "index.docker.io/library/", "docker.io/", "index.docker.io/"} {
+		if strings.HasPrefix(ref, prefix) {
+			return strings.TrimPrefix(ref, prefix)
+		}
+	}
+	return ref
+}
+
+// SplitReference 将 repo:tag 拆分为 repo 和 tag
+func SplitReference(ref string) (string, string) {
+	ref = NormalizeReference(ref)
+	if repo, digest, ok := strings.Cut(ref, "@"); ok {
+		return repo, digest
+	}
+	i := strings.LastIndex(ref, ":")
+	return ref[:i], ref[i+1:]
+}
+
+// LoadIndex 读取镜像仓库索引，索引文件不存在时返回空索引
+func LoadIndex() (*Index, error) {
+	index := &Index{Tags: make(map[string]string)}
+	data, err := os.ReadFile(utils.GetImageIndex())
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "read image index failed")
+	}
+	if err = json.Unmarshal(data, index); err != nil {
+		return nil, errors.WithMessage(err, "parse image index failed")
+	}
+	if index.Tags == nil {
+		index.Tags = make(map[string]string)
+	}
+	return index, nil
+}
+
+// UpdateIndex 在文件锁的保护下修改镜像仓库索引，多个进程同时打 tag 时不会互相覆盖
+func UpdateIndex(update func(index *Index) error) error {
+	indexPath := utils.GetImageIndex()
+	if err := os.MkdirAll(path.Dir(indexPath), constant.Perm0755); err != nil {
+		return errors.WithMessagef(err, "mkdir %s failed", path.Dir(indexPath))
+	}
+	lock, err := os.OpenFile(indexPath+".lock", os.O_CREATE|os.O_RDWR, constant.Perm0644)
+	if err != nil {
+		return errors.WithMessage(err, "open image index lock failed")
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return errors.WithMessage(err, "lock image index failed")
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	index, err := LoadIndex()
+	if err != nil {
+		return err
+	}
+	if err = update(index); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(index, "", "    ")
+	if err != nil {
+		return errors.WithMessage(err, "image index marshal failed")
+	}
+	tmpPath := fmt.Sprintf("%s.%d.tmp", indexPath, os.Getpid())
+	if err = os.WriteFile(tmpPath, data, constant.Perm0644); err != nil {
+		return errors.WithMessage(err, "write image index failed")
+	}
+	return errors.WithMessage(os.Rename(tmpPath, indexPath), "rename image index failed")
+}
+
+// Tag 将 ref 指向 manifest digest，已有的 tag 会被覆盖
+func Tag(ref, digest string) error {
+	if !BlobExists(digest) {
+		return errors.Errorf("manifest %s not found in image store", digest)
+	}
+	ref = NormalizeReference(ref)
+	if strings.Contains(ref, "@") {
+		return errors.Errorf("invalid tag %s", ref)
+	}
+	return UpdateIndex(func(index *Index) error {
+		index.Tags[ref] = digest
+		return nil
+	})
+}
+
+// Resolve 返回镜像对应的 manifest digest，支持 repo:tag、repo@sha256:...、manifest digest 和镜像ID（前缀）
+func Resolve(ref string) (string, error) {
+	if _, digest, ok := strings.Cut(ref, "@"); ok {
+		if !BlobExists(digest) {
+			return "", errors.WithMessage(ErrImageNotFound, ref)
+		}
+		return digest, nil
+	}
+	index, err := LoadIndex()
+	if err != nil {
+		return "", err
+	}
+	if digest, ok := index.Tags[NormalizeReference(ref)]; ok {
+		return digest, nil
+	}
+	// 镜像ID也是 sha256:... 形式，只有索引中的 manifest digest 才按 digest 查找
+	for _, digest := range index.Digests() {
+		if digest == ref {
+			return digest, nil
+		}
+	}
+	return resolveID(index, ref)
+}
+
+// resolveID 按镜像ID或其前缀查找镜像，e.g. images 中显示的 12 位镜像ID
+func resolveID(index *Index, ref string) (string, error) {
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	if len(prefix) < 4 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", errors.WithMessage(ErrImageNotFound, ref)
+	}
+	found := ""
+	for _, digest := range index.Digests() {
+		img, err := GetByDigest(digest)
+		if err != nil || !strings.HasPrefix(img.ID(), "sha256:"+prefix) {
+			continue
+		}
+		if found != "" {
+			return "", errors.Errorf("image id %s is ambiguous", ref)
+		}
+		found = digest
+	}
+	if found == "" {
+		return "", errors.WithMessage(ErrImageNotFound, ref)
+	}
+	return found, nil
+}
+
+// Untag 删除一个 tag，返回 tag 原来指向的 manifest digest
+func Untag(ref string) (string, error) {
+	ref = NormalizeReference(ref)
+	var digest string
+	err := UpdateIndex(func(index *Index) error {
+		var ok bool
+		if digest, ok = index.Tags[ref]; !ok {
+			return errors.WithMessage(ErrImageNotFound, ref)
+		}
+		delete(index.Tags, ref)
+		return nil
+	})
+	return digest, err
+}
+
+// Delete 删除指向镜像的所有 tag，以及不再被其他镜像引用的 manifest、镜像配置和层，返回删除的 tag 和 blob
+/*
+keepLayers 为 true 时保留解压后的层目录，已经停止但还没有删除的容器仍然以这些层作为 lower
+*/
+func Delete(digest string, keepLayers bool) ([]string, []string, error) {
+	img, err := GetByDigest(digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	var untagged, deleted []string
+	err = UpdateIndex(func(index *Index) error {
+		untagged = index.TagsOf(digest)
+		for _, tag := range untagged {
+			delete(index.Tags, tag)
+		}
+		// 其他镜像仍然引用的 blob 不能删除，包括通过 repo@sha256:... 拉取的镜像和构建的中间镜像这些没有 tag 的镜像
+		manifests, err := storedManifests()
+		if err != nil {
+			return err
+		}
+		referenced := make(map[string]bool)
+		for other, manifest := range manifests {
+			if other == digest {
+				continue
+			}
+			referenced[other] = true
+			referenced[manifest.Config.Digest] = true
+			for _, layer := range manifest.Layers {
+				referenced[layer.Digest] = true
+			}
+		}
+		for _, blob := range img.Blobs() {
+			if referenced[blob] {
+				continue
+			}
+			if err := os.Remove(utils.GetBlob(blob)); err != nil && !os.IsNotExist(err) {
+				return errors.WithMessagef(err, "remove blob %s failed", blob)
+			}
+			deleted = append(deleted, blob)
+			if keepLayers {
+				continue
+			}
+			if err := os.RemoveAll(utils.GetLayer(blob)); err != nil {
+				return errors.WithMessagef(err, "remove layer %s failed", blob)
+			}
+		}
+		return nil
+	})
+	return untagged, deleted, err
+}
+
+// Digests 返回索引中所有镜像的 manifest digest，已排序并去重
+func (index *Index) Digests() []string {
+	seen := make(map[string]bool)
+	var digests []string
+	for _, digest := range index.Tags {
+		if !seen[digest] {
+			seen[digest] = true
+			digests = append(digests, digest)
+		}
+	}
+	sort.Strings(digests)
+	return digests
+}
+
+// TagsOf 返回指向 manifest digest 的所有 tag
+func (index *Index) TagsOf(digest string) []string {
+	var tags []string
+	for ref, d := range index.Tags {
+		if d == digest {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
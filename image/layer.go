@@ -0,0 +1,134 @@
+package image
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/aspirshar/myContainer/constant"
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxParallelExtract 同时解压的层数上限
+const maxParallelExtract = 4
+
+// PrepareLayers 准备镜像的所有层，返回从底层到顶层排列的层 digest
+/*
+1.层的 digest 即为镜像仓库中层 blob 的 digest
+2.每一层只解压一次，解压到 layers/sha256/<digest> 目录，不同镜像中相同的层共享同一个目录
+3.解压时校验层 blob 的 digest，先解压到临时目录再重命名，多个容器同时启动时不会看到解压了一半的层
+4.每一层解压到单独的目录，互不依赖，尚未解压的层并行解压
+*/
+func PrepareLayers(imageName string) ([]string, error) {
+	img, err := Get(imageName)
+	if err != nil {
+		return nil, err
+	}
+	if len(img.Manifest.Layers) == 0 {
+		return nil, errors.Errorf("no layers found in image %s", imageName)
+	}
+	layers := make([]string, 0, len(img.Manifest.Layers))
+	var missing []string
+	for _, layer := range img.Manifest.Layers {
+		exists, err := utils.PathExists(utils.GetLayer(layer.Digest))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "check layer %s failed", layer.Digest)
+		}
+		if !exists && !slices.Contains(missing, layer.Digest) {
+			missing = append(missing, layer.Digest)
+		}
+		layers = append(layers, layer.Digest)
+	}
+
+	errs := make([]error, len(missing))
+	sem := make(chan struct{}, maxParallelExtract)
+	var wg sync.WaitGroup
+	for i, digest := range missing {
+		wg.Add(1)
+		go func(i int, digest string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			log.Infof("Extracting layer %s to %s", digest, utils.GetLayer(digest))
+			errs[i] = extractLayer(digest)
+		}(i, digest)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return layers, nil
+}
+
+// extractLayer 将镜像仓库中的一层解压到层目录，层可能经过 gzip 或 zstd 压缩
+func extractLayer(digest string) error {
+	blob, err := OpenBlob(digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	layerPath := utils.GetLayer(digest)
+	if err := os.MkdirAll(path.Dir(layerPath), constant.Perm0755); err != nil {
+		return errors.WithMessagef(err, "mkdir %s failed", path.Dir(layerPath))
+	}
+	tmpPath, err := os.MkdirTemp(path.Dir(layerPath), path.Base(layerPath)+".tmp-")
+	if err != nil {
+		return errors.WithMessage(err, "create temp layer dir failed")
+	}
+	defer os.RemoveAll(tmpPath)
+	// MkdirTemp 创建的目录权限为 0700，层的根目录需要与普通根目录相同
+	if err = os.Chmod(tmpPath, constant.Perm0755); err != nil {
+		return errors.WithMessagef(err, "chmod %s failed", tmpPath)
+	}
+
+	reader := bufio.NewReader(blob)
+	layer, err := decompress(reader)
+	if err != nil {
+		return errors.WithMessagef(err, "open layer %s failed", digest)
+	}
+	defer layer.Close()
+	if err = ApplyLayer(tmpPath, layer); err != nil {
+		return errors.WithMessagef(err, "extract layer %s failed", digest)
+	}
+	// tar 归档结束标记之后可能还有填充的内容，读完剩余内容才能校验 digest
+	if _, err = io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+	// 其他进程已经解压了同一层时直接使用已有的目录
+	if err = os.Rename(tmpPath, layerPath); err != nil {
+		if exists, _ := utils.PathExists(layerPath); !exists {
+			return errors.WithMessagef(err, "rename layer %s failed", digest)
+		}
+	}
+	return nil
+}
+
+// WriteLayer 将未压缩的层 tar 流用 gzip 压缩后写入镜像仓库，返回层的描述和解压后内容的 digest（diff_id）
+func WriteLayer(r io.Reader) (Descriptor, string, error) {
+	pr, pw := io.Pipe()
+	diffHash := sha256.New()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, io.TeeReader(r, diffHash))
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	digest, size, err := WriteBlob(pr, "")
+	if err != nil {
+		pr.CloseWithError(err)
+		return Descriptor{}, "", errors.WithMessage(err, "write layer failed")
+	}
+	return Descriptor{MediaType: MediaTypeLayerGzip, Digest: digest, Size: size}, fmt.Sprintf("sha256:%x", diffHash.Sum(nil)), nil
+}
@@ -0,0 +1,120 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/aspirshar/myContainer/config"
+	"github.com/aspirshar/myContainer/utils"
+)
+
+// layerTar 生成只包含一个文件的层
+func layerTar(t *testing.T, name, content string, compress bool) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !compress {
+		return buf.Bytes()
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(buf.Bytes())
+	zw.Close()
+	return gz.Bytes()
+}
+
+// writeImage 按 docker save 的格式生成镜像 tar 包
+func writeImage(t *testing.T, imageName string, layers map[string][]byte, order []string) {
+	manifest, _ := json.Marshal([]ArchiveManifest{{Config: "config.json", RepoTags: []string{imageName + ":latest"}, Layers: order}})
+	file, err := os.Create(utils.GetImage(imageName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	tw := tar.NewWriter(file)
+	write := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range order {
+		write(name, layers[name])
+	}
+	write("config.json", []byte(`{"config":{}}`))
+	write("manifest.json", manifest)
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepareLayersShared(t *testing.T) {
+	root := t.TempDir()
+	config.ImagesPath = root + "/images/"
+	config.LayersPath = root + "/layers/"
+	os.MkdirAll(config.ImagesPath, 0755)
+
+	base := layerTar(t, "base.txt", "base", false)
+	writeImage(t, "app1", map[string][]byte{
+		"a/layer.tar": base,
+		"b/layer.tar": layerTar(t, "app1.txt", "app1", true),
+	}, []string{"a/layer.tar", "b/layer.tar"})
+	writeImage(t, "app2", map[string][]byte{
+		"blobs/sha256/x": base,
+		"blobs/sha256/y": layerTar(t, "app2.txt", "app2", false),
+	}, []string{"blobs/sha256/x", "blobs/sha256/y"})
+
+	layers1, err := PrepareLayers("app1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers2, err := PrepareLayers("app2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers1) != 2 || len(layers2) != 2 || layers1[0] != layers2[0] || layers1[1] == layers2[1] {
+		t.Fatalf("unexpected layers %v %v", layers1, layers2)
+	}
+	// 两个镜像共享底层，一共只有三个层目录
+	entries, err := os.ReadDir(path.Join(config.LayersPath, "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expect 3 layer dirs, got %d", len(entries))
+	}
+	for file, layer := range map[string]string{"base.txt": layers1[0], "app1.txt": layers1[1], "app2.txt": layers2[1]} {
+		if _, err := os.Stat(path.Join(utils.GetLayer(layer), file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 层已经解压过时不会重新解压
+	os.Remove(path.Join(utils.GetLayer(layers1[1]), "app1.txt"))
+	again, err := PrepareLayers("app1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, layers1) {
+		t.Fatalf("unexpected layers %v, want %v", again, layers1)
+	}
+	if _, err := os.Stat(path.Join(utils.GetLayer(layers1[1]), "app1.txt")); !os.IsNotExist(err) {
+		t.Fatal("layer extracted again")
+	}
+}
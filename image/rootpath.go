@@ -0,0 +1,71 @@
+package image
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxSymlinks 解析路径时最多跟随的符号链接数，与 Linux 的 ELOOP 限制一致
+const maxSymlinks = 40
+
+// ResolveInRoot 将 name 解析为 root 下的真实路径，把 root 当作根目录解析路径中的符号链接
+/*
+1.绝对路径的符号链接从 root 开始解析，.. 最多回到 root，不会跳出 root
+2.不存在的路径按原样拼接，之后创建的目录也在 root 之内
+3.followLast 为 false 时不跟随最后一级的符号链接，用于替换或硬链接符号链接本身
+*/
+func ResolveInRoot(root, name string, followLast bool) (string, error) {
+	// 未解析的路径，按顺序逐级处理
+	remaining := strings.Split(name, "/")
+	resolved := ""
+	links := 0
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			if resolved == "." || resolved == "/" {
+				resolved = ""
+			}
+			continue
+		}
+		next := path.Join(resolved, part)
+		if len(remaining) == 0 && !followLast {
+			resolved = next
+			break
+		}
+		info, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", errors.WithMessagef(err, "lstat %s failed", next)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", errors.Errorf("too many levels of symbolic links in %s", name)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", errors.WithMessagef(err, "readlink %s failed", next)
+		}
+		// 符号链接的目标替换当前这一级，绝对路径从 root 重新开始
+		if strings.HasPrefix(target, "/") {
+			resolved = ""
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return filepath.Join(root, resolved), nil
+}
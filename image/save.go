@@ -0,0 +1,193 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
+)
+
+// save 支持的镜像 tar 包格式
+const (
+	FormatDocker = "docker"
+	FormatOCI    = "oci"
+)
+
+// savedImage 要导出的一个镜像和它的镜像名称
+type savedImage struct {
+	*Image
+	tags []string
+}
+
+// Save 将镜像导出为 tar 包，format 为 docker 时与 docker save 的格式一致，为 oci 时是 OCI image layout
+/*
+1.ref 是镜像名称时只导出这一个名称，是镜像ID时导出镜像的所有名称
+2.所有 blob 都放在 blobs/sha256/ 下，多个镜像共享的层只写入一次
+3.从镜像仓库读取 blob 时校验 digest，镜像仓库损坏时导出失败
+*/
+func Save(w io.Writer, refs []string, format string) error {
+	if format != FormatDocker && format != FormatOCI {
+		return errors.Errorf("unsupported format %s, expect %s or %s", format, FormatDocker, FormatOCI)
+	}
+	images, err := collectImages(refs)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	written := make(map[string]bool)
+	for _, img := range images {
+		blobs := img.Blobs()
+		if format == FormatDocker {
+			// docker save 的格式不需要 manifest
+			blobs = blobs[1:]
+		}
+		for _, blob := range blobs {
+			if written[blob] {
+				continue
+			}
+			if err = writeBlobEntry(tw, blob); err != nil {
+				return err
+			}
+			written[blob] = true
+		}
+	}
+
+	if format == FormatDocker {
+		err = writeDockerManifest(tw, images)
+	} else {
+		err = writeOCIIndex(tw, images)
+	}
+	if err != nil {
+		return err
+	}
+	return errors.WithMessage(tw.Close(), "write image archive failed")
+}
+
+// collectImages 解析要导出的镜像，同一个镜像指定多次时合并镜像名称
+func collectImages(refs []string) ([]*savedImage, error) {
+	var images []*savedImage
+	byDigest := make(map[string]*savedImage)
+	for _, ref := range refs {
+		img, err := Get(ref)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "get image %s failed", ref)
+		}
+		// Get 可能导入了 images 目录下的镜像 tar 包，之后再读取索引
+		index, err := LoadIndex()
+		if err != nil {
+			return nil, err
+		}
+		tags := index.TagsOf(img.Digest)
+		if tag := NormalizeReference(ref); index.Tags[tag] == img.Digest {
+			tags = []string{tag}
+		}
+		saved, ok := byDigest[img.Digest]
+		if !ok {
+			saved = &savedImage{Image: img}
+			byDigest[img.Digest] = saved
+			images = append(images, saved)
+		}
+		for _, tag := range tags {
+			if !contains(saved.tags, tag) {
+				saved.tags = append(saved.tags, tag)
+			}
+		}
+	}
+	return images, nil
+}
+
+// contains 判断 list 中是否有 s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// blobPath 返回 blob 在导出的 tar 包中的路径
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// writeBlobEntry 将镜像仓库中的 blob 写入 tar 包
+func writeBlobEntry(tw *tar.Writer, digest string) error {
+	info, err := os.Stat(utils.GetBlob(digest))
+	if err != nil {
+		return errors.WithMessagef(err, "stat blob %s failed", digest)
+	}
+	reader, err := OpenBlob(digest)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	hdr := &tar.Header{Name: blobPath(digest), Mode: 0644, Size: info.Size(), ModTime: time.Unix(0, 0)}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return errors.WithMessagef(err, "write blob %s failed", digest)
+	}
+	_, err = io.Copy(tw, reader)
+	return errors.WithMessagef(err, "write blob %s failed", digest)
+}
+
+// writeFileEntry 将内存中的内容写入 tar 包
+func writeFileEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Unix(0, 0)}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.WithMessagef(err, "write %s failed", name)
+	}
+	_, err := tw.Write(data)
+	return errors.WithMessagef(err, "write %s failed", name)
+}
+
+// writeDockerManifest 写入 docker save 格式的 manifest.json
+func writeDockerManifest(tw *tar.Writer, images []*savedImage) error {
+	manifests := make([]ArchiveManifest, 0, len(images))
+	for _, img := range images {
+		manifest := ArchiveManifest{Config: blobPath(img.ID()), RepoTags: img.tags, Layers: []string{}}
+		for _, layer := range img.Manifest.Layers {
+			manifest.Layers = append(manifest.Layers, blobPath(layer.Digest))
+		}
+		manifests = append(manifests, manifest)
+	}
+	data, err := json.Marshal(manifests)
+	if err != nil {
+		return errors.WithMessage(err, "manifest.json marshal failed")
+	}
+	return writeFileEntry(tw, dockerManifest, data)
+}
+
+// writeOCIIndex 写入 OCI image layout 的 oci-layout 和 index.json，每个镜像名称对应 index.json 中的一项
+func writeOCIIndex(tw *tar.Writer, images []*savedImage) error {
+	index := IndexManifest{SchemaVersion: 2, MediaType: MediaTypeIndex, Manifests: []Descriptor{}}
+	for _, img := range images {
+		data, err := ReadBlob(img.Digest)
+		if err != nil {
+			return err
+		}
+		desc := Descriptor{MediaType: MediaTypeManifest, Digest: img.Digest, Size: int64(len(data))}
+		if len(img.tags) == 0 {
+			index.Manifests = append(index.Manifests, desc)
+			continue
+		}
+		for _, tag := range img.tags {
+			_, name := SplitReference(tag)
+			tagged := desc
+			tagged.Annotations = map[string]string{AnnotationContainerName: tag, AnnotationRefName: name}
+			index.Manifests = append(index.Manifests, tagged)
+		}
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return errors.WithMessage(err, "index.json marshal failed")
+	}
+	if err = writeFileEntry(tw, ociLayoutFile, []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return err
+	}
+	return writeFileEntry(tw, ociIndexFile, data)
+}
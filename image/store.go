@@ -0,0 +1,264 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/aspirshar/myContainer/config"
+	"github.com/aspirshar/myContainer/constant"
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
+)
+
+// ValidateDigest 检查 digest 的格式，只支持 sha256，同时避免 digest 被拼接成任意路径
+func ValidateDigest(digest string) error {
+	hexPart, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hexPart) != sha256.Size*2 {
+		return errors.Errorf("invalid digest %s", digest)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil || strings.ToLower(hexPart) != hexPart {
+		return errors.Errorf("invalid digest %s", digest)
+	}
+	return nil
+}
+
+// maxManifestSize manifest 的大小上限，更大的 blob 一定是层，查找 manifest 时不需要读取
+const maxManifestSize = 4 << 20
+
+// storedManifests 返回镜像仓库中所有的 manifest，key 为 manifest digest
+/*
+索引只记录有 tag 的镜像，这里遍历 blobs 目录，
+1.跳过写入中的临时文件和超过 maxManifestSize 的 blob
+2.能解析为 manifest 且 config 有 mediaType 和 digest 的 blob 才是 manifest，镜像配置和层都不满足
+*/
+func storedManifests() (map[string]*Manifest, error) {
+	dir := path.Join(config.ImagesPath, "blobs", "sha256")
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read dir %s failed", dir)
+	}
+	manifests := make(map[string]*Manifest)
+	for _, entry := range entries {
+		digest := "sha256:" + entry.Name()
+		if !entry.Type().IsRegular() || ValidateDigest(digest) != nil {
+			continue
+		}
+		if manifest := readManifest(digest); manifest != nil {
+			manifests[digest] = manifest
+		}
+	}
+	return manifests, nil
+}
+
+// readManifest 读取 blob 并解析为 manifest，blob 不是 manifest 时返回 nil
+func readManifest(digest string) *Manifest {
+	file, err := os.Open(utils.GetBlob(digest))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxManifestSize+1))
+	if err != nil || len(data) > maxManifestSize || !bytes.HasPrefix(data, []byte("{")) {
+		return nil
+	}
+	manifest := new(Manifest)
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil
+	}
+	if manifest.Config.MediaType == "" || ValidateDigest(manifest.Config.Digest) != nil {
+		return nil
+	}
+	return manifest
+}
+
+// BlobExists 判断镜像仓库中是否已有该 blob
+func BlobExists(digest string) bool {
+	if ValidateDigest(digest) != nil {
+		return false
+	}
+	exists, _ := utils.PathExists(utils.GetBlob(digest))
+	return exists
+}
+
+// WriteBlob 将内容写入镜像仓库，返回内容的 digest 和大小
+/*
+1.先写入 blobs 目录下的临时文件，同时计算 sha256
+2.expected 不为空时校验内容的 digest，不一致时丢弃临时文件并返回错误
+3.重命名为 blobs/sha256/<digest>，相同内容只保存一份
+*/
+func WriteBlob(r io.Reader, expected string) (string, int64, error) {
+	if expected != "" {
+		if err := ValidateDigest(expected); err != nil {
+			return "", 0, err
+		}
+	}
+	dir := path.Join(config.ImagesPath, "blobs", "sha256")
+	if err := os.MkdirAll(dir, constant.Perm0755); err != nil {
+		return "", 0, errors.WithMessagef(err, "mkdir %s failed", dir)
+	}
+	tmpFile, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return "", 0, errors.WithMessage(err, "create temp blob failed")
+	}
+	defer os.Remove(tmpFile.Name())
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), r)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, errors.WithMessage(err, "write blob failed")
+	}
+	digest := fmt.Sprintf("sha256:%x", hash.Sum(nil))
+	if expected != "" && digest != expected {
+		return "", 0, errors.Errorf("blob digest mismatch, expect %s, got %s", expected, digest)
+	}
+	if err = os.Chmod(tmpFile.Name(), constant.Perm0644); err != nil {
+		return "", 0, errors.WithMessagef(err, "chmod %s failed", tmpFile.Name())
+	}
+	if err = os.Rename(tmpFile.Name(), utils.GetBlob(digest)); err != nil {
+		return "", 0, errors.WithMessagef(err, "rename blob %s failed", digest)
+	}
+	return digest, size, nil
+}
+
+// WriteBlobBytes 将一段内容写入镜像仓库，返回内容的 digest
+func WriteBlobBytes(data []byte) (string, error) {
+	digest, _, err := WriteBlob(bytes.NewReader(data), "")
+	return digest, err
+}
+
+// OpenBlob 打开镜像仓库中的 blob，读到末尾时校验内容的 digest，不一致时返回错误
+func OpenBlob(digest string) (io.ReadCloser, error) {
+	if err := ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(utils.GetBlob(digest))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "open blob %s failed", digest)
+	}
+	return &verifiedReader{file: file, hash: sha256.New(), digest: digest}, nil
+}
+
+// ReadBlob 读取镜像仓库中的 blob 并校验 digest
+func ReadBlob(digest string) ([]byte, error) {
+	reader, err := OpenBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read blob %s failed", digest)
+	}
+	return data, nil
+}
+
+// verifiedReader 边读边计算 sha256，读到 EOF 时与期望的 digest 比较
+type verifiedReader struct {
+	file   *os.File
+	hash   hash.Hash
+	digest string
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if digest := fmt.Sprintf("sha256:%x", r.hash.Sum(nil)); digest != r.digest {
+			return n, errors.Errorf("blob %s is corrupted, content digest is %s", r.digest, digest)
+		}
+	}
+	return n, err
+}
+
+func (r *verifiedReader) Close() error {
+	return r.file.Close()
+}
+
+// PartialBlob 下载中的 blob，下载中断后可以从已下载的位置继续
+type PartialBlob struct {
+	file   *os.File
+	digest string
+	Size   int64 // 已下载的大小
+}
+
+// OpenPartialBlob 打开 blob 的下载文件 blobs/sha256/.partial-<hex>，已下载的内容保留，新内容追加到末尾
+/*
+1.加文件锁，多个进程同时下载同一个 blob 时后来者等待
+2.拿到锁后 blob 已经存在（其他进程下载完成）时返回 nil
+*/
+func OpenPartialBlob(digest string) (*PartialBlob, error) {
+	if err := ValidateDigest(digest); err != nil {
+		return nil, err
+	}
+	dir := path.Join(config.ImagesPath, "blobs", "sha256")
+	if err := os.MkdirAll(dir, constant.Perm0755); err != nil {
+		return nil, errors.WithMessagef(err, "mkdir %s failed", dir)
+	}
+	partialPath := path.Join(dir, ".partial-"+strings.TrimPrefix(digest, "sha256:"))
+	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, constant.Perm0644)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "open %s failed", partialPath)
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, errors.WithMessagef(err, "lock %s failed", partialPath)
+	}
+	if BlobExists(digest) {
+		file.Close()
+		return nil, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.WithMessagef(err, "stat %s failed", partialPath)
+	}
+	return &PartialBlob{file: file, digest: digest, Size: info.Size()}, nil
+}
+
+// Write 追加下载的内容
+func (b *PartialBlob) Write(p []byte) (int, error) {
+	n, err := b.file.Write(p)
+	b.Size += int64(n)
+	return n, err
+}
+
+// Truncate 丢弃已下载的内容，服务端不支持断点续传时从头下载
+func (b *PartialBlob) Truncate() error {
+	if err := b.file.Truncate(0); err != nil {
+		return errors.WithMessagef(err, "truncate %s failed", b.file.Name())
+	}
+	b.Size = 0
+	return nil
+}
+
+// Commit 校验下载内容的 digest 并移动到 blob 的位置，校验失败时删除已下载的内容
+func (b *PartialBlob) Commit() error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(b.file, 0, b.Size)); err != nil {
+		return errors.WithMessagef(err, "read %s failed", b.file.Name())
+	}
+	if digest := fmt.Sprintf("sha256:%x", hash.Sum(nil)); digest != b.digest {
+		os.Remove(b.file.Name())
+		return errors.Errorf("blob digest mismatch, expect %s, got %s", b.digest, digest)
+	}
+	return errors.WithMessagef(os.Rename(b.file.Name(), utils.GetBlob(b.digest)), "rename blob %s failed", b.digest)
+}
+
+// Close 释放文件锁，未完成的下载内容保留在下载文件中
+func (b *PartialBlob) Close() error {
+	return b.file.Close()
+}
@@ -0,0 +1,200 @@
+package image
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aspirshar/myContainer/config"
+	"github.com/aspirshar/myContainer/utils"
+)
+
+func TestBlobVerify(t *testing.T) {
+	config.ImagesPath = t.TempDir() + "/"
+	digest, size, err := WriteBlob(strings.NewReader("hello"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" || size != 5 {
+		t.Fatalf("unexpected blob %s %d", digest, size)
+	}
+	// 写入时校验 digest
+	if _, _, err = WriteBlob(strings.NewReader("hello!"), digest); err == nil {
+		t.Fatal("expect digest mismatch")
+	}
+	if _, _, err = WriteBlob(strings.NewReader("hello"), "sha256:../../etc"); err == nil {
+		t.Fatal("expect invalid digest")
+	}
+	data, err := ReadBlob(digest)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("read blob %q %v", data, err)
+	}
+	// 读取时发现内容被篡改
+	if err = os.WriteFile(utils.GetBlob(digest), []byte("hellO"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadBlob(digest); err == nil {
+		t.Fatal("expect corrupted blob")
+	}
+}
+
+func TestReferenceAndTag(t *testing.T) {
+	config.ImagesPath = t.TempDir() + "/"
+	for ref, want := range map[string]string{
+		"busybox":                  "busybox:latest",
+		"busybox:1.36":             "busybox:1.36",
+		"localhost:5000/app":       "localhost:5000/app:latest",
+		"localhost:5000/app:v1":    "localhost:5000/app:v1",
+		"app@sha256:0123456789abc": "app@sha256:0123456789abc",
+	} {
+		if got := NormalizeReference(ref); got != want {
+			t.Fatalf("NormalizeReference(%s) = %s, want %s", ref, got, want)
+		}
+	}
+
+	img, err := WriteImage(NewConfigFile(), nil, "app", "app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"app", "app:latest", "app:v1", "app@" + img.Digest, img.Digest, shortHex(img.ID())} {
+		if digest, err := Resolve(ref); err != nil || digest != img.Digest {
+			t.Fatalf("Resolve(%s) = %s %v", ref, digest, err)
+		}
+	}
+	if _, err = Resolve("app:v2"); err == nil {
+		t.Fatal("expect image not found")
+	}
+	index, err := LoadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := index.TagsOf(img.Digest); len(tags) != 2 || tags[0] != "app:latest" || tags[1] != "app:v1" {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	got, err := Get("app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID() != img.ID() || got.Config.OS != "linux" {
+		t.Fatalf("unexpected image %+v", got)
+	}
+}
+
+func TestPrepareCorruptedLayer(t *testing.T) {
+	root := t.TempDir()
+	config.ImagesPath = root + "/images/"
+	config.LayersPath = root + "/layers/"
+	layer, diffID, err := WriteLayer(bytes.NewReader(layerTar(t, "a.txt", "a", false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := NewConfigFile()
+	configFile.RootFS.DiffIDs = []string{diffID}
+	if _, err = WriteImage(configFile, []Descriptor{layer}, "corrupted"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(utils.GetBlob(layer.Digest))
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(utils.GetBlob(layer.Digest), data, 0644)
+	if _, err = PrepareLayers("corrupted"); err == nil {
+		t.Fatal("expect corrupted layer")
+	}
+	if exists, _ := utils.PathExists(utils.GetLayer(layer.Digest)); exists {
+		t.Fatal("corrupted layer should not be extracted")
+	}
+}
+
+func TestDeleteKeepsSharedBlobs(t *testing.T) {
+	root := t.TempDir()
+	config.ImagesPath = root + "/images/"
+	config.LayersPath = root + "/layers/"
+	base, _, err := WriteLayer(bytes.NewReader(layerTar(t, "base.txt", "base", false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, _, err := WriteLayer(bytes.NewReader(layerTar(t, "top.txt", "top", false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app1, err := WriteImage(NewConfigFile(), []Descriptor{base, top}, "app1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := NewConfigFile()
+	configFile.Author = "app2"
+	if _, err = WriteImage(configFile, []Descriptor{base}, "app2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = PrepareLayers("app1"); err != nil {
+		t.Fatal(err)
+	}
+
+	untagged, deleted, err := Delete(app1.Digest, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(untagged) != 1 || untagged[0] != "app1:latest" || len(deleted) != 3 {
+		t.Fatalf("unexpected untagged %v deleted %v", untagged, deleted)
+	}
+	if !BlobExists(base.Digest) || BlobExists(top.Digest) || BlobExists(app1.Digest) {
+		t.Fatal("shared layer should be kept and other blobs removed")
+	}
+	if exists, _ := utils.PathExists(utils.GetLayer(base.Digest)); !exists {
+		t.Fatal("shared layer dir removed")
+	}
+	if exists, _ := utils.PathExists(utils.GetLayer(top.Digest)); exists {
+		t.Fatal("layer dir not removed")
+	}
+	if _, err = Resolve("app1"); err == nil {
+		t.Fatal("expect image not found")
+	}
+	if _, err = Resolve(shortHex(app1.ID())); err == nil {
+		t.Fatal("expect image id not found")
+	}
+}
+
+func TestDeleteKeepsBlobsOfUntaggedImages(t *testing.T) {
+	root := t.TempDir()
+	config.ImagesPath = root + "/images/"
+	config.LayersPath = root + "/layers/"
+	base, _, err := WriteLayer(bytes.NewReader(layerTar(t, "base.txt", "base", false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, _, err := WriteLayer(bytes.NewReader(layerTar(t, "top.txt", "top", false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有 tag 的镜像，e.g. 通过 repo@sha256:... 拉取的镜像或构建的中间镜像
+	untaggedImg, err := WriteImage(NewConfigFile(), []Descriptor{base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := NewConfigFile()
+	configFile.Author = "app"
+	app, err := WriteImage(configFile, []Descriptor{base, top}, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, deleted, err := Delete(app.Digest, false); err != nil || len(deleted) != 3 {
+		t.Fatalf("unexpected deleted %v %v", deleted, err)
+	}
+	if !BlobExists(base.Digest) || BlobExists(top.Digest) {
+		t.Fatal("layer of untagged image should be kept and other blobs removed")
+	}
+	if _, err = GetByDigest(untaggedImg.Digest); err != nil {
+		t.Fatal(err)
+	}
+	if _, deleted, err := Delete(untaggedImg.Digest, false); err != nil || len(deleted) != 3 {
+		t.Fatalf("unexpected deleted %v %v", deleted, err)
+	}
+	if BlobExists(base.Digest) {
+		t.Fatal("layer not removed")
+	}
+}
+
+func shortHex(id string) string {
+	return strings.TrimPrefix(id, "sha256:")[:12]
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/image"
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// shortIDLength images、history 中显示的镜像ID长度，与 docker 一致
+const shortIDLength = 12
+
+// shortID 截取镜像ID的前 12 位，e.g. sha256:0123456789abcdef... 对应 0123456789ab
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > shortIDLength {
+		return id[:shortIDLength]
+	}
+	return id
+}
+
+// formatSize 按 docker 的方式以 1000 为进制显示大小，e.g. 4.26MB
+func formatSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
+
+// formatCreated 以本地时间显示镜像的创建时间，与 ps 中的格式相同
+func formatCreated(created *time.Time) string {
+	if created == nil {
+		return "<unknown>"
+	}
+	return created.Local().Format("2006-01-02 15:04:05")
+}
+
+// listImages 打印镜像列表，每个 tag 一行，filters 为 label=k 或 label=k=v 形式的过滤条件
+func listImages(filters []string) error {
+	index, err := image.LoadIndex()
+	if err != nil {
+		return err
+	}
+	tags := make([]string, 0, len(index.Tags))
+	for tag := range index.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, tag := range tags {
+		img, err := image.GetByDigest(index.Tags[tag])
+		if err != nil {
+			log.Errorf("read image %s error %v", tag, err)
+			continue
+		}
+		if len(filters) > 0 {
+			imageConfig, err := container.ImageConfigOf(img)
+			if err != nil {
+				log.Errorf("read image %s config error %v", tag, err)
+				continue
+			}
+			matched, err := utils.MatchLabelFilters(imageConfig.Labels, filters)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+		}
+		repo, name := image.SplitReference(tag)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", repo, name, shortID(img.ID()), formatCreated(img.Config.Created), formatSize(img.Size()))
+	}
+	return w.Flush()
+}
+
+// tagImage 为镜像 source 添加新的 tag target
+func tagImage(source, target string) error {
+	digest, err := image.Resolve(source)
+	if err != nil {
+		return err
+	}
+	return image.Tag(target, digest)
+}
+
+// removeImages 删除镜像
+/*
+1.按 tag 删除且镜像还有其他 tag 时，只删除这个 tag
+2.按镜像ID删除且镜像有多个 tag 时，需要指定 -f 才会删除所有 tag
+3.有容器使用该镜像时拒绝删除，-f 可以删除已停止容器使用的镜像，但不能删除运行中容器使用的镜像
+4.删除镜像时删除不再被其他镜像引用的 blob 和层目录
+*/
+func removeImages(refs []string, force bool) error {
+	for _, ref := range refs {
+		if err := removeImage(ref, force); err != nil {
+			return errors.WithMessagef(err, "remove image %s failed", ref)
+		}
+	}
+	return nil
+}
+
+func removeImage(ref string, force bool) error {
+	digest, err := image.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	index, err := image.LoadIndex()
+	if err != nil {
+		return err
+	}
+	tags := index.TagsOf(digest)
+	byTag := index.Tags[image.NormalizeReference(ref)] == digest
+	if byTag && len(tags) > 1 {
+		if _, err = image.Untag(ref); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", image.NormalizeReference(ref))
+		return nil
+	}
+	if !byTag && len(tags) > 1 && !force {
+		return errors.Errorf("image is referenced in multiple repositories %v, use -f to remove all of them", tags)
+	}
+
+	img, err := image.GetByDigest(digest)
+	if err != nil {
+		return err
+	}
+	users, err := containersUsingImage(img)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.Status == container.RUNNING {
+			return errors.Errorf("image is being used by running container %s, stop it first", user.Id)
+		}
+		if !force {
+			return errors.Errorf("image is being used by stopped container %s, remove it first or use -f", user.Id)
+		}
+	}
+	untagged, deleted, err := image.Delete(digest, len(users) > 0)
+	for _, tag := range untagged {
+		fmt.Printf("Untagged: %s\n", tag)
+	}
+	for _, blob := range deleted {
+		fmt.Printf("Deleted: %s\n", blob)
+	}
+	return err
+}
+
+// containersUsingImage 返回使用镜像的所有容器，包括已经停止的容器
+func containersUsingImage(img *image.Image) ([]*container.Info, error) {
+	files, err := os.ReadDir(container.InfoLoc)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read dir %s failed", container.InfoLoc)
+	}
+	var users []*container.Info
+	for _, file := range files {
+		info, err := getContainerInfo(file)
+		if err != nil {
+			continue
+		}
+		// 没有记录镜像ID的容器按镜像名称判断
+		used := info.ImageId == img.ID()
+		if info.ImageId == "" && info.Image != "" {
+			digest, err := image.Resolve(info.Image)
+			used = err == nil && digest == img.Digest
+		}
+		if used {
+			users = append(users, info)
+		}
+	}
+	return users, nil
+}
+
+// inspectImage 以 JSON 格式打印镜像的配置和各个层
+func inspectImage(ref string) error {
+	img, err := image.Get(ref)
+	if err != nil {
+		return err
+	}
+	object, err := newImageInspect(img)
+	if err != nil {
+		return err
+	}
+	return printJSON(ref, object)
+}
+
+// imageHistory 打印镜像每一步构建的记录，最新的一步在最前面
+/*
+镜像配置中的 history 与层按顺序一一对应，empty_layer 为 true 的记录没有对应的层
+*/
+func imageHistory(ref string, noTrunc bool) error {
+	img, err := image.Get(ref)
+	if err != nil {
+		return err
+	}
+	history := img.Config.History
+	if len(history) == 0 {
+		// 没有构建记录时每一层显示为一条空记录
+		history = make([]image.History, len(img.Manifest.Layers))
+	}
+	type historyRow struct {
+		image.History
+		size int64
+	}
+	rows := make([]historyRow, 0, len(history))
+	layer := 0
+	for _, h := range history {
+		row := historyRow{History: h}
+		if !h.EmptyLayer && layer < len(img.Manifest.Layers) {
+			row.size = img.Manifest.Layers[layer].Size
+			layer++
+		}
+		rows = append(rows, row)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "IMAGE\tCREATED\tCREATED BY\tSIZE\tCOMMENT\n")
+	for i := len(rows) - 1; i >= 0; i-- {
+		id := "<missing>"
+		if i == len(rows)-1 {
+			id = shortID(img.ID())
+		}
+		createdBy := strings.ReplaceAll(rows[i].CreatedBy, "\t", " ")
+		if runes := []rune(createdBy); !noTrunc && len(runes) > 45 {
+			createdBy = string(runes[:44]) + "…"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", id, formatCreated(rows[i].Created), createdBy, formatSize(rows[i].size), rows[i].Comment)
+	}
+	return w.Flush()
+}
+
+// loadImages 导入镜像 tar 包中的所有镜像，input 为空时从标准输入读取
+/*
+标准输入需要遍历两次，先复制到临时文件
+*/
+func loadImages(input string) error {
+	if input == "" {
+		tmpFile, err := os.CreateTemp("", "mycontainer-load-")
+		if err != nil {
+			return errors.WithMessage(err, "create temp file failed")
+		}
+		defer os.Remove(tmpFile.Name())
+		_, err = io.Copy(tmpFile, os.Stdin)
+		if closeErr := tmpFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return errors.WithMessage(err, "read image archive from stdin failed")
+		}
+		input = tmpFile.Name()
+	}
+	loaded, err := image.Load(input)
+	if err != nil {
+		return err
+	}
+	for _, img := range loaded {
+		if len(img.Tags) == 0 {
+			fmt.Printf("Loaded image ID: %s\n", img.Digest)
+		}
+		for _, tag := range img.Tags {
+			fmt.Printf("Loaded image: %s\n", tag)
+		}
+	}
+	return nil
+}
+
+// saveImages 将镜像导出为 tar 包，output 为空时写入标准输出
+func saveImages(refs []string, output, format string) error {
+	if output == "" {
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return errors.New("refusing to write image archive to a terminal, use -o or redirect stdout")
+		}
+		return image.Save(os.Stdout, refs, format)
+	}
+	// 先写入临时文件，导出失败时不会留下不完整的 tar 包
+	tmpPath := output + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.WithMessagef(err, "create %s failed", tmpPath)
+	}
+	defer os.Remove(tmpPath)
+	err = image.Save(file, refs, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return errors.WithMessagef(os.Rename(tmpPath, output), "rename %s failed", tmpPath)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/image"
+	"github.com/pkg/errors"
+)
+
+// containerInspect inspect 容器时的输出，在容器信息之外列出 exec 会话
+type containerInspect struct {
+	*container.Info
+	ExecSessions []*container.ExecSession `json:"execSessions,omitempty"`
+}
+
+// imageInspect inspect 镜像时的输出，在镜像配置之外列出镜像ID、标签和各个层
+type imageInspect struct {
+	Id       string   `json:"id"`
+	RepoTags []string `json:"repoTags"`
+	Digest   string   `json:"digest"`
+	*image.ConfigFile
+	Layers []image.Descriptor `json:"layers"`
+}
+
+func newImageInspect(img *image.Image) (*imageInspect, error) {
+	index, err := image.LoadIndex()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInspect{
+		Id:         img.ID(),
+		RepoTags:   index.TagsOf(img.Digest),
+		Digest:     img.Digest,
+		ConfigFile: img.Config,
+		Layers:     img.Manifest.Layers,
+	}, nil
+}
+
+// inspectObject 以 JSON 格式打印容器或镜像的详细信息，优先按容器名称和ID查找，找不到时再查找镜像
+func inspectObject(name string) error {
+	var object interface{}
+	containerInfo, err := container.GetContainerInfoByName(name)
+	if err != nil {
+		containerInfo, err = getInfoByContainerId(name)
+	}
+	if err == nil {
+		sessions, err := container.ListExecSessions(containerInfo.Id)
+		if err != nil {
+			return err
+		}
+		object = containerInspect{Info: containerInfo, ExecSessions: sessions}
+	} else {
+		img, err := image.Get(name)
+		if errors.Is(err, image.ErrImageNotFound) {
+			return errors.Errorf("no such container or image: %s", name)
+		}
+		if err != nil {
+			return err
+		}
+		if object, err = newImageInspect(img); err != nil {
+			return err
+		}
+	}
+
+	return printJSON(name, object)
+}
+
+// printJSON 以缩进的 JSON 格式打印对象
+func printJSON(name string, object interface{}) error {
+	content, err := json.MarshalIndent(object, "", "    ")
+	if err != nil {
+		return errors.WithMessagef(err, "marshal %s failed", name)
+	}
+	fmt.Println(string(content))
+	return nil
+}
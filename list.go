@@ -11,11 +11,13 @@ import (
 	"text/tabwriter"
 
 	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/utils"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func ListContainers() {
+// ListContainers 打印容器列表，filters 为 label=k 或 label=k=v 形式的过滤条件
+func ListContainers(filters []string) {
 	// 读取存放容器信息目录下的所有文件
 	files, err := os.ReadDir(container.InfoLoc)
 	if err != nil {
@@ -29,7 +31,14 @@ func ListContainers() {
 			log.Errorf("get container info error %v", err)
 			continue
 		}
-		containers = append(containers, tmpContainer)
+		matched, err := utils.MatchLabelFilters(tmpContainer.Labels, filters)
+		if err != nil {
+			log.Errorf("filter container error %v", err)
+			return
+		}
+		if matched {
+			containers = append(containers, tmpContainer)
+		}
 	}
 	// 使用tabwriter.NewWriter在控制台打印出容器信息
 	// tabwriter 是引用的text/tabwriter类库，用于在控制台打印对齐的表格
@@ -44,7 +53,7 @@ func ListContainers() {
 			item.Name,
 			item.Pid,
 			item.IP,
-			item.Status,
+			formatStatus(item),
 			item.Command,
 			item.CreatedTime)
 		if err != nil {
@@ -56,6 +65,14 @@ func ListContainers() {
 	}
 }
 
+// formatStatus 生成 ps 中展示的状态，配置了健康检查的运行中容器会附带健康状态，比如 running (healthy)
+func formatStatus(info *container.Info) string {
+	if info.Status != container.RUNNING || info.HealthState == nil || info.HealthState.Status == container.HealthNone {
+		return info.Status
+	}
+	return fmt.Sprintf("%s (%s)", info.Status, info.HealthState.Status)
+}
+
 func getContainerInfo(file os.DirEntry) (*container.Info, error) {
 	configFileDir := fmt.Sprintf(container.InfoLocFormat, file.Name())
 	configFilePath := path.Join(configFileDir, container.ConfigName)
@@ -77,6 +94,7 @@ func getContainerInfo(file os.DirEntry) (*container.Info, error) {
 		log.Errorf("json unmarshal error %v", err)
 		return nil, err
 	}
+	container.LoadHealthState(info)
 
 	// 如果容器状态为 RUNNING，则检查进程是否存在
 	if info.Status == container.RUNNING {
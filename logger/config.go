@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// JSONFileDriver 默认的日志驱动，日志以 json 格式写入容器目录下的文件
+const JSONFileDriver = "json-file"
+
+// Config 容器的日志配置，与 docker inspect 中的 LogConfig 相同
+type Config struct {
+	Type   string            `json:"type"`
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// ParseOptions 解析 --log-opt key=value 参数
+func ParseOptions(opts []string) (map[string]string, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	options := make(map[string]string, len(opts))
+	for _, opt := range opts {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok || key == "" {
+			return nil, errors.Errorf("invalid log option %s, expect key=value", opt)
+		}
+		options[key] = value
+	}
+	return options, nil
+}
+
+// JSONFileOptions json-file 日志驱动的轮转参数
+type JSONFileOptions struct {
+	MaxSize  int64 // 单个日志文件的最大字节数，0 表示不轮转
+	MaxFile  int   // 最多保留的日志文件个数，包括当前正在写入的文件
+	Compress bool  // 是否用 gzip 压缩轮转后的文件
+}
+
+// ParseJSONFileOptions 解析 max-size、max-file、compress 参数
+func ParseJSONFileOptions(options map[string]string) (*JSONFileOptions, error) {
+	opts := &JSONFileOptions{MaxFile: 1}
+	for key, value := range options {
+		var err error
+		switch key {
+		case "max-size":
+			if opts.MaxSize, err = parseSize(value); err == nil && opts.MaxSize <= 0 {
+				err = errors.New("must be positive")
+			}
+		case "max-file":
+			if opts.MaxFile, err = strconv.Atoi(value); err == nil && opts.MaxFile < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case "compress":
+			opts.Compress, err = strconv.ParseBool(value)
+		default:
+			return nil, errors.Errorf("unknown log option %s for %s log driver", key, JSONFileDriver)
+		}
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid log option %s=%s", key, value)
+		}
+	}
+	// 与 docker 一致，max-file 和 compress 只有在指定 max-size 时才有意义
+	if opts.MaxSize == 0 && (options["max-file"] != "" || options["compress"] != "") {
+		return nil, errors.New("max-file and compress require max-size")
+	}
+	return opts, nil
+}
+
+// parseSize 解析 10m 这样的大小，支持 k、m、g 单位，不区分大小写，不带单位时为字节数
+func parseSize(value string) (int64, error) {
+	units := map[byte]int64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+	value = strings.ToLower(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "b")
+	multiplier := int64(1)
+	if len(value) > 0 {
+		if unit, ok := units[value[len(value)-1]]; ok {
+			multiplier = unit
+			value = value[:len(value)-1]
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size %s", value)
+	}
+	return size * multiplier, nil
+}
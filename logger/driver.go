@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SyslogDriver = "syslog"
+	NoneDriver   = "none"
+	// defaultTag 默认使用容器ID作为日志标签
+	defaultTag = "{{.ID}}"
+)
+
+// Driver 日志驱动，日志进程将容器的每条输出交给驱动处理
+type Driver interface {
+	Log(entry *Entry) error
+	Close() error
+}
+
+// Info 创建日志驱动需要的容器信息，由 run 通过管道以 JSON 格式传给日志进程
+type Info struct {
+	Config        Config            `json:"config"`
+	ContainerID   string            `json:"containerId"`
+	ContainerName string            `json:"containerName"`
+	ImageName     string            `json:"imageName"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	LogPath       string            `json:"logPath"` // json-file 日志驱动的日志文件路径
+}
+
+// DriverName 返回日志驱动名称，没有配置时为 json-file
+func (c *Config) DriverName() string {
+	if c == nil || c.Type == "" {
+		return JSONFileDriver
+	}
+	return c.Type
+}
+
+// New 根据容器的日志配置创建日志驱动，json-file 日志驱动会负责日志轮转
+func New(info *Info) (Driver, error) {
+	switch info.Config.DriverName() {
+	case JSONFileDriver:
+		opts, err := ParseJSONFileOptions(info.Config.Config)
+		if err != nil {
+			return nil, err
+		}
+		return NewJSONFile(info.LogPath, opts)
+	case SyslogDriver:
+		return NewSyslog(info)
+	case FluentdDriver:
+		return NewFluentd(info)
+	case HTTPDriver:
+		return NewHTTP(info)
+	case NoneDriver:
+		return noneDriver{}, nil
+	default:
+		return nil, errors.Errorf("unknown log driver %s", info.Config.Type)
+	}
+}
+
+// ValidateOptions 校验日志驱动和参数，在容器启动前发现错误
+func ValidateOptions(config *Config) error {
+	switch config.DriverName() {
+	case JSONFileDriver:
+		_, err := ParseJSONFileOptions(config.Config)
+		return err
+	case SyslogDriver:
+		_, err := parseSyslogOptions(config.Config)
+		return err
+	case FluentdDriver:
+		_, _, err := parseFluentdOptions(config.Config)
+		return err
+	case HTTPDriver:
+		_, _, _, err := parseHTTPOptions(config.Config)
+		return err
+	case NoneDriver:
+		if len(config.Config) > 0 {
+			return errors.Errorf("%s log driver does not accept options", NoneDriver)
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown log driver %s, expect %s", config.Type,
+			strings.Join([]string{JSONFileDriver, SyslogDriver, FluentdDriver, HTTPDriver, NoneDriver}, ", "))
+	}
+}
+
+// Readable 判断日志驱动写入的日志能否通过 logs 命令读取
+func Readable(config *Config) bool {
+	return config.DriverName() == JSONFileDriver
+}
+
+// Tag 根据 tag 参数生成日志标签，支持 {{.ID}}、{{.Name}}、{{.ImageName}}
+func (info *Info) Tag() (string, error) {
+	tag := info.Config.Config["tag"]
+	if tag == "" {
+		tag = defaultTag
+	}
+	tmpl, err := template.New("tag").Parse(tag)
+	if err != nil {
+		return "", errors.WithMessagef(err, "invalid log tag %s", tag)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, struct {
+		ID        string
+		Name      string
+		ImageName string
+	}{info.ContainerID, info.ContainerName, info.ImageName})
+	if err != nil {
+		return "", errors.WithMessagef(err, "invalid log tag %s", tag)
+	}
+	return buf.String(), nil
+}
+
+// noneDriver 丢弃所有日志
+type noneDriver struct{}
+
+// Discard 返回丢弃所有日志的日志驱动
+func Discard() Driver { return noneDriver{} }
+
+func (noneDriver) Log(*Entry) error { return nil }
+
+func (noneDriver) Close() error { return nil }
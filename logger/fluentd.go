@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	FluentdDriver         = "fluentd"
+	defaultFluentdAddress = "localhost:24224"
+	fluentdDialTimeout    = 5 * time.Second
+	fluentdWriteTimeout   = 10 * time.Second
+)
+
+// parseFluentdOptions 解析 fluentd-address 和转发共用参数，地址格式为 host:port 或 tcp://host:port
+func parseFluentdOptions(options map[string]string) (string, *forwardOptions, error) {
+	opts := defaultForwardOptions()
+	address := defaultFluentdAddress
+	for key, value := range options {
+		handled, err := opts.parse(key, value)
+		if err != nil {
+			return "", nil, err
+		}
+		if handled {
+			continue
+		}
+		switch key {
+		case "fluentd-address":
+			address = strings.TrimPrefix(value, "tcp://")
+			if _, _, err = net.SplitHostPort(address); err != nil {
+				return "", nil, errors.Errorf("invalid log option fluentd-address=%s, expect [tcp://]host:port", value)
+			}
+		default:
+			return "", nil, errors.Errorf("unknown log option %s for %s log driver", key, FluentdDriver)
+		}
+	}
+	return address, opts, nil
+}
+
+// fluentd 通过 Fluentd Forward 协议发送日志
+type fluentd struct {
+	address string
+	conn    net.Conn
+}
+
+// NewFluentd 创建 fluentd 日志驱动，连接在第一次发送时建立，断开后下次发送时重连
+func NewFluentd(info *Info) (Driver, error) {
+	address, opts, err := parseFluentdOptions(info.Config.Config)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := info.Tag()
+	if err != nil {
+		return nil, err
+	}
+	f := &fluentd{address: address}
+	forwarder, err := newForwarder(info, opts, func(records []*Record) error {
+		return f.send(tag, records)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &fluentdDriver{forwarder: forwarder, fluentd: f}, nil
+}
+
+// send 以 Forward 模式发送一批日志：[tag, [[time, record], ...]]
+func (f *fluentd) send(tag string, records []*Record) error {
+	if f.conn == nil {
+		conn, err := net.DialTimeout("tcp", f.address, fluentdDialTimeout)
+		if err != nil {
+			return errors.WithMessagef(err, "connect fluentd %s failed", f.address)
+		}
+		f.conn = conn
+	}
+	buf := appendArrayHeader(nil, 2)
+	buf = appendString(buf, tag)
+	buf = appendArrayHeader(buf, len(records))
+	for _, record := range records {
+		buf = appendArrayHeader(buf, 2)
+		buf = appendEventTime(buf, record.Time)
+		buf = appendFluentdRecord(buf, record)
+	}
+	_ = f.conn.SetWriteDeadline(time.Now().Add(fluentdWriteTimeout))
+	if _, err := f.conn.Write(buf); err != nil {
+		f.conn.Close()
+		f.conn = nil
+		return errors.WithMessagef(err, "write fluentd %s failed", f.address)
+	}
+	return nil
+}
+
+// appendFluentdRecord 编码一条日志，字段与 docker fluentd 日志驱动相同，另外附带镜像和标签
+func appendFluentdRecord(buf []byte, record *Record) []byte {
+	fields := [][2]string{
+		{"log", record.Log},
+		{"source", record.Source},
+		{"container_id", record.ContainerID},
+		{"container_name", record.ContainerName},
+		{"image", record.Image},
+	}
+	size := len(fields)
+	if len(record.Labels) > 0 {
+		size++
+	}
+	buf = appendMapHeader(buf, size)
+	for _, field := range fields {
+		buf = appendString(appendString(buf, field[0]), field[1])
+	}
+	if len(record.Labels) > 0 {
+		buf = appendString(buf, "labels")
+		buf = appendMapHeader(buf, len(record.Labels))
+		for key, value := range record.Labels {
+			buf = appendString(appendString(buf, key), value)
+		}
+	}
+	return buf
+}
+
+// fluentdDriver 关闭时先发送剩余日志再断开连接
+type fluentdDriver struct {
+	*forwarder
+	fluentd *fluentd
+}
+
+func (d *fluentdDriver) Close() error {
+	err := d.forwarder.Close()
+	if d.fluentd.conn != nil {
+		d.fluentd.conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,435 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aspirshar/myContainer/constant"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultBufferSize    = 1000
+	defaultMaxBackoff    = 30 * time.Second
+	initialBackoff       = 100 * time.Millisecond
+)
+
+// Record 发送给日志收集服务的一条日志，附带容器信息
+type Record struct {
+	Time          time.Time         `json:"time"`
+	Log           string            `json:"log"`
+	Source        string            `json:"source"` // stdout 或 stderr
+	Tag           string            `json:"tag,omitempty"`
+	ContainerID   string            `json:"container_id"`
+	ContainerName string            `json:"container_name"`
+	Image         string            `json:"image,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// forwardOptions 转发类日志驱动共用的缓冲、批量和重试参数
+type forwardOptions struct {
+	batchSize     int           // 每批最多发送的日志条数
+	flushInterval time.Duration // 不满一批时最多等待的时间
+	bufferSize    int           // 内存中最多缓冲的日志条数，超过后写入磁盘
+	maxBackoff    time.Duration // 重试间隔的上限
+}
+
+func defaultForwardOptions() *forwardOptions {
+	return &forwardOptions{
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		bufferSize:    defaultBufferSize,
+		maxBackoff:    defaultMaxBackoff,
+	}
+}
+
+// parse 解析共用参数，不是共用参数时返回 false
+func (opts *forwardOptions) parse(key, value string) (bool, error) {
+	var err error
+	switch key {
+	case "batch-size":
+		if opts.batchSize, err = strconv.Atoi(value); err == nil && opts.batchSize < 1 {
+			err = errors.New("must be at least 1")
+		}
+	case "buffer-size":
+		if opts.bufferSize, err = strconv.Atoi(value); err == nil && opts.bufferSize < 1 {
+			err = errors.New("must be at least 1")
+		}
+	case "flush-interval":
+		if opts.flushInterval, err = time.ParseDuration(value); err == nil && opts.flushInterval <= 0 {
+			err = errors.New("must be positive")
+		}
+	case "max-backoff":
+		if opts.maxBackoff, err = time.ParseDuration(value); err == nil && opts.maxBackoff <= 0 {
+			err = errors.New("must be positive")
+		}
+	case "tag":
+		_, err = (&Info{Config: Config{Config: map[string]string{"tag": value}}}).Tag()
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, errors.WithMessagef(err, "invalid log option %s=%s", key, value)
+	}
+	return true, nil
+}
+
+// forwarder 缓冲容器输出并批量发送给日志收集服务
+/*
+1.Log 把日志放入内存队列后立即返回，不会因为收集服务不可用而阻塞容器输出
+2.后台 goroutine 攒够 batchSize 条或等待 flushInterval 后调用 send 发送一批，失败时按指数退避重试
+3.重试期间内存队列满了以后，新的日志追加到磁盘上的溢出文件；只要溢出文件中还有未发送的日志，
+  新日志都写入溢出文件，保证发送顺序与输出顺序一致。内存队列发送完后再按顺序发送溢出文件中的日志
+4.关闭时尽量发送剩余日志，收集服务仍不可用时未发送的日志保留在溢出文件中
+5.启动时接管已经退出的进程留下的溢出文件，在新的日志之前发送
+*/
+type forwarder struct {
+	info    *Info
+	tag     string
+	opts    *forwardOptions
+	send    func([]*Record) error
+	records chan *Record
+
+	mu          sync.Mutex
+	spillPath   string
+	spillFile   *os.File // 溢出文件的写入句柄，没有溢出时为 nil
+	spillOffset int64    // 溢出文件中已发送部分的长度
+	claimed     []string // 接管的其他进程留下的溢出文件
+
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newForwarder(info *Info, opts *forwardOptions, send func([]*Record) error) (*forwarder, error) {
+	tag, err := info.Tag()
+	if err != nil {
+		return nil, err
+	}
+	f := &forwarder{
+		info:    info,
+		tag:     tag,
+		opts:    opts,
+		send:    send,
+		records: make(chan *Record, opts.bufferSize),
+		// 溢出文件放在容器目录下，按进程区分，exec -d 的后台进程和日志进程互不影响
+		spillPath: spillPath(info, strconv.Itoa(os.Getpid())),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	f.claimed = claimSpills(info)
+	go f.run()
+	return f, nil
+}
+
+func (f *forwarder) Log(entry *Entry) error {
+	record := &Record{
+		Time:          entry.Time,
+		Log:           strings.TrimSuffix(entry.Log, "\n"),
+		Source:        entry.Stream,
+		Tag:           f.tag,
+		ContainerID:   f.info.ContainerID,
+		ContainerName: f.info.ContainerName,
+		Image:         f.info.ImageName,
+		Labels:        f.info.Labels,
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.spillFile == nil {
+		select {
+		case f.records <- record:
+			return nil
+		default:
+		}
+	}
+	return f.spillLocked([]*Record{record})
+}
+
+// Close 发送剩余日志后返回
+func (f *forwarder) Close() error {
+	f.closeOnce.Do(func() { close(f.closing) })
+	<-f.done
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.spillFile != nil {
+		f.spillFile.Close()
+		if err := trimSpill(f.spillPath, f.spillOffset); err != nil {
+			log.Errorf("trim spill file error %v", err)
+		}
+		log.Warnf("log collector unavailable, unsent logs are kept in %s", f.spillPath)
+	}
+	return nil
+}
+
+func (f *forwarder) run() {
+	defer close(f.done)
+	for i, claimedPath := range f.claimed {
+		if !f.replaySpill(claimedPath) {
+			log.Warnf("log collector unavailable, unsent logs are kept in %v", f.claimed[i:])
+			break
+		}
+	}
+	for {
+		batch, closing := f.nextBatch()
+		if len(batch) > 0 && !f.sendWithRetry(batch) {
+			f.mu.Lock()
+			if err := f.spillLocked(batch); err != nil {
+				log.Errorf("spill logs error %v", err)
+			}
+			f.mu.Unlock()
+		}
+		if len(f.records) == 0 && !f.drainSpill() {
+			return
+		}
+		if closing && len(f.records) == 0 {
+			return
+		}
+	}
+}
+
+// nextBatch 从内存队列中取出一批日志，返回的 bool 表示是否正在关闭
+func (f *forwarder) nextBatch() ([]*Record, bool) {
+	var batch []*Record
+	timer := time.NewTimer(f.opts.flushInterval)
+	defer timer.Stop()
+	for len(batch) < f.opts.batchSize {
+		select {
+		case record := <-f.records:
+			batch = append(batch, record)
+		case <-timer.C:
+			return batch, false
+		case <-f.closing:
+			for len(batch) < f.opts.batchSize {
+				select {
+				case record := <-f.records:
+					batch = append(batch, record)
+				default:
+					return batch, true
+				}
+			}
+			return batch, true
+		}
+	}
+	return batch, false
+}
+
+// sendWithRetry 发送一批日志，失败时按指数退避重试，关闭时只再尝试一次，返回是否发送成功
+func (f *forwarder) sendWithRetry(batch []*Record) bool {
+	backoff := initialBackoff
+	for {
+		err := f.send(batch)
+		if err == nil {
+			return true
+		}
+		log.Warnf("send %d logs error %v, retry in %v", len(batch), err, backoff)
+		select {
+		case <-f.closing:
+			return f.send(batch) == nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > f.opts.maxBackoff {
+			backoff = f.opts.maxBackoff
+		}
+	}
+}
+
+// spillLocked 将日志追加到溢出文件，调用方需要持有锁
+func (f *forwarder) spillLocked(records []*Record) error {
+	if f.spillFile == nil {
+		file, err := os.OpenFile(f.spillPath, os.O_APPEND|os.O_CREATE|os.O_RDWR, constant.Perm0644)
+		if err != nil {
+			return errors.WithMessagef(err, "open spill file %s failed", f.spillPath)
+		}
+		f.spillFile = file
+	}
+	var buf []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return errors.WithMessage(err, "record marshal failed")
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	_, err := f.spillFile.Write(buf)
+	return errors.WithMessagef(err, "write spill file %s failed", f.spillPath)
+}
+
+// drainSpill 按顺序发送溢出文件中的日志，全部发送后删除溢出文件，关闭时发送失败返回 false
+func (f *forwarder) drainSpill() bool {
+	for {
+		f.mu.Lock()
+		if f.spillFile == nil {
+			f.mu.Unlock()
+			return true
+		}
+		info, err := f.spillFile.Stat()
+		if err != nil {
+			f.mu.Unlock()
+			log.Errorf("stat spill file %s error %v", f.spillPath, err)
+			return true
+		}
+		if info.Size() <= f.spillOffset {
+			// 全部发送完毕，之后的日志重新进入内存队列
+			f.spillFile.Close()
+			os.Remove(f.spillPath)
+			f.spillFile = nil
+			f.spillOffset = 0
+			f.mu.Unlock()
+			return true
+		}
+		start, end := f.spillOffset, info.Size()
+		f.mu.Unlock()
+
+		// 读取和发送时不持有锁，Log 可以继续追加
+		sent, ok := f.sendSpilled(f.spillPath, start, end)
+		f.mu.Lock()
+		f.spillOffset = sent
+		f.mu.Unlock()
+		if !ok {
+			return false
+		}
+	}
+}
+
+// sendSpilled 分批发送溢出文件中 [start, end) 的日志，返回已发送部分的结束位置和是否全部发送成功
+func (f *forwarder) sendSpilled(spillPath string, start, end int64) (int64, bool) {
+	file, err := os.Open(spillPath)
+	if err != nil {
+		log.Errorf("open spill file %s error %v", spillPath, err)
+		return end, true
+	}
+	defer file.Close()
+	reader := bufio.NewReader(io.NewSectionReader(file, start, end-start))
+	offset := start
+	for {
+		var batch []*Record
+		batchEnd := offset
+		for len(batch) < f.opts.batchSize {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				break
+			}
+			batchEnd += int64(len(line))
+			record := new(Record)
+			if err = json.Unmarshal(line, record); err != nil {
+				log.Errorf("decode spilled record error %v", err)
+				continue
+			}
+			batch = append(batch, record)
+		}
+		if batchEnd == offset {
+			return offset, true
+		}
+		if len(batch) > 0 && !f.sendWithRetry(batch) {
+			return offset, false
+		}
+		offset = batchEnd
+	}
+}
+
+// replaySpill 发送接管的溢出文件中的日志，全部发送后删除文件，关闭时发送失败返回 false
+func (f *forwarder) replaySpill(spillPath string) bool {
+	info, err := os.Stat(spillPath)
+	if err != nil {
+		log.Errorf("stat spill file %s error %v", spillPath, err)
+		return true
+	}
+	sent, ok := f.sendSpilled(spillPath, 0, info.Size())
+	if !ok {
+		if err = trimSpill(spillPath, sent); err != nil {
+			log.Errorf("trim spill file error %v", err)
+		}
+		return false
+	}
+	os.Remove(spillPath)
+	return true
+}
+
+// spillPath 返回溢出文件的路径，e.g. <容器ID>-spill-<pid>.log，接管的文件为 <容器ID>-spill-<pid>-<n>.log
+func spillPath(info *Info, name string) string {
+	return path.Join(path.Dir(info.LogPath), fmt.Sprintf("%s-spill-%s.log", info.ContainerID, name))
+}
+
+// claimSpills 接管已经退出的进程留下的溢出文件，按修改时间从旧到新返回
+/*
+1.文件名中的 pid 对应的进程仍然存在时，文件可能还在使用，不接管
+2.改名为以当前进程 pid 开头的文件名，多个进程同时启动时只有一个能改名成功；
+  当前进程退出时仍未发送完的文件会被之后启动的进程再次接管
+*/
+func claimSpills(info *Info) []string {
+	prefix := info.ContainerID + "-spill-"
+	matches, err := filepath.Glob(spillPath(info, "*"))
+	if err != nil {
+		return nil
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return modTime(matches[i]).Before(modTime(matches[j]))
+	})
+	var claimed []string
+	for _, match := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(path.Base(match), prefix), ".log")
+		pidStr, _, _ := strings.Cut(name, "-")
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil || pid == os.Getpid() || syscall.Kill(pid, 0) != syscall.ESRCH {
+			continue
+		}
+		claimedPath := spillPath(info, fmt.Sprintf("%d-%d", os.Getpid(), len(claimed)))
+		if err = os.Rename(match, claimedPath); err != nil {
+			continue
+		}
+		claimed = append(claimed, claimedPath)
+	}
+	return claimed
+}
+
+func modTime(name string) time.Time {
+	info, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// trimSpill 删除溢出文件中前 offset 字节已经发送的日志，保留的文件中只有未发送的日志
+func trimSpill(spillPath string, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	src, err := os.Open(spillPath)
+	if err != nil {
+		return errors.WithMessagef(err, "open %s failed", spillPath)
+	}
+	defer src.Close()
+	if _, err = src.Seek(offset, io.SeekStart); err != nil {
+		return errors.WithMessagef(err, "seek %s failed", spillPath)
+	}
+	tmpPath := spillPath + ".tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, constant.Perm0644)
+	if err != nil {
+		return errors.WithMessagef(err, "create %s failed", tmpPath)
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.WithMessagef(err, "copy %s failed", spillPath)
+	}
+	return errors.WithMessagef(os.Rename(tmpPath, spillPath), "rename %s failed", tmpPath)
+}
@@ -0,0 +1,336 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func forwardInfo(t *testing.T, driver string, options map[string]string) *Info {
+	return &Info{
+		Config:        Config{Type: driver, Config: options},
+		ContainerID:   "1234567890",
+		ContainerName: "web",
+		ImageName:     "busybox",
+		Labels:        map[string]string{"app": "demo"},
+		LogPath:       path.Join(t.TempDir(), "1234567890-json.log"),
+	}
+}
+
+func logLines(t *testing.T, driver Driver, n int) {
+	for i := 0; i < n; i++ {
+		entry := &Entry{Log: fmt.Sprintf("line %d\n", i), Stream: Stdout, Time: time.Now()}
+		if err := driver.Log(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkRecords(t *testing.T, records []*Record, n int) {
+	if len(records) != n {
+		t.Fatalf("got %d records, want %d", len(records), n)
+	}
+	for i, record := range records {
+		if record.Log != fmt.Sprintf("line %d", i) {
+			t.Fatalf("record %d out of order: %q", i, record.Log)
+		}
+		if record.ContainerID != "1234567890" || record.ContainerName != "web" || record.Image != "busybox" ||
+			record.Labels["app"] != "demo" || record.Source != Stdout {
+			t.Fatalf("record %d missing container attributes: %+v", i, record)
+		}
+	}
+}
+
+// httpCollector 前 failures 次请求返回 503，之后记录收到的日志
+type httpCollector struct {
+	mu       sync.Mutex
+	failures int
+	records  []*Record
+}
+
+func (c *httpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var records []*Record
+	if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.records = append(c.records, records...)
+}
+
+func TestHTTPRetry(t *testing.T) {
+	collector := &httpCollector{failures: 2}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	driver, err := New(forwardInfo(t, HTTPDriver, map[string]string{
+		"http-url": server.URL, "batch-size": "7", "flush-interval": "10ms",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logLines(t, driver, 50)
+	// 等待重试成功后再关闭，关闭时只会再尝试一次
+	time.Sleep(500 * time.Millisecond)
+	if err = driver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, collector.records, 50)
+}
+
+func TestHTTPSpill(t *testing.T) {
+	collector := &httpCollector{failures: 3}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	info := forwardInfo(t, HTTPDriver, map[string]string{
+		"http-url": server.URL, "buffer-size": "5", "batch-size": "4", "flush-interval": "10ms",
+	})
+	driver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logLines(t, driver, 100)
+	// 内存队列只能放 5 条，其余日志写入溢出文件
+	spilled, _ := os.ReadDir(path.Dir(info.LogPath))
+	if len(spilled) == 0 {
+		t.Fatal("expect spill file")
+	}
+	time.Sleep(time.Second)
+	if err = driver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, collector.records, 100)
+	if left, _ := os.ReadDir(path.Dir(info.LogPath)); len(left) != 0 {
+		t.Fatalf("spill file not removed: %v", left)
+	}
+}
+
+func TestHTTPCollectorDown(t *testing.T) {
+	info := forwardInfo(t, HTTPDriver, map[string]string{
+		"http-url": "http://127.0.0.1:1/logs", "buffer-size": "2", "flush-interval": "10ms",
+	})
+	driver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logLines(t, driver, 10)
+	if err = driver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 收集服务不可用时日志保留在溢出文件中
+	left, _ := os.ReadDir(path.Dir(info.LogPath))
+	if len(left) != 1 {
+		t.Fatalf("expect spill file, got %v", left)
+	}
+	data, _ := os.ReadFile(path.Join(path.Dir(info.LogPath), left[0].Name()))
+	var count int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		count++
+	}
+	if count != 10 {
+		t.Fatalf("spilled %d records, want 10", count)
+	}
+}
+
+func TestReplayOrphanSpill(t *testing.T) {
+	collector := &httpCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	info := forwardInfo(t, HTTPDriver, map[string]string{"http-url": server.URL, "flush-interval": "10ms"})
+	// 已经退出的进程留下的溢出文件
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for i := 0; i < 5; i++ {
+		line, _ := json.Marshal(&Record{Log: fmt.Sprintf("line %d", i), Source: Stdout, ContainerID: "1234567890",
+			ContainerName: "web", Image: "busybox", Labels: map[string]string{"app": "demo"}})
+		buf.Write(append(line, '\n'))
+	}
+	orphan := path.Join(path.Dir(info.LogPath), fmt.Sprintf("1234567890-spill-%d.log", cmd.Process.Pid))
+	if err := os.WriteFile(orphan, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 5; i < 10; i++ {
+		if err = driver.Log(&Entry{Log: fmt.Sprintf("line %d\n", i), Stream: Stdout, Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err = driver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 溢出文件中的日志在新的日志之前发送
+	checkRecords(t, collector.records, 10)
+	if left, _ := os.ReadDir(path.Dir(info.LogPath)); len(left) != 0 {
+		t.Fatalf("spill file not removed: %v", left)
+	}
+}
+
+func TestFluentdForward(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []*Record)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var records []*Record
+		for {
+			message, err := decodeMsgpack(reader)
+			if err != nil {
+				received <- records
+				return
+			}
+			// [tag, [[time, record], ...]]
+			msg := message.([]interface{})
+			for _, e := range msg[1].([]interface{}) {
+				event := e.([]interface{})
+				fields := event[1].(map[string]interface{})
+				labels := map[string]string{}
+				for k, v := range fields["labels"].(map[string]interface{}) {
+					labels[k] = v.(string)
+				}
+				records = append(records, &Record{
+					Time: event[0].(time.Time), Log: fields["log"].(string), Source: fields["source"].(string),
+					Tag: msg[0].(string), ContainerID: fields["container_id"].(string),
+					ContainerName: fields["container_name"].(string), Image: fields["image"].(string), Labels: labels,
+				})
+			}
+		}
+	}()
+
+	driver, err := New(forwardInfo(t, FluentdDriver, map[string]string{
+		"fluentd-address": "tcp://" + listener.Addr().String(), "tag": "docker.{{.Name}}", "batch-size": "3",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logLines(t, driver, 10)
+	if err = driver.Close(); err != nil {
+		t.Fatal(err)
+	}
+	records := <-received
+	checkRecords(t, records, 10)
+	if records[0].Tag != "docker.web" || records[0].Time.IsZero() {
+		t.Fatalf("unexpected record %+v", records[0])
+	}
+}
+
+func TestForwardOptions(t *testing.T) {
+	valid := []*Config{
+		{Type: FluentdDriver},
+		{Type: FluentdDriver, Config: map[string]string{"fluentd-address": "127.0.0.1:24224", "max-backoff": "5s"}},
+		{Type: HTTPDriver, Config: map[string]string{"http-url": "https://logs.example.com/v1", "batch-size": "10"}},
+	}
+	for _, config := range valid {
+		if err := ValidateOptions(config); err != nil {
+			t.Fatalf("%v: %v", config, err)
+		}
+	}
+	invalid := []*Config{
+		{Type: FluentdDriver, Config: map[string]string{"fluentd-address": "localhost"}},
+		{Type: FluentdDriver, Config: map[string]string{"batch-size": "0"}},
+		{Type: HTTPDriver},
+		{Type: HTTPDriver, Config: map[string]string{"http-url": "ftp://example.com"}},
+		{Type: HTTPDriver, Config: map[string]string{"http-url": "http://example.com", "flush-interval": "soon"}},
+	}
+	for _, config := range invalid {
+		if err := ValidateOptions(config); err == nil {
+			t.Fatalf("%v: expect error", config)
+		}
+	}
+}
+
+// decodeMsgpack 只解码 fluentd 日志驱动会产生的类型
+func decodeMsgpack(r *bufio.Reader) (interface{}, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	readN := func(n int) ([]byte, error) {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	var length int
+	switch {
+	case b&0xe0 == 0xa0:
+		buf, err := readN(int(b & 0x1f))
+		return string(buf), err
+	case b == 0xd9:
+		n, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf, err := readN(int(n))
+		return string(buf), err
+	case b&0xf0 == 0x90, b == 0xdc:
+		if length = int(b & 0x0f); b == 0xdc {
+			buf, err := readN(2)
+			if err != nil {
+				return nil, err
+			}
+			length = int(binary.BigEndian.Uint16(buf))
+		}
+		array := make([]interface{}, length)
+		for i := range array {
+			if array[i], err = decodeMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case b&0xf0 == 0x80:
+		m := make(map[string]interface{})
+		for i := 0; i < int(b&0x0f); i++ {
+			key, err := decodeMsgpack(r)
+			if err != nil {
+				return nil, err
+			}
+			if m[key.(string)], err = decodeMsgpack(r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case b == 0xd7:
+		buf, err := readN(9)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(buf[1:5])), int64(binary.BigEndian.Uint32(buf[5:]))), nil
+	}
+	return nil, fmt.Errorf("unexpected msgpack type 0x%x", b)
+}
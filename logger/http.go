@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	HTTPDriver         = "http"
+	defaultHTTPTimeout = 10 * time.Second
+)
+
+// parseHTTPOptions 解析 http-url、http-timeout 和转发共用参数
+func parseHTTPOptions(options map[string]string) (string, time.Duration, *forwardOptions, error) {
+	opts := defaultForwardOptions()
+	endpoint := ""
+	timeout := defaultHTTPTimeout
+	for key, value := range options {
+		handled, err := opts.parse(key, value)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		if handled {
+			continue
+		}
+		switch key {
+		case "http-url":
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return "", 0, nil, errors.Errorf("invalid log option http-url=%s, expect http(s)://host[:port]/path", value)
+			}
+			endpoint = value
+		case "http-timeout":
+			if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+				return "", 0, nil, errors.Errorf("invalid log option http-timeout=%s", value)
+			}
+		default:
+			return "", 0, nil, errors.Errorf("unknown log option %s for %s log driver", key, HTTPDriver)
+		}
+	}
+	if endpoint == "" {
+		return "", 0, nil, errors.Errorf("%s log driver requires http-url", HTTPDriver)
+	}
+	return endpoint, timeout, opts, nil
+}
+
+// NewHTTP 创建 http 日志驱动，每批日志以 JSON 数组的格式 POST 到 http-url，返回 2xx 时视为发送成功
+func NewHTTP(info *Info) (Driver, error) {
+	endpoint, timeout, opts, err := parseHTTPOptions(info.Config.Config)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: timeout}
+	send := func(records []*Record) error {
+		body, err := json.Marshal(records)
+		if err != nil {
+			return errors.WithMessage(err, "records marshal failed")
+		}
+		resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return errors.WithMessagef(err, "post %s failed", endpoint)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return errors.Errorf("post %s failed, status %s", endpoint, resp.Status)
+		}
+		return nil
+	}
+	return newForwarder(info, opts, send)
+}
@@ -0,0 +1,226 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aspirshar/myContainer/constant"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+	// maxLineSize 单条日志的最大长度，超过时拆分为多条，与 docker 保持一致
+	maxLineSize = 16 * 1024
+)
+
+// Entry 一条日志，格式与 docker json-file 日志驱动相同，比如
+// {"log":"hello\n","stream":"stdout","time":"2024-01-01T00:00:00.000000001Z"}
+type Entry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// JSONFile 将日志以每行一个 JSON 对象的格式追加到文件中
+/*
+同一个日志文件可能有多个写入者：容器的日志进程和 exec -d 的后台进程。
+只有持有容器 stdout、stderr 管道的日志进程负责轮转（opts 不为 nil），
+其他写入者在写入前检查文件是否已被轮转，如果是则重新打开，保证日志不会写进旧文件
+*/
+type JSONFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+	opts *JSONFileOptions
+	// compressing 在后台压缩轮转文件的 goroutine
+	compressing sync.WaitGroup
+}
+
+// NewJSONFile 以追加方式打开日志文件，opts 为 nil 时不负责轮转
+func NewJSONFile(path string, opts *JSONFileOptions) (*JSONFile, error) {
+	l := &JSONFile{path: path, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *JSONFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, constant.Perm0644)
+	if err != nil {
+		return errors.WithMessagef(err, "open log file %s failed", l.path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.WithMessagef(err, "stat log file %s failed", l.path)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Log 写入一条日志，stdout 和 stderr 由不同的 goroutine 写入，这里加锁保证每行完整
+func (l *JSONFile) Log(entry *Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.WithMessage(err, "log entry marshal failed")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opts == nil {
+		if err = l.reopenIfRotated(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(append(line, '\n'))
+	l.size += int64(n)
+	if err != nil {
+		return errors.WithMessage(err, "write log failed")
+	}
+	if l.opts != nil && l.opts.MaxSize > 0 && l.size >= l.opts.MaxSize {
+		return l.rotate()
+	}
+	return nil
+}
+
+// reopenIfRotated 日志文件被其他进程轮转后重新打开
+func (l *JSONFile) reopenIfRotated() error {
+	pathInfo, err := os.Stat(l.path)
+	if err == nil {
+		fileInfo, err := l.file.Stat()
+		if err == nil && os.SameFile(pathInfo, fileInfo) {
+			return nil
+		}
+	}
+	l.file.Close()
+	return l.open()
+}
+
+// rotate 轮转日志文件
+/*
+1.只保留一个文件时直接清空当前文件
+2.删除最旧的文件，将 <log>.i 依次改名为 <log>.i+1，当前文件改名为 <log>.1
+3.重新创建日志文件，需要时在后台压缩 <log>.1，压缩期间不阻塞日志写入
+4.下一次轮转前等待上一次的压缩完成，避免改名时 <log>.1 还在压缩中
+*/
+func (l *JSONFile) rotate() error {
+	if l.opts.MaxFile <= 1 {
+		if err := l.file.Truncate(0); err != nil {
+			return errors.WithMessagef(err, "truncate log file %s failed", l.path)
+		}
+		l.size = 0
+		return nil
+	}
+	if err := l.file.Close(); err != nil {
+		return errors.WithMessagef(err, "close log file %s failed", l.path)
+	}
+	l.compressing.Wait()
+	for i := l.opts.MaxFile - 1; i >= 1; i-- {
+		for _, ext := range []string{"", ".gz"} {
+			from := RotatedPath(l.path, i) + ext
+			if i == l.opts.MaxFile-1 {
+				if err := os.Remove(from); err != nil && !os.IsNotExist(err) {
+					return errors.WithMessagef(err, "remove log file %s failed", from)
+				}
+				continue
+			}
+			to := RotatedPath(l.path, i+1) + ext
+			if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+				return errors.WithMessagef(err, "rename log file %s failed", from)
+			}
+		}
+	}
+	rotated := RotatedPath(l.path, 1)
+	if err := os.Rename(l.path, rotated); err != nil {
+		return errors.WithMessagef(err, "rename log file %s failed", l.path)
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	if l.opts.Compress {
+		l.compressing.Add(1)
+		go func() {
+			defer l.compressing.Done()
+			if err := compressFile(rotated); err != nil {
+				log.Errorf("compress log file error %v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Close 关闭日志文件，并等待后台的压缩完成
+func (l *JSONFile) Close() error {
+	err := l.file.Close()
+	l.compressing.Wait()
+	return err
+}
+
+// RotatedPath 返回第 i 个轮转后的日志文件路径，i 越大越旧
+func RotatedPath(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
+
+// compressFile 将文件压缩为 <path>.gz 并删除原文件，先写临时文件，读取方不会读到压缩了一半的文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return errors.WithMessagef(err, "open %s failed", path)
+	}
+	defer src.Close()
+	tmpPath := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, constant.Perm0644)
+	if err != nil {
+		return errors.WithMessagef(err, "create %s failed", tmpPath)
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.WithMessagef(err, "compress %s failed", path)
+	}
+	if err = os.Rename(tmpPath, path+".gz"); err != nil {
+		return errors.WithMessagef(err, "rename %s failed", tmpPath)
+	}
+	return errors.WithMessagef(os.Remove(path), "remove %s failed", path)
+}
+
+// Copy 按行读取 r 中的输出交给日志驱动，直到 r 被关闭
+// 每条日志保留行尾的换行符，超过 maxLineSize 的行和最后没有换行符的内容原样作为一条日志
+// 日志驱动出错时丢弃这条日志并继续读取，避免管道写满后阻塞容器进程
+func Copy(driver Driver, r io.Reader, stream string) error {
+	reader := bufio.NewReaderSize(r, maxLineSize)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if len(line) > 0 {
+			if logErr := driver.Log(&Entry{Log: string(line), Stream: stream, Time: time.Now().UTC()}); logErr != nil {
+				log.Errorf("log %s error %v", stream, logErr)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.WithMessagef(err, "read %s failed", stream)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package logger
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyAndDecode(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "test-json.log")
+	l, err := NewJSONFile(logPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	longLine := strings.Repeat("a", maxLineSize+10)
+	if err = Copy(l, strings.NewReader("hello\n"+longLine+"\nlast"), Stdout); err != nil {
+		t.Fatal(err)
+	}
+	if err = Copy(l, strings.NewReader("oops\n"), Stderr); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	entries, err := readAll(logPath, &ReadConfig{Tail: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []struct{ log, stream string }{
+		{"hello\n", Stdout},
+		{longLine[:maxLineSize], Stdout},
+		{longLine[maxLineSize:] + "\n", Stdout},
+		{"last", Stdout},
+		{"oops\n", Stderr},
+	}
+	if len(entries) != len(expect) {
+		t.Fatalf("expect %d entries, got %d", len(expect), len(entries))
+	}
+	for i, e := range expect {
+		if entries[i].Log != e.log || entries[i].Stream != e.stream || entries[i].Time.IsZero() {
+			t.Fatalf("entry %d: expect %q %s, got %+v", i, e.log, e.stream, entries[i])
+		}
+	}
+}
+
+func TestRotateAndReadAcrossFiles(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "test-json.log")
+	opts, err := ParseJSONFileOptions(map[string]string{"max-size": "200", "max-file": "3", "compress": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewJSONFile(logPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每条日志约 70 字节，每个文件保存 3 条
+	for i := 0; i < 20; i++ {
+		if err = l.Log(&Entry{Log: strconv.Itoa(i) + "\n", Stream: Stdout, Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+	if files := rotatedFiles(logPath); len(files) != 2 || !strings.HasSuffix(files[0], ".2.gz") || !strings.HasSuffix(files[1], ".1.gz") {
+		t.Fatalf("unexpected rotated files %v", files)
+	}
+
+	entries, err := readAll(logPath, &ReadConfig{Tail: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最旧的文件已被删除，剩下的日志连续且有序
+	if len(entries) == 0 || entries[len(entries)-1].Log != "19\n" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	first, _ := strconv.Atoi(strings.TrimSpace(entries[0].Log))
+	for i, entry := range entries {
+		if entry.Log != strconv.Itoa(first+i)+"\n" {
+			t.Fatalf("entry %d: unexpected log %q", i, entry.Log)
+		}
+	}
+
+	// tail 超过当前文件的行数时从轮转文件中补足
+	entries, err = readAll(logPath, &ReadConfig{Tail: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 || entries[0].Log != "15\n" || entries[4].Log != "19\n" {
+		t.Fatalf("unexpected tail entries %+v", entries)
+	}
+}
+
+func TestParseJSONFileOptions(t *testing.T) {
+	opts, err := ParseJSONFileOptions(map[string]string{"max-size": "10m", "max-file": "3"})
+	if err != nil || opts.MaxSize != 10<<20 || opts.MaxFile != 3 || opts.Compress {
+		t.Fatalf("unexpected options %+v %v", opts, err)
+	}
+	for _, invalid := range []map[string]string{
+		{"max-size": "ten"},
+		{"max-size": "1m", "max-file": "0"},
+		{"max-file": "3"},
+		{"unknown": "1"},
+	} {
+		if _, err = ParseJSONFileOptions(invalid); err == nil {
+			t.Fatalf("expect error for %v", invalid)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// 这里只实现 Fluentd Forward 协议用到的 MessagePack 编码：字符串、数组、map 和 EventTime
+
+func appendString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n < 1<<8:
+		buf = append(buf, 0xd9, byte(n))
+	case n < 1<<16:
+		buf = append(buf, 0xda)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 0xdb)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendArrayHeader(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x90|byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xdc), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, 0xdd), uint32(n))
+	}
+}
+
+func appendMapHeader(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x80|byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xde), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, 0xdf), uint32(n))
+	}
+}
+
+// appendEventTime 编码 Fluentd 的 EventTime：ext 类型 0，4 字节秒和 4 字节纳秒
+func appendEventTime(buf []byte, t time.Time) []byte {
+	buf = append(buf, 0xd7, 0x00)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(t.Unix()))
+	return binary.BigEndian.AppendUint32(buf, uint32(t.Nanosecond()))
+}
@@ -0,0 +1,348 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// followInterval 跟随模式下轮询日志文件的间隔
+const followInterval = 200 * time.Millisecond
+
+// ReadConfig 读取日志的参数
+type ReadConfig struct {
+	Tail   int       // 只读取最后 Tail 条日志，小于 0 时读取全部
+	Since  time.Time // 只读取该时间之后的日志，零值表示不限制
+	Until  time.Time // 只读取该时间之前的日志，零值表示不限制
+	Follow bool      // 读到文件末尾后继续等待新的日志
+	// Running 跟随模式下判断容器是否仍在运行，返回 false 后读完剩余的日志即返回
+	Running func() bool
+}
+
+// ReadJSONFile 按 config 读取 json-file 格式的日志，包括轮转后的日志文件，符合条件的日志交给 handle 处理
+/*
+1.指定了 Tail 时从当前文件末尾向前查找最后 Tail 行的起始位置，不需要读取整个文件，行数不够时再从较新的轮转文件中补足
+2.按从旧到新的顺序读取轮转后的文件和当前文件，根据日志中的时间过滤 Since、Until 之外的日志
+3.跟随模式下读到文件末尾后轮询文件，日志文件被轮转（inode 变化）时重新打开，被截断时从头读取
+*/
+func ReadJSONFile(path string, config *ReadConfig, handle func(*Entry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.WithMessagef(err, "open log file %s failed", path)
+	}
+	// next 为轮转后新创建的日志文件
+	var next *os.File
+	defer func() {
+		file.Close()
+		if next != nil {
+			next.Close()
+		}
+	}()
+
+	rotated := rotatedFiles(path)
+	// 第一个需要读取的轮转文件开头要跳过的行数
+	skip := 0
+	if config.Tail >= 0 {
+		offset, found, err := tailOffset(file, config.Tail)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			return errors.WithMessagef(err, "seek log file %s failed", path)
+		}
+		remaining := config.Tail - found
+		first := len(rotated)
+		for remaining > 0 && first > 0 {
+			first--
+			n, err := countLines(rotated[first])
+			if err != nil {
+				return err
+			}
+			if n >= remaining {
+				skip = n - remaining
+				break
+			}
+			remaining -= n
+		}
+		rotated = rotated[first:]
+	}
+
+	emit := func(line []byte) (bool, error) {
+		entry := new(Entry)
+		if err := json.Unmarshal(line, entry); err != nil {
+			return false, errors.WithMessagef(err, "decode log entry %q failed", bytes.TrimSpace(line))
+		}
+		if !config.Until.IsZero() && entry.Time.After(config.Until) {
+			// 日志按时间顺序写入，之后的日志都在 Until 之后
+			return true, nil
+		}
+		if !config.Since.IsZero() && entry.Time.Before(config.Since) {
+			return false, nil
+		}
+		return false, handle(entry)
+	}
+	for i, rotatedPath := range rotated {
+		if i > 0 {
+			skip = 0
+		}
+		stop, err := readRotatedFile(rotatedPath, skip, emit)
+		if err != nil || stop {
+			return err
+		}
+	}
+
+	reader := bufio.NewReader(file)
+	// 跟随模式下可能读到写了一半的行，保存起来等待剩余部分
+	var pending []byte
+	exited := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		pending = append(pending, line...)
+		if err == nil {
+			stop, err := emit(pending)
+			if err != nil || stop {
+				return err
+			}
+			pending = pending[:0]
+			continue
+		}
+		if err != io.EOF {
+			return errors.WithMessagef(err, "read log file %s failed", path)
+		}
+
+		// 读到了文件末尾
+		if !config.Follow || exited {
+			return nil
+		}
+		if !config.Until.IsZero() && time.Now().After(config.Until) {
+			return nil
+		}
+		if next != nil {
+			file.Close()
+			file, next = next, nil
+			reader.Reset(file)
+			pending = pending[:0]
+			continue
+		}
+		if next, err = reopenIfRotated(path, file); err != nil {
+			return err
+		}
+		if next != nil {
+			// 先读完轮转前写入旧文件的日志，下次读到末尾时再切换到新文件
+			continue
+		}
+		if truncated, err := isTruncated(file); err != nil {
+			return err
+		} else if truncated {
+			if _, err = file.Seek(0, io.SeekStart); err != nil {
+				return errors.WithMessagef(err, "seek log file %s failed", path)
+			}
+			reader.Reset(file)
+			pending = pending[:0]
+			continue
+		}
+		// 容器退出后日志进程可能还有没写完的日志，再读一遍后返回
+		if config.Running != nil && !config.Running() {
+			exited = true
+			continue
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// rotatedFiles 按从旧到新的顺序返回轮转后的日志文件，压缩过的文件以 .gz 结尾
+func rotatedFiles(path string) []string {
+	var files []string
+	for i := 1; ; i++ {
+		rotatedPath := RotatedPath(path, i)
+		if _, err := os.Stat(rotatedPath); err != nil {
+			rotatedPath += ".gz"
+			if _, err = os.Stat(rotatedPath); err != nil {
+				break
+			}
+		}
+		files = append([]string{rotatedPath}, files...)
+	}
+	return files
+}
+
+// openRotatedFile 打开轮转后的日志文件，压缩过的文件返回解压后的内容
+func openRotatedFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "open log file %s failed", path)
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, errors.WithMessagef(err, "open compressed log file %s failed", path)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, file}, nil
+}
+
+// countLines 统计轮转后的日志文件中的日志条数
+func countLines(path string) (int, error) {
+	file, err := openRotatedFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	count := 0
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := file.Read(buf)
+		count += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, errors.WithMessagef(err, "read log file %s failed", path)
+		}
+	}
+}
+
+// readRotatedFile 跳过开头的 skip 行后逐行读取轮转后的日志文件，emit 返回 true 时停止读取并返回 true
+func readRotatedFile(path string, skip int, emit func([]byte) (bool, error)) (bool, error) {
+	file, err := openRotatedFile(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			if skip > 0 {
+				skip--
+			} else if stop, err := emit(line); err != nil || stop {
+				return stop, err
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.WithMessagef(err, "read log file %s failed", path)
+		}
+	}
+}
+
+// tailOffset 从文件末尾向前查找，返回最后 n 行的起始位置和找到的行数，文件不足 n 行时返回 0 和文件的总行数
+func tailOffset(file *os.File, n int) (int64, int, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, errors.WithMessage(err, "stat log file failed")
+	}
+	size := info.Size()
+	if n == 0 || size == 0 {
+		return size, 0, nil
+	}
+	const chunkSize = 4096
+	buf := make([]byte, chunkSize)
+	offset := size
+	count := 0
+	for offset > 0 {
+		readSize := int64(chunkSize)
+		if offset < readSize {
+			readSize = offset
+		}
+		offset -= readSize
+		if _, err = file.ReadAt(buf[:readSize], offset); err != nil && err != io.EOF {
+			return 0, 0, errors.WithMessage(err, "read log file failed")
+		}
+		for i := readSize - 1; i >= 0; i-- {
+			// 文件最后一个换行符是最后一行的结尾，不作为分隔
+			if buf[i] != '\n' || offset+i == size-1 {
+				continue
+			}
+			count++
+			if count == n {
+				return offset + i + 1, n, nil
+			}
+		}
+	}
+	// 第一行前面没有换行符
+	return 0, count + 1, nil
+}
+
+// reopenIfRotated 日志文件路径指向了新的文件时打开并返回新文件，否则返回 nil
+func reopenIfRotated(path string, file *os.File) (*os.File, error) {
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		// 轮转时旧文件已经改名而新文件还没有创建
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithMessagef(err, "stat log file %s failed", path)
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "stat log file %s failed", path)
+	}
+	if os.SameFile(pathInfo, fileInfo) {
+		return nil, nil
+	}
+	reopened, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithMessagef(err, "open log file %s failed", path)
+	}
+	return reopened, nil
+}
+
+// isTruncated 判断文件是否被截断，即文件大小小于当前的读取位置
+func isTruncated(file *os.File) (bool, error) {
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, errors.WithMessage(err, "seek log file failed")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false, errors.WithMessage(err, "stat log file failed")
+	}
+	return info.Size() < offset, nil
+}
+
+// ParseTime 解析 --since、--until 参数，支持 RFC3339 格式的时间、Unix 时间戳和 10m 这样相对于 now 的时长
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	// Unix 时间戳，可以带小数部分
+	secs, frac, _ := strings.Cut(value, ".")
+	if sec, err := strconv.ParseInt(secs, 10, 64); err == nil {
+		var nsec int64
+		if frac != "" {
+			frac = (frac + "000000000")[:9]
+			if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+				return time.Time{}, errors.Errorf("invalid time %s", value)
+			}
+		}
+		return time.Unix(sec, nsec), nil
+	}
+	return time.Time{}, errors.Errorf("invalid time %s", value)
+}
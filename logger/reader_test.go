@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func readAll(logPath string, config *ReadConfig) ([]*Entry, error) {
+	var entries []*Entry
+	err := ReadJSONFile(logPath, config, func(entry *Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+// writeEntries 写入 n 条日志，第 i 条的内容为 i，时间为 base 之后 i 秒
+func writeEntries(t *testing.T, logPath string, base time.Time, from, to int) {
+	l, err := NewJSONFile(logPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := from; i < to; i++ {
+		entry := &Entry{Log: strconv.Itoa(i) + "\n", Stream: Stdout, Time: base.Add(time.Duration(i) * time.Second)}
+		if err = l.Log(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadTailAndTimeWindow(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "test-json.log")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 超过一个读取块，验证跨块向前查找
+	writeEntries(t, logPath, base, 0, 1000)
+
+	entries, err := readAll(logPath, &ReadConfig{Tail: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Log != "997\n" || entries[2].Log != "999\n" {
+		t.Fatalf("unexpected tail entries %+v", entries)
+	}
+	if entries, _ = readAll(logPath, &ReadConfig{Tail: 0}); len(entries) != 0 {
+		t.Fatalf("expect no entries for tail 0, got %d", len(entries))
+	}
+	if entries, _ = readAll(logPath, &ReadConfig{Tail: 5000}); len(entries) != 1000 {
+		t.Fatalf("expect all entries, got %d", len(entries))
+	}
+
+	entries, err = readAll(logPath, &ReadConfig{Tail: -1, Since: base.Add(10 * time.Second), Until: base.Add(12 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Log != "10\n" || entries[2].Log != "12\n" {
+		t.Fatalf("unexpected time window entries %+v", entries)
+	}
+}
+
+func TestReadFollowRotation(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "test-json.log")
+	base := time.Now()
+	writeEntries(t, logPath, base, 0, 2)
+
+	running := make(chan bool, 1)
+	running <- true
+	done := make(chan []*Entry)
+	go func() {
+		entries, err := readAll(logPath, &ReadConfig{
+			Tail:   -1,
+			Follow: true,
+			Running: func() bool {
+				select {
+				case r := <-running:
+					running <- r
+					return r
+				default:
+					return true
+				}
+			},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- entries
+	}()
+
+	time.Sleep(3 * followInterval)
+	writeEntries(t, logPath, base, 2, 3)
+	// 轮转：旧文件改名后创建新文件
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, logPath, base, 3, 5)
+	time.Sleep(3 * followInterval)
+	<-running
+	running <- false
+
+	select {
+	case entries := <-done:
+		if len(entries) != 5 {
+			t.Fatalf("expect 5 entries, got %d", len(entries))
+		}
+		for i, entry := range entries {
+			if entry.Log != strconv.Itoa(i)+"\n" {
+				t.Fatalf("entry %d: unexpected log %q", i, entry.Log)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follow did not stop after the container exited")
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"10m":                  now.Add(-10 * time.Minute),
+		"2024-01-01T10:00:00Z": time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		"1704103200.5":         time.Unix(1704103200, 500000000),
+	}
+	for value, expect := range cases {
+		got, err := ParseTime(value, now)
+		if err != nil || !got.Equal(expect) {
+			t.Fatalf("parse %s: expect %v, got %v %v", value, expect, got, err)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Fatal("expect error for invalid time")
+	}
+}
@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSyslogAddress = "unix:///dev/log"
+	defaultSyslogPort    = "514"
+	// RFC5424 中的日志级别，stdout 为 info，stderr 为 err
+	severityErr  = 3
+	severityInfo = 6
+	// rfc5424TimeFormat RFC5424 的时间格式，精确到微秒
+	rfc5424TimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogFacilities RFC5424 中的 facility 名称和编号
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogOptions syslog 日志驱动的参数
+type syslogOptions struct {
+	network  string // unix、unixgram 或 udp
+	address  string
+	facility int
+}
+
+// parseSyslogOptions 解析 syslog-address、syslog-facility、tag 参数
+/*
+syslog-address 的格式为 unix:///dev/log、unixgram:///dev/log 或 udp://host[:port]，默认为 unix:///dev/log，
+unix 地址会先尝试数据报 socket，/dev/log 通常是数据报 socket
+*/
+func parseSyslogOptions(options map[string]string) (*syslogOptions, error) {
+	opts := &syslogOptions{facility: syslogFacilities["daemon"]}
+	address := defaultSyslogAddress
+	for key, value := range options {
+		switch key {
+		case "syslog-address":
+			address = value
+		case "syslog-facility":
+			facility, ok := syslogFacilities[value]
+			if !ok {
+				return nil, errors.Errorf("invalid syslog facility %s", value)
+			}
+			opts.facility = facility
+		case "tag":
+			if _, err := template.New("tag").Parse(value); err != nil {
+				return nil, errors.WithMessagef(err, "invalid log tag %s", value)
+			}
+		default:
+			return nil, errors.Errorf("unknown log option %s for %s log driver", key, SyslogDriver)
+		}
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid syslog address %s", address)
+	}
+	opts.network = u.Scheme
+	switch u.Scheme {
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return nil, errors.Errorf("invalid syslog address %s, missing socket path", address)
+		}
+		opts.address = u.Path
+	case "udp":
+		if u.Host == "" {
+			return nil, errors.Errorf("invalid syslog address %s, missing host", address)
+		}
+		opts.address = u.Host
+		if u.Port() == "" {
+			opts.address = net.JoinHostPort(u.Host, defaultSyslogPort)
+		}
+	default:
+		return nil, errors.Errorf("invalid syslog address %s, expect unix, unixgram or udp", address)
+	}
+	return opts, nil
+}
+
+// Syslog 以 RFC5424 格式将日志发送到 syslog
+type Syslog struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	stream   bool // 流式 socket 需要用换行符分隔每条日志
+	facility int
+	hostname string
+	tag      string
+}
+
+// NewSyslog 连接 syslog 地址并创建 syslog 日志驱动
+func NewSyslog(info *Info) (*Syslog, error) {
+	opts, err := parseSyslogOptions(info.Config.Config)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := info.Tag()
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	// RFC5424 中空的字段用 - 表示
+	if tag == "" {
+		tag = "-"
+	}
+	s := &Syslog{facility: opts.facility, hostname: hostname, tag: tag}
+	switch opts.network {
+	case "unix":
+		if s.conn, err = net.Dial("unixgram", opts.address); err != nil {
+			s.conn, err = net.Dial("unix", opts.address)
+			s.stream = true
+		}
+	default:
+		s.conn, err = net.Dial(opts.network, opts.address)
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "connect syslog %s failed", opts.address)
+	}
+	return s, nil
+}
+
+// Log 发送一条日志，格式为 <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *Syslog) Log(entry *Entry) error {
+	severity := severityInfo
+	if entry.Stream == Stderr {
+		severity = severityErr
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s - - - %s", s.facility*8+severity, entry.Time.Format(rfc5424TimeFormat),
+		s.hostname, s.tag, strings.TrimSuffix(entry.Log, "\n"))
+	if s.stream {
+		msg += "\n"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.conn.Write([]byte(msg))
+	return errors.WithMessage(err, "write syslog failed")
+}
+
+func (s *Syslog) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,162 @@
+package logger
+
+import (
+	"bufio"
+	"net"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var rfc5424Pattern = regexp.MustCompile(`^<(\d+)>1 \S+ \S+ (\S+) - - - (.*)$`)
+
+// checkSyslogMessage 校验 RFC5424 格式的消息，返回 PRI、APP-NAME 和 MSG
+func checkSyslogMessage(t *testing.T, msg string, pri, tag, log string) {
+	match := rfc5424Pattern.FindStringSubmatch(msg)
+	if match == nil {
+		t.Fatalf("invalid RFC5424 message %q", msg)
+	}
+	if match[1] != pri || match[2] != tag || match[3] != log {
+		t.Fatalf("expect <%s> %s %q, got %q", pri, tag, log, msg)
+	}
+}
+
+func newTestSyslog(t *testing.T, address string, extra map[string]string) Driver {
+	options := map[string]string{"syslog-address": address}
+	for k, v := range extra {
+		options[k] = v
+	}
+	info := &Info{
+		Config:        Config{Type: SyslogDriver, Config: options},
+		ContainerID:   "1234567890",
+		ContainerName: "web",
+		ImageName:     "busybox",
+	}
+	if err := ValidateOptions(&info.Config); err != nil {
+		t.Fatal(err)
+	}
+	driver, err := New(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return driver
+}
+
+func TestSyslogUnixgram(t *testing.T) {
+	socket := path.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	driver := newTestSyslog(t, "unix://"+socket, map[string]string{"tag": "{{.Name}}/{{.ImageName}}", "syslog-facility": "local0"})
+	defer driver.Close()
+	if err = driver.Log(&Entry{Log: "hello\n", Stream: Stdout, Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err = driver.Log(&Entry{Log: "oops\n", Stream: Stderr, Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// local0 为 16，stdout 为 info(6)，stderr 为 err(3)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSyslogMessage(t, string(buf[:n]), "134", "web/busybox", "hello")
+	if n, err = conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	checkSyslogMessage(t, string(buf[:n]), "131", "web/busybox", "oops")
+}
+
+func TestSyslogUnixStream(t *testing.T) {
+	socket := path.Join(t.TempDir(), "log.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	driver := newTestSyslog(t, "unix://"+socket, nil)
+	defer driver.Close()
+	for _, log := range []string{"first\n", "second\n"} {
+		if err = driver.Log(&Entry{Log: log, Stream: Stdout, Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 默认 facility 为 daemon(3)，标签为容器ID
+	for _, log := range []string{"first", "second"} {
+		select {
+		case line := <-lines:
+			checkSyslogMessage(t, line, "30", "1234567890", log)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for syslog message")
+		}
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	driver := newTestSyslog(t, "udp://"+conn.LocalAddr().String(), nil)
+	defer driver.Close()
+	if err = driver.Log(&Entry{Log: "hello udp\n", Stream: Stdout, Time: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSyslogMessage(t, string(buf[:n]), "30", "1234567890", "hello udp")
+}
+
+func TestValidateOptions(t *testing.T) {
+	valid := []*Config{
+		{},
+		{Type: JSONFileDriver, Config: map[string]string{"max-size": "1m"}},
+		{Type: SyslogDriver, Config: map[string]string{"syslog-address": "udp://127.0.0.1", "tag": "{{.ID}}"}},
+		{Type: NoneDriver},
+	}
+	for _, config := range valid {
+		if err := ValidateOptions(config); err != nil {
+			t.Fatalf("expect %+v valid, got %v", config, err)
+		}
+	}
+	invalid := []*Config{
+		{Type: "unknown"},
+		{Type: SyslogDriver, Config: map[string]string{"syslog-address": "tcp://127.0.0.1:514"}},
+		{Type: SyslogDriver, Config: map[string]string{"syslog-facility": "nope"}},
+		{Type: SyslogDriver, Config: map[string]string{"max-size": "1m"}},
+		{Type: NoneDriver, Config: map[string]string{"tag": "x"}},
+	}
+	for _, config := range invalid {
+		if err := ValidateOptions(config); err == nil {
+			t.Fatalf("expect error for %+v", config)
+		}
+	}
+	if !Readable(nil) || Readable(&Config{Type: SyslogDriver}) {
+		t.Fatal("only json-file logs should be readable")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aspirshar/myContainer/registry"
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
+)
+
+// loginRegistry 验证用户名和密码后保存到登录信息文件，没有指定的用户名和密码从标准输入读取
+/*
+1.passwordStdin 为 true 时从标准输入读取密码，便于在脚本中使用，e.g. echo $TOKEN | mycontainer login -u user --password-stdin
+2.否则在终端中提示输入，输入密码时关闭回显
+*/
+func loginRegistry(server, username, password string, passwordStdin bool) error {
+	server = registry.NormalizeRegistry(server)
+	stdin := bufio.NewReader(os.Stdin)
+	if passwordStdin {
+		if username == "" {
+			return errors.New("must provide --username with --password-stdin")
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return errors.WithMessage(err, "read password from stdin failed")
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	if username == "" {
+		fmt.Print("Username: ")
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return errors.WithMessage(err, "read username failed")
+		}
+		username = strings.TrimSpace(line)
+	}
+	if password == "" && !passwordStdin {
+		fmt.Print("Password: ")
+		if utils.IsTerminal(os.Stdin) {
+			restore, err := utils.DisableEcho(os.Stdin)
+			if err != nil {
+				return err
+			}
+			defer restore()
+		}
+		line, err := stdin.ReadString('\n')
+		fmt.Println()
+		if err != nil && line == "" {
+			return errors.WithMessage(err, "read password failed")
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if username == "" || password == "" {
+		return errors.New("username and password are required")
+	}
+
+	if err := registry.NewClient().Login(server, username, password); err != nil {
+		return err
+	}
+	if err := registry.SaveCredentials(server, username, password); err != nil {
+		return err
+	}
+	fmt.Println("Login Succeeded")
+	return nil
+}
+
+// logoutRegistry 删除 registry 的登录信息
+func logoutRegistry(server string) error {
+	server = registry.NormalizeRegistry(server)
+	found, err := registry.RemoveCredentials(server)
+	if err != nil {
+		return err
+	}
+	if !found {
+		fmt.Printf("Not logged in to %s\n", server)
+		return nil
+	}
+	fmt.Printf("Removing login credentials for %s\n", server)
+	return nil
+}
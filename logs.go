@@ -1,16 +1,30 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/logger"
+	"github.com/pkg/errors"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func logContainer(containerIdOrName string) {
+// logOptions logs 命令的参数
+type logOptions struct {
+	Timestamps bool // 是否显示日志时间
+	Follow     bool // 是否持续输出新的日志直到容器退出
+	Tail       int  // 只显示最后 Tail 条日志，小于 0 时显示全部
+	Since      time.Time
+	Until      time.Time
+}
+
+func logContainer(containerIdOrName string, opts *logOptions) {
 	// 首先尝试通过容器名称获取容器ID
 	containerId := containerIdOrName
 	containerInfo, err := container.GetContainerInfoByName(containerIdOrName)
@@ -23,21 +37,98 @@ func logContainer(containerIdOrName string) {
 		log.Infof("Found container '%s' with ID: %s", containerIdOrName, containerId)
 	}
 
-	logFileLocation := fmt.Sprintf(container.InfoLocFormat, containerId) + container.GetLogfile(containerId)
-	file, err := os.Open(logFileLocation)
-	defer file.Close()
-	if err != nil {
-		log.Errorf("Log container open file %s error %v", logFileLocation, err)
+	if containerInfo == nil {
+		containerInfo, _ = getInfoByContainerId(containerId)
+	}
+	// 只有 json-file 日志驱动的日志保存在本地，可以读取
+	if containerInfo != nil && !logger.Readable(containerInfo.LogConfig) {
+		log.Errorf("Container %s uses the %s log driver, its logs can not be read back by logs, only the %s log driver supports it",
+			containerIdOrName, containerInfo.LogConfig.DriverName(), logger.JSONFileDriver)
 		return
 	}
-	content, err := io.ReadAll(file)
+	logFileLocation := container.GetLogPath(containerId)
+	readConfig := &logger.ReadConfig{
+		Tail:   opts.Tail,
+		Since:  opts.Since,
+		Until:  opts.Until,
+		Follow: opts.Follow,
+		// 每次重新读取容器信息，容器退出或被删除后结束跟随
+		Running: func() bool {
+			info, err := getInfoByContainerId(containerId)
+			return err == nil && isContainerRunning(info)
+		},
+	}
+	// 按日志记录的 stream 分别输出到 stdout 和 stderr
+	err = logger.ReadJSONFile(logFileLocation, readConfig, func(entry *logger.Entry) error {
+		out := os.Stdout
+		if entry.Stream == logger.Stderr {
+			out = os.Stderr
+		}
+		if opts.Timestamps {
+			if _, err := fmt.Fprint(out, entry.Time.Format(time.RFC3339Nano), " "); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprint(out, entry.Log)
+		return err
+	})
 	if err != nil {
 		log.Errorf("Log container read file %s error %v", logFileLocation, err)
 		return
 	}
-	_, err = fmt.Fprint(os.Stdout, string(content))
+}
+
+// runLogger 后台日志进程，从 fd 3、fd 4 读取容器的 stdout、stderr，从 fd 5 读取日志配置，将输出交给日志驱动处理
+/*
+1.创建日志驱动后通过 fd 6 告诉父进程结果，创建失败时父进程不会启动容器
+2.日志驱动创建失败时仍然读取并丢弃容器的输出，容器写入时不会因为管道没有读端收到 SIGPIPE
+*/
+func runLogger() error {
+	streams := map[string]io.Reader{
+		logger.Stdout: os.NewFile(uintptr(3), "stdout"),
+		logger.Stderr: os.NewFile(uintptr(4), "stderr"),
+	}
+	driver, err := newLoggerDriver()
+	statusPipe := os.NewFile(uintptr(6), "status")
+	status := container.LoggerReady
 	if err != nil {
-		log.Errorf("Log container Fprint  error %v", err)
-		return
+		status = err.Error()
+	}
+	_, _ = statusPipe.WriteString(status)
+	statusPipe.Close()
+	if err != nil {
+		log.Errorf("create log driver error %v, container output is discarded", err)
+		copyStreams(logger.Discard(), streams)
+		return err
+	}
+	defer driver.Close()
+	copyStreams(driver, streams)
+	return nil
+}
+
+// newLoggerDriver 从 fd 5 读取日志配置并创建日志驱动
+func newLoggerDriver() (logger.Driver, error) {
+	configPipe := os.NewFile(uintptr(5), "config")
+	logInfo := new(logger.Info)
+	err := json.NewDecoder(configPipe).Decode(logInfo)
+	configPipe.Close()
+	if err != nil {
+		return nil, errors.WithMessage(err, "read log config failed")
+	}
+	return logger.New(logInfo)
+}
+
+// copyStreams 并发地将多个输出流写入日志，所有流都读到 EOF 后返回
+func copyStreams(driver logger.Driver, streams map[string]io.Reader) {
+	var wg sync.WaitGroup
+	for stream, reader := range streams {
+		wg.Add(1)
+		go func(stream string, reader io.Reader) {
+			defer wg.Done()
+			if err := logger.Copy(driver, reader, stream); err != nil {
+				log.Errorf("copy %s to log error %v", stream, err)
+			}
+		}(stream, reader)
 	}
-}
\ No newline at end of file
+	wg.Wait()
+}
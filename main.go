@@ -20,17 +20,38 @@ func main() {
 		RunCommand,
 		commitCommand,
 		listCommand,
+		inspectCommand,
+		imagesCommand,
+		rmiCommand,
+		tagCommand,
+		historyCommand,
+		loadCommand,
+		saveCommand,
+		pullCommand,
+		pushCommand,
+		loginCommand,
+		logoutCommand,
+		buildCommand,
+		imageCommand,
 		logCommand,
 		execCommand,
+		enterCommand,
 		stopCommand,
 		removeCommand,
 		networkCommand,
+		healthMonitorCommand,
+		execShimCommand,
+		loggerCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
 		// 设置日志输出格式
 		log.SetFormatter(&log.JSONFormatter{})
 		log.SetOutput(os.Stdout)
+		// save 可能将 tar 包写入标准输出，日志改为输出到标准错误
+		if len(os.Args) > 1 && os.Args[1] == "save" {
+			log.SetOutput(os.Stderr)
+		}
 
 		// 对于 init 命令，跳过配置初始化，因为它在容器内部执行
 		if len(os.Args) > 1 && os.Args[1] == "init" {
@@ -4,14 +4,34 @@ import (
 	"fmt"
 	"github.com/aspirshar/myContainer/cgroups/resource"
 	"github.com/aspirshar/myContainer/network"
+	"github.com/aspirshar/myContainer/utils"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/logger"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
+// 容器、网络和镜像共用的标签参数
+var (
+	labelFlag = cli.StringSliceFlag{
+		Name:  "label",
+		Usage: "set metadata label,e.g. --label team=infra --label service=web",
+	}
+	labelFileFlag = cli.StringSliceFlag{
+		Name:  "label-file",
+		Usage: "read labels from a file of k=v lines,e.g. --label-file ./labels",
+	}
+	filterFlag = cli.StringSliceFlag{
+		Name:  "filter",
+		Usage: "filter output by label,e.g. --filter label=team --filter label=service=web",
+	}
+)
+
 var RunCommand = cli.Command{
 	Name: "run",
 	Usage: `Create a container with namespace and cgroups limit
@@ -49,7 +69,20 @@ var RunCommand = cli.Command{
 		},
 		cli.StringSliceFlag{
 			Name:  "e",
-			Usage: "set environment,e.g. -e name mycontainer",
+			Usage: "set environment,e.g. -e name=mycontainer, -e NAME passes NAME from the host",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read environment from a file of NAME=VALUE lines,e.g. --env-file ./env",
+		},
+		cli.StringFlag{
+			Name:  "log-driver",
+			Value: logger.JSONFileDriver,
+			Usage: "logging driver for the container: json-file, syslog, fluentd, http or none",
+		},
+		cli.StringSliceFlag{
+			Name:  "log-opt",
+			Usage: "log driver options,e.g. --log-opt max-size=10m --log-opt max-file=3 --log-opt compress=true for json-file, --log-opt tag={{.Name}} for syslog, fluentd and http",
 		},
 		cli.StringFlag{
 			Name:  "net",
@@ -59,6 +92,56 @@ var RunCommand = cli.Command{
 			Name:  "p",
 			Usage: "port mapping,e.g. -p 8080:80 -p 30336:3306",
 		},
+		cli.BoolFlag{
+			Name:  "init",
+			Usage: "run an init inside the container that reaps zombies and forwards signals",
+		},
+		cli.StringFlag{
+			Name:  "health-cmd",
+			Usage: "command to run to check health,e.g. --health-cmd 'wget -q -O- localhost'",
+		},
+		cli.DurationFlag{
+			Name:  "health-interval",
+			Usage: "time between running the check,e.g. --health-interval 30s",
+		},
+		cli.DurationFlag{
+			Name:  "health-timeout",
+			Usage: "maximum time to allow one check to run,e.g. --health-timeout 30s",
+		},
+		cli.IntFlag{
+			Name:  "health-retries",
+			Usage: "consecutive failures needed to report unhealthy,e.g. --health-retries 3",
+		},
+		cli.DurationFlag{
+			Name:  "health-start-period",
+			Usage: "start period for the container to initialize before failures count,e.g. --health-start-period 10s",
+		},
+		labelFlag,
+		labelFileFlag,
+		cli.StringFlag{
+			Name:  "workdir, w",
+			Usage: "working directory inside the container,e.g. -w /app",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "username or uid with optional group,e.g. -u nobody or -u 1000:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "group-add",
+			Usage: "additional groups to join,e.g. --group-add audio",
+		},
+		cli.StringFlag{
+			Name:  "hostname",
+			Usage: "container host name,default is the container id",
+		},
+		cli.StringFlag{
+			Name:  "domainname",
+			Usage: "container NIS domain name",
+		},
+		cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "overwrite the default entrypoint of the image,e.g. --entrypoint /bin/sh",
+		},
 	},
 	/*
 		run命令执行的函数。
@@ -68,7 +151,7 @@ var RunCommand = cli.Command{
 	*/
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
-			return fmt.Errorf("missing container command")
+			return fmt.Errorf("missing image name")
 		}
 
 		var cmdArray []string
@@ -98,12 +181,64 @@ var RunCommand = cli.Command{
 		log.Info("resConf:", resConf)
 		volume := context.String("v")
 		containerName := context.String("name")
-		envSlice := context.StringSlice("e")
 
 		network := context.String("net")
 		portMapping := context.StringSlice("p")
+		initConf := &container.InitConfig{
+			Init:             context.Bool("init"),
+			Cwd:              context.String("workdir"),
+			User:             context.String("user"),
+			AdditionalGroups: context.StringSlice("group-add"),
+			Hostname:         context.String("hostname"),
+			Domainname:       context.String("domainname"),
+		}
 
-		Run(tty, cmdArray, envSlice, resConf, volume, containerName, imageName, network, portMapping)
+		// 本地没有镜像时从 registry 拉取
+		if err := ensureImage(imageName, ""); err != nil {
+			return err
+		}
+		// 镜像配置中的命令、工作目录、用户、环境变量、标签和健康检查等作为默认值
+		imageConfig, err := container.LoadImageConfig(imageName)
+		if err != nil {
+			log.Warnf("load image %s config failed, detail: %v", imageName, err)
+		}
+		var entrypoint []string
+		if context.String("entrypoint") != "" {
+			entrypoint = []string{context.String("entrypoint")}
+		}
+		applyImageConfig(initConf, imageConfig, entrypoint, context.IsSet("entrypoint"), cmdArray)
+		if len(initConf.Args) == 0 {
+			return fmt.Errorf("missing container command")
+		}
+		health := healthConfigFromContext(context, imageConfig)
+		labels, err := utils.ParseLabels(context.StringSlice("label"), context.StringSlice("label-file"))
+		if err != nil {
+			return err
+		}
+		if imageConfig != nil && len(imageConfig.Labels) > 0 {
+			labels = utils.MergeLabels(imageConfig.Labels, labels)
+		}
+		envSlice, err := utils.ParseEnv(context.StringSlice("e"), context.StringSlice("env-file"))
+		if err != nil {
+			return err
+		}
+		if imageConfig != nil {
+			envSlice = utils.MergeEnv(imageConfig.Env, envSlice)
+		}
+		logOpts, err := logger.ParseOptions(context.StringSlice("log-opt"))
+		if err != nil {
+			return err
+		}
+		logConfig := &logger.Config{Type: context.String("log-driver"), Config: logOpts}
+		// 提前校验日志参数，避免容器启动后日志进程才报错
+		if err = logger.ValidateOptions(logConfig); err != nil {
+			return err
+		}
+
+		// 以容器的退出码作为 run 命令的退出码，便于在脚本中使用
+		if exitCode := Run(tty, initConf, imageConfig, envSlice, resConf, volume, containerName, imageName, network, portMapping, health, labels, logConfig); exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
 		return nil
 	},
 }
@@ -117,44 +252,440 @@ var initCommand = cli.Command{
 	},
 }
 
+var healthMonitorCommand = cli.Command{
+	Name:   "health-monitor",
+	Usage:  "Run health checks of a container in background. Do not call it outside",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return monitorHealth(context.Args().Get(0))
+	},
+}
+
+var enterCommand = cli.Command{
+	Name:  "enter",
+	Usage: "run a command in selected namespaces of a container,e.g. mycontainer enter 123456789 --net -- ss -tnlp",
+	Flags: []cli.Flag{
+		cli.BoolFlag{Name: "net", Usage: "enter the network namespace"},
+		cli.BoolFlag{Name: "pid", Usage: "enter the pid namespace"},
+		cli.BoolFlag{Name: "mount", Usage: "enter the mount namespace"},
+		cli.BoolFlag{Name: "uts", Usage: "enter the uts namespace"},
+		cli.BoolFlag{Name: "ipc", Usage: "enter the ipc namespace"},
+	},
+	Action: func(context *cli.Context) error {
+		// 与 exec 相同，C代码已经执行过命令时直接返回
+		if os.Getenv(EnvExecPid) != "" {
+			return nil
+		}
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		var namespaces []string
+		for _, ns := range enterNamespaces {
+			if context.Bool(ns.Flag) {
+				namespaces = append(namespaces, ns.Name)
+			}
+		}
+		if len(namespaces) == 0 {
+			return fmt.Errorf("at least one of --net, --pid, --mount, --uts, --ipc is required")
+		}
+		commandArray := context.Args().Tail()
+		if len(commandArray) > 0 && commandArray[0] == "--" {
+			commandArray = commandArray[1:]
+		}
+		if exitCode := EnterContainer(context.Args().Get(0), namespaces, commandArray); exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
+		return nil
+	},
+}
+
+var execShimCommand = cli.Command{
+	Name:   "exec-shim",
+	Usage:  "Run a detached exec command and record its exit code. Do not call it outside",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("missing container id or exec id")
+		}
+		return runExecShim(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
 var commitCommand = cli.Command{
 	Name:  "commit",
 	Usage: "commit container to image,e.g. mycontainer commit 123456789 myimage",
+	Flags: []cli.Flag{
+		labelFlag,
+		labelFileFlag,
+		cli.StringFlag{
+			Name:  "message, m",
+			Usage: "commit message",
+		},
+		cli.StringFlag{
+			Name:  "author, a",
+			Usage: "author, e.g. \"John Hannibal Smith <hannibal@a-team.com>\"",
+		},
+		cli.StringSliceFlag{
+			Name:  "change, c",
+			Usage: "apply Dockerfile instruction to the created image, e.g. --change 'CMD [\"sh\"]'",
+		},
+		cli.BoolTFlag{
+			Name:  "pause, p",
+			Usage: "pause container during commit, use --pause=false to disable",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 2 {
 			return fmt.Errorf("missing container name and image name")
 		}
 		containerID := context.Args().Get(0)
 		imageName := context.Args().Get(1)
-		return commitContainer(containerID, imageName)
+		labels, err := utils.ParseLabels(context.StringSlice("label"), context.StringSlice("label-file"))
+		if err != nil {
+			return err
+		}
+		return commitContainer(containerID, imageName, &commitOptions{
+			Labels:  labels,
+			Message: context.String("message"),
+			Author:  context.String("author"),
+			Changes: context.StringSlice("change"),
+			Pause:   context.BoolT("pause"),
+		})
 	},
 }
 
 var listCommand = cli.Command{
 	Name:  "ps",
 	Usage: "list all the containers",
+	Flags: []cli.Flag{
+		filterFlag,
+	},
 	Action: func(context *cli.Context) error {
-		ListContainers()
+		ListContainers(context.StringSlice("filter"))
 		return nil
 	},
 }
 
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "display detailed information of a container or image,e.g. mycontainer inspect 1234567890",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container or image name")
+		}
+		return inspectObject(context.Args().Get(0))
+	},
+}
+
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images,e.g. mycontainer images",
+	Flags: []cli.Flag{
+		filterFlag,
+	},
+	Action: func(context *cli.Context) error {
+		return listImages(context.StringSlice("filter"))
+	},
+}
+
+var rmiCommand = cli.Command{
+	Name:  "rmi",
+	Usage: "remove one or more images,e.g. mycontainer rmi busybox:latest",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "remove all tags of the image and remove images used by stopped containers",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return removeImages(context.Args(), context.Bool("force"))
+	},
+}
+
+var tagCommand = cli.Command{
+	Name:  "tag",
+	Usage: "create a tag that refers to an image,e.g. mycontainer tag busybox mybusybox:v1",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("missing source or target image name")
+		}
+		return tagImage(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+var historyCommand = cli.Command{
+	Name:  "history",
+	Usage: "show the history of an image,e.g. mycontainer history busybox",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "don't truncate output",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return imageHistory(context.Args().Get(0), context.Bool("no-trunc"))
+	},
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from a docker save or OCI image layout tar archive,e.g. mycontainer load -i busybox.tar",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "input, i",
+			Usage: "read from tar archive file, instead of stdin",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return loadImages(context.String("input"))
+	},
+}
+
+var pullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "pull an image from a registry,e.g. mycontainer pull busybox:latest",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "pull the image for the platform, e.g. linux/arm64",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return pullImage(context.Args().Get(0), context.String("platform"))
+	},
+}
+var pushCommand = cli.Command{
+	Name:  "push",
+	Usage: "push an image to a registry,e.g. mycontainer push localhost:5000/busybox:v1",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return pushImage(context.Args().Get(0))
+	},
+}
+var loginCommand = cli.Command{
+	Name:  "login",
+	Usage: "log in to a registry,e.g. mycontainer login -u user localhost:5000",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "username, u",
+			Usage: "username",
+		},
+		cli.StringFlag{
+			Name:  "password, p",
+			Usage: "password",
+		},
+		cli.BoolFlag{
+			Name:  "password-stdin",
+			Usage: "take the password from stdin",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return loginRegistry(context.Args().Get(0), context.String("username"), context.String("password"), context.Bool("password-stdin"))
+	},
+}
+var logoutCommand = cli.Command{
+	Name:  "logout",
+	Usage: "log out from a registry,e.g. mycontainer logout localhost:5000",
+	Action: func(context *cli.Context) error {
+		return logoutRegistry(context.Args().Get(0))
+	},
+}
+var buildCommand = cli.Command{
+	Name:  "build",
+	Usage: "build an image from a Dockerfile,e.g. mycontainer build -t app:v1 .",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "tag, t",
+			Usage: "name and optionally a tag for the image, e.g. -t app:v1",
+		},
+		cli.StringFlag{
+			Name:  "file, f",
+			Usage: "path of the Dockerfile, default is PATH/Dockerfile",
+		},
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Usage: "set build-time variables, e.g. --build-arg VERSION=1.0",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use cache when building the image",
+		},
+		cli.StringFlag{
+			Name:  "network",
+			Usage: "connect the containers of RUN instructions to the network",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing build context path")
+		}
+		return buildImage(&buildOptions{
+			Tags:       context.StringSlice("tag"),
+			Dockerfile: context.String("file"),
+			ContextDir: context.Args().Get(0),
+			BuildArgs:  context.StringSlice("build-arg"),
+			NoCache:    context.Bool("no-cache"),
+			Network:    context.String("network"),
+		})
+	},
+}
+var saveCommand = cli.Command{
+	Name:  "save",
+	Usage: "save images to a tar archive,e.g. mycontainer save -o busybox.tar busybox",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "write to a file, instead of stdout",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: "docker",
+			Usage: "archive format: docker or oci",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return saveImages(context.Args(), context.String("output"), context.String("format"))
+	},
+}
+
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "image commands",
+	Subcommands: []cli.Command{
+		{
+			Name:  "inspect",
+			Usage: "display detailed information of an image,e.g. mycontainer image inspect busybox",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				return inspectImage(context.Args().Get(0))
+			},
+		},
+	},
+}
+
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "print logs of a container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "timestamps, t",
+			Usage: "show timestamps",
+		},
+		cli.BoolFlag{
+			Name:  "follow, f",
+			Usage: "follow log output until the container exits",
+		},
+		cli.StringFlag{
+			Name:  "tail, n",
+			Value: "all",
+			Usage: "number of lines to show from the end of the logs,e.g. --tail 100",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show logs since timestamp (e.g. 2024-01-02T15:04:05Z) or relative (e.g. 10m)",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "show logs before timestamp (e.g. 2024-01-02T15:04:05Z) or relative (e.g. 10m)",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("please input your container id")
 		}
 		containerName := context.Args().Get(0)
-		logContainer(containerName)
+		opts := &logOptions{
+			Timestamps: context.Bool("timestamps"),
+			Follow:     context.Bool("follow"),
+			Tail:       -1,
+		}
+		if tail := context.String("tail"); tail != "all" {
+			n, err := strconv.Atoi(tail)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid --tail %s", tail)
+			}
+			opts.Tail = n
+		}
+		now := time.Now()
+		var err error
+		if opts.Since, err = logger.ParseTime(context.String("since"), now); err != nil {
+			return err
+		}
+		if opts.Until, err = logger.ParseTime(context.String("until"), now); err != nil {
+			return err
+		}
+		logContainer(containerName, opts)
 		return nil
 	},
 }
 
+var loggerCommand = cli.Command{
+	Name:   "logger",
+	Usage:  "Send container stdout and stderr to the log driver. Do not call it outside",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		return runLogger()
+	},
+}
+
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container mycontainer exec 123456789 /bin/sh",
+	// 支持 -it 这样的组合短选项
+	UseShortOptionHandling: true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "detach, d",
+			Usage: "run command in background,output goes to the container log",
+		},
+		cli.BoolFlag{
+			Name:  "interactive, i",
+			Usage: "keep stdin attached",
+		},
+		cli.BoolFlag{
+			Name:  "tty, t",
+			Usage: "allocate a pseudo-tty",
+		},
+		cli.StringSliceFlag{
+			Name:  "env, e",
+			Usage: "set environment,e.g. -e name=mycontainer, -e NAME passes NAME from the host",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read environment from a file of NAME=VALUE lines,e.g. --env-file ./env",
+		},
+		cli.StringFlag{
+			Name:  "workdir, w",
+			Usage: "working directory inside the container,e.g. -w /app",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "username or uid with optional group,e.g. -u nobody or -u 1000:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "group-add",
+			Usage: "additional groups to join,e.g. --group-add audio",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		// 如果环境变量存在，说明C代码已经运行过了，即setns系统调用已经执行了，这里就直接返回，避免重复执行
 		if os.Getenv(EnvExecPid) != "" {
@@ -168,7 +699,25 @@ var execCommand = cli.Command{
 		containerName := context.Args().Get(0)
 		// 将除了容器名之外的参数作为命令部分
 		commandArray := context.Args().Tail()
-		ExecContainer(containerName, commandArray)
+		envs, err := utils.ParseEnv(context.StringSlice("env"), context.StringSlice("env-file"))
+		if err != nil {
+			return err
+		}
+		opts := &execOptions{
+			Cwd:         context.String("workdir"),
+			User:        context.String("user"),
+			GroupAdd:    context.StringSlice("group-add"),
+			Env:         envs,
+			Interactive: context.Bool("interactive"),
+			Tty:         context.Bool("tty"),
+			Detach:      context.Bool("detach"),
+		}
+		if opts.Detach && (opts.Interactive || opts.Tty) {
+			return fmt.Errorf("-d can not be used with -i or -t")
+		}
+		if exitCode := ExecContainer(containerName, commandArray, opts); exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
 		return nil
 	},
 }
@@ -222,6 +771,8 @@ var networkCommand = cli.Command{
 					Name:  "subnet",
 					Usage: "subnet cidr",
 				},
+				labelFlag,
+				labelFileFlag,
 			},
 			Action: func(context *cli.Context) error {
 				if len(context.Args()) < 1 {
@@ -230,8 +781,12 @@ var networkCommand = cli.Command{
 				driver := context.String("driver")
 				subnet := context.String("subnet")
 				name := context.Args()[0]
+				labels, err := utils.ParseLabels(context.StringSlice("label"), context.StringSlice("label-file"))
+				if err != nil {
+					return err
+				}
 
-				err := network.CreateNetwork(driver, subnet, name)
+				err = network.CreateNetwork(driver, subnet, name, labels)
 				if err != nil {
 					return fmt.Errorf("create network error: %+v", err)
 				}
@@ -241,11 +796,24 @@ var networkCommand = cli.Command{
 		{
 			Name:  "list",
 			Usage: "list container network",
+			Flags: []cli.Flag{
+				filterFlag,
+			},
 			Action: func(context *cli.Context) error {
-				network.ListNetwork()
+				network.ListNetwork(context.StringSlice("filter"))
 				return nil
 			},
 		},
+		{
+			Name:  "inspect",
+			Usage: "display detailed information of a container network",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing network name")
+				}
+				return network.InspectNetwork(context.Args()[0])
+			},
+		},
 		{
 			Name:  "remove",
 			Usage: "remove container network",
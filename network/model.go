@@ -6,9 +6,10 @@ import (
 )
 
 type Network struct {
-	Name    string     // 网络名
-	IPRange *net.IPNet // 地址段
-	Driver  string     // 网络驱动名
+	Name    string            // 网络名
+	IPRange *net.IPNet        // 地址段
+	Driver  string            // 网络驱动名
+	Labels  map[string]string `json:",omitempty"` // 网络标签
 }
 
 type Endpoint struct {
@@ -14,6 +14,7 @@ import (
 
 	"github.com/aspirshar/myContainer/constant"
 	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/utils"
 
 	"github.com/pkg/errors"
 
@@ -88,21 +89,14 @@ func (net *Network) remove(dumpPath string) error {
 }
 
 func (net *Network) load(dumpPath string) error {
-	// 打开配置文件
-	netConfigFile, err := os.Open(dumpPath)
-	if err != nil {
-		return err
-	}
-	defer netConfigFile.Close()
-	// 从配置文件中读取网络 配置 json 符串
-	netJson := make([]byte, 2000)
-	n, err := netConfigFile.Read(netJson)
+	// 从配置文件中读取网络配置 json 字符串，带标签的配置可能较大，需要完整读取
+	netJson, err := os.ReadFile(dumpPath)
 	if err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(netJson[:n], net)
-	return errors.Wrapf(err, "unmarshal %s failed", netJson[:n])
+	err = json.Unmarshal(netJson, net)
+	return errors.Wrapf(err, "unmarshal %s failed", netJson)
 }
 
 // LoadFromFile 读取 defaultNetworkPath 目录下的 Network 信息存放到内存中，便于使用
@@ -135,7 +129,7 @@ func loadNetwork() (map[string]*Network, error) {
 }
 
 // CreateNetwork 根据不同 driver 创建 Network
-func CreateNetwork(driver, subnet, name string) error {
+func CreateNetwork(driver, subnet, name string, labels map[string]string) error {
 	// 将网段的字符串转换成net. IPNet的对象
 	_, cidr, _ := net.ParseCIDR(subnet)
 	// 通过IPAM分配网关IP，获取到网段中第一个IP作为网关的IP
@@ -150,12 +144,13 @@ func CreateNetwork(driver, subnet, name string) error {
 	if err != nil {
 		return err
 	}
+	net.Labels = labels
 	// 保存网络信息，将网络的信息保存在文件系统中，以便查询和在网络上连接网络端点
 	return net.dump(defaultNetworkPath)
 }
 
-// ListNetwork 打印出当前全部 Network 信息
-func ListNetwork() {
+// ListNetwork 打印出当前全部 Network 信息，filters 为 label=k 或 label=k=v 形式的过滤条件
+func ListNetwork(filters []string) {
 	networks, err := loadNetwork()
 	if err != nil {
 		logrus.Errorf("load network from file failed,detail: %v", err)
@@ -163,12 +158,21 @@ func ListNetwork() {
 	}
 	// 通过tabwriter库把信息打印到屏幕上
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprint(w, "NAME\tIpRange\tDriver\n")
+	fmt.Fprint(w, "NAME\tIpRange\tDriver\tLabels\n")
 	for _, net := range networks {
-		fmt.Fprintf(w, "%s\t%s\t%s\n",
+		matched, err := utils.MatchLabelFilters(net.Labels, filters)
+		if err != nil {
+			logrus.Errorf("filter network failed,detail: %v", err)
+			return
+		}
+		if !matched {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
 			net.Name,
 			net.IPRange.String(),
 			net.Driver,
+			utils.FormatLabels(net.Labels),
 		)
 	}
 	if err = w.Flush(); err != nil {
@@ -177,6 +181,24 @@ func ListNetwork() {
 	}
 }
 
+// InspectNetwork 以 JSON 格式打印指定 Network 的详细信息
+func InspectNetwork(networkName string) error {
+	networks, err := loadNetwork()
+	if err != nil {
+		return errors.WithMessage(err, "load network from file failed")
+	}
+	net, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("no Such Network: %s", networkName)
+	}
+	netJson, err := json.MarshalIndent(net, "", "    ")
+	if err != nil {
+		return errors.Wrapf(err, "Marshal %v failed", net)
+	}
+	fmt.Println(string(netJson))
+	return nil
+}
+
 // DeleteNetwork 根据名字删除 Network
 func DeleteNetwork(networkName string) error {
 	networks, err := loadNetwork()
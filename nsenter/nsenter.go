@@ -5,52 +5,287 @@ package nsenter
 #include <unistd.h>
 #include <errno.h>
 #include <sched.h>
+#include <signal.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <sys/prctl.h>
+#include <sys/stat.h>
+#include <sys/wait.h>
+
+// 与 docker exec 保持一致的退出码
+#define EXIT_SETUP_FAILED 125   // 进入容器失败
+#define EXIT_CANNOT_EXEC  126   // 命令无法执行
+#define EXIT_NOT_FOUND    127   // 命令不存在
+
+#define MAX_GROUPS 64
+
+static pid_t child_pid = -1;
+
+static void fail(const char *fmt, const char *arg) {
+	fprintf(stderr, "nsenter: ");
+	fprintf(stderr, fmt, arg, strerror(errno));
+	fprintf(stderr, "\n");
+	exit(EXIT_SETUP_FAILED);
+}
+
+// read_argv 读取Go代码序列化到 mydocker_argc、mydocker_argv_<i> 中的命令参数，参数原样传给 execvp，不经过 shell 解析
+static char **read_argv(void) {
+	char *argc_env = getenv("mydocker_argc");
+	if (!argc_env) {
+		return NULL;
+	}
+	int argc = atoi(argc_env);
+	if (argc <= 0) {
+		return NULL;
+	}
+	char **argv = calloc(argc + 1, sizeof(char *));
+	char name[64];
+	int i;
+	for (i = 0; i < argc; i++) {
+		snprintf(name, sizeof(name), "mydocker_argv_%d", i);
+		char *arg = getenv(name);
+		if (!arg) {
+			return NULL;
+		}
+		argv[i] = strdup(arg);
+		unsetenv(name);
+	}
+	argv[argc] = NULL;
+	unsetenv("mydocker_argc");
+	return argv;
+}
+
+// write_pid 将当前进程写入指定 cgroup 目录的 cgroup.procs
+static int write_pid(const char *dir) {
+	char procs[4096];
+	snprintf(procs, sizeof(procs), "%s/cgroup.procs", dir);
+	int fd = open(procs, O_WRONLY);
+	if (fd == -1) {
+		return -1;
+	}
+	char pid[32];
+	int len = snprintf(pid, sizeof(pid), "%d", getpid());
+	int ret = write(fd, pid, len) == len ? 0 : -1;
+	close(fd);
+	return ret;
+}
+
+// join_cgroup 加入容器 init 进程所在的 cgroup，必须在进入 cgroup namespace 之前执行，保证读到的是宿主机视角的路径
+static void join_cgroup(const char *pid) {
+	char path[256];
+	snprintf(path, sizeof(path), "/proc/%s/cgroup", pid);
+	FILE *f = fopen(path, "r");
+	if (!f) {
+		fail("open %s failed: %s", path);
+	}
+	char line[4096];
+	char dir[4096];
+	// 没有挂载对应 hierarchy 的 cgroup 目录时跳过
+	while (fgets(line, sizeof(line), f)) {
+		line[strcspn(line, "\n")] = 0;
+		// 每行格式为 hierarchy-ID:controller-list:cgroup-path
+		char *controllers = strchr(line, ':');
+		if (!controllers) {
+			continue;
+		}
+		controllers++;
+		char *cgroup_path = strchr(controllers, ':');
+		if (!cgroup_path) {
+			continue;
+		}
+		*cgroup_path++ = 0;
+		if (*controllers == 0) {
+			// cgroup v2
+			snprintf(dir, sizeof(dir), "/sys/fs/cgroup%s", cgroup_path);
+			if (write_pid(dir) == -1 && errno != ENOENT) {
+				fail("join cgroup %s failed: %s", dir);
+			}
+			continue;
+		}
+		// cgroup v1，name=systemd 这类命名 hierarchy 挂载在去掉 name= 前缀的目录下
+		if (strncmp(controllers, "name=", 5) == 0) {
+			controllers += 5;
+		}
+		snprintf(dir, sizeof(dir), "/sys/fs/cgroup/%s%s", controllers, cgroup_path);
+		if (write_pid(dir) == -1 && errno != ENOENT) {
+			fail("join cgroup %s failed: %s", dir);
+		}
+	}
+	fclose(f);
+}
+
+// selected 判断 namespace 是否在逗号分隔的列表中，列表为空时表示全部
+static int selected(const char *list, const char *ns) {
+	if (!list) {
+		return 1;
+	}
+	size_t len = strlen(ns);
+	const char *p = list;
+	while (*p) {
+		if (strncmp(p, ns, len) == 0 && (p[len] == ',' || p[len] == 0)) {
+			return 1;
+		}
+		p = strchr(p, ',');
+		if (!p) {
+			break;
+		}
+		p++;
+	}
+	return 0;
+}
+
+// join_namespaces 进入容器 init 进程的 namespace，ns_list 不为空时只进入列表中的 namespace
+// 先打开所有 namespace 文件再依次 setns，因为进入 mnt namespace 之后 /proc 已经是容器内的了
+static void join_namespaces(const char *pid, const char *ns_list) {
+	// user 必须最先进入，mnt 最后进入
+	char *namespaces[] = { "user", "cgroup", "ipc", "uts", "net", "pid", "mnt" };
+	int count = sizeof(namespaces) / sizeof(namespaces[0]);
+	int fds[sizeof(namespaces) / sizeof(namespaces[0])];
+	char nspath[256];
+	char selfpath[256];
+	struct stat target, self;
+	int i;
+
+	for (i = 0; i < count; i++) {
+		fds[i] = -1;
+		if (!selected(ns_list, namespaces[i])) {
+			continue;
+		}
+		snprintf(nspath, sizeof(nspath), "/proc/%s/ns/%s", pid, namespaces[i]);
+		snprintf(selfpath, sizeof(selfpath), "/proc/self/ns/%s", namespaces[i]);
+		if (stat(nspath, &target) == -1) {
+			// 内核不支持该 namespace
+			if (errno == ENOENT) {
+				continue;
+			}
+			fail("stat %s failed: %s", nspath);
+		}
+		// 已经处于同一个 namespace 中时不需要进入，对 user namespace 重复 setns 会返回 EINVAL
+		if (stat(selfpath, &self) == 0 && self.st_ino == target.st_ino && self.st_dev == target.st_dev) {
+			continue;
+		}
+		fds[i] = open(nspath, O_RDONLY | O_CLOEXEC);
+		if (fds[i] == -1) {
+			fail("open %s failed: %s", nspath);
+		}
+	}
+	for (i = 0; i < count; i++) {
+		if (fds[i] == -1) {
+			continue;
+		}
+		if (setns(fds[i], 0) == -1) {
+			fail("setns on %s namespace failed: %s", namespaces[i]);
+		}
+		close(fds[i]);
+	}
+}
+
+// setup_process 切换工作目录和用户，mydocker_cwd、mydocker_uid、mydocker_gid、mydocker_groups 由Go代码根据容器内的用户配置解析后传入
+static void setup_process(void) {
+	char *cwd = getenv("mydocker_cwd");
+	if (cwd && chdir(cwd) == -1) {
+		fail("chdir to %s failed: %s", cwd);
+	}
+	char *uid = getenv("mydocker_uid");
+	char *gid = getenv("mydocker_gid");
+	if (!uid || !gid) {
+		return;
+	}
+	// 附加组格式为逗号分隔的gid列表，必须在切换用户之前设置
+	gid_t groups[MAX_GROUPS];
+	size_t ngroups = 0;
+	char *groups_env = getenv("mydocker_groups");
+	if (groups_env && *groups_env) {
+		char *list = strdup(groups_env);
+		char *saveptr = NULL;
+		char *token = strtok_r(list, ",", &saveptr);
+		while (token && ngroups < MAX_GROUPS) {
+			groups[ngroups++] = (gid_t)atoi(token);
+			token = strtok_r(NULL, ",", &saveptr);
+		}
+		free(list);
+	}
+	if (setgroups(ngroups, groups) == -1) {
+		fail("setgroups %s failed: %s", groups_env ? groups_env : "");
+	}
+	if (setgid((gid_t)atoi(gid)) == -1) {
+		fail("setgid %s failed: %s", gid);
+	}
+	if (setuid((uid_t)atoi(uid)) == -1) {
+		fail("setuid %s failed: %s", uid);
+	}
+}
+
+static void forward_signal(int sig) {
+	if (child_pid > 0) {
+		kill(child_pid, sig);
+	}
+}
 
 __attribute__((constructor)) void enter_namespace(void) {
-   // 这里的代码会在Go运行时启动前执行，它会在单线程的C上下文中运行
+	// 这里的代码会在Go运行时启动前执行，它会在单线程的C上下文中运行
 	char *mydocker_pid;
 	mydocker_pid = getenv("mydocker_pid");
-	if (mydocker_pid) {
-		fprintf(stdout, "got mydocker_pid=%s\n", mydocker_pid);
-	} else {
-		fprintf(stdout, "missing mydocker_pid env skip nsenter");
+	if (!mydocker_pid) {
 		// 如果没有指定PID就不需要继续执行，直接退出
 		return;
 	}
-	char *mydocker_cmd;
-	mydocker_cmd = getenv("mydocker_cmd");
-	if (mydocker_cmd) {
-		fprintf(stdout, "got mydocker_cmd=%s\n", mydocker_cmd);
-	} else {
-		fprintf(stdout, "missing mydocker_cmd env skip nsenter");
+	char **argv = read_argv();
+	if (!argv) {
 		// 如果没有指定命令也是直接退出
 		return;
 	}
-	int i;
-	char nspath[1024];
-	// 需要进入的5种namespace
-	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
-
-	for (i=0; i<5; i++) {
-		// 拼接对应路径，类似于/proc/pid/ns/ipc这样
-		sprintf(nspath, "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
-		int fd = open(nspath, O_RDONLY);
-		// 执行setns系统调用，进入对应namespace
-		if (setns(fd, 0) == -1) {
-			fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
-		} else {
-			fprintf(stdout, "setns on %s namespace succeeded\n", namespaces[i]);
-		}
-		close(fd);
-	}
-	// 在进入的Namespace中执行指定命令，然后退出
-	int res = system(mydocker_cmd);
-	exit(0);
-	return;
+
+	// mydocker_ns 由 enter 命令设置，只进入指定的 namespace，不加入容器的 cgroup，以便使用宿主机上的调试工具
+	char *ns_list = getenv("mydocker_ns");
+	if (!ns_list) {
+		join_cgroup(mydocker_pid);
+	}
+	join_namespaces(mydocker_pid, ns_list);
+
+	// 进入 pid namespace 只对之后创建的子进程生效，因此需要 fork 一次，由子进程执行命令
+	child_pid = fork();
+	if (child_pid == -1) {
+		fail("fork %s failed: %s", argv[0]);
+	}
+	if (child_pid == 0) {
+		// 当前进程被杀死时子进程也随之退出，避免残留在容器中
+		prctl(PR_SET_PDEATHSIG, SIGKILL);
+		setup_process();
+		// 控制变量不需要传给容器内的命令
+		unsetenv("mydocker_pid");
+		unsetenv("mydocker_ns");
+		unsetenv("mydocker_cwd");
+		unsetenv("mydocker_uid");
+		unsetenv("mydocker_gid");
+		unsetenv("mydocker_groups");
+		execvp(argv[0], argv);
+		int code = errno == ENOENT ? EXIT_NOT_FOUND : EXIT_CANNOT_EXEC;
+		fprintf(stderr, "nsenter: exec %s failed: %s\n", argv[0], strerror(errno));
+		exit(code);
+	}
+
+	// 将收到的信号转发给子进程，并以子进程的退出码退出
+	int sig;
+	for (sig = 1; sig < NSIG; sig++) {
+		if (sig == SIGKILL || sig == SIGSTOP || sig == SIGCHLD) {
+			continue;
+		}
+		signal(sig, forward_signal);
+	}
+	int status;
+	while (waitpid(child_pid, &status, 0) == -1) {
+		if (errno != EINTR) {
+			fail("wait %s failed: %s", argv[0]);
+		}
+	}
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
 }
 */
 import "C"
@@ -58,4 +293,4 @@ import "C"
 // Init 是一个空函数，用于确保包被正确编译
 func Init() {
 	// 这个函数不做任何事情，只是为了确保包被正确编译
-}
\ No newline at end of file
+}
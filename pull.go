@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aspirshar/myContainer/image"
+	"github.com/aspirshar/myContainer/registry"
+	"github.com/pkg/errors"
+)
+
+// pullImage 从 registry 拉取镜像，platform 为空时拉取当前平台的镜像
+func pullImage(ref, platform string) error {
+	client := registry.NewClient()
+	client.Out = os.Stdout
+	if platform != "" {
+		p, err := image.ParsePlatform(platform)
+		if err != nil {
+			return err
+		}
+		client.Platform = p
+	}
+	_, err := client.Pull(ref)
+	return err
+}
+
+// ensureImage 镜像仓库和 images 目录中都没有该镜像时从 registry 拉取，platform 为空时拉取当前平台的镜像
+func ensureImage(imageName, platform string) error {
+	_, err := image.Get(imageName)
+	if !errors.Is(err, image.ErrImageNotFound) {
+		return err
+	}
+	fmt.Printf("Unable to find image '%s' locally\n", imageName)
+	return pullImage(imageName, platform)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	"github.com/aspirshar/myContainer/registry"
+)
+
+// pushImage 将镜像推送到镜像名称中的 registry，e.g. localhost:5000/app:v1
+func pushImage(ref string) error {
+	client := registry.NewClient()
+	client.Out = os.Stdout
+	_, err := client.Push(ref)
+	return err
+}
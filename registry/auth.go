@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/aspirshar/myContainer/config"
+	"github.com/pkg/errors"
+)
+
+// AuthConfig 一个 registry 的登录信息，auth 为 base64(username:password)，与 docker 的 config.json 格式相同
+type AuthConfig struct {
+	Auth string `json:"auth"`
+}
+
+// AuthFile 登录信息文件，e.g. {"auths":{"localhost:5000":{"auth":"dXNlcjpwYXNz"}}}
+type AuthFile struct {
+	Auths map[string]AuthConfig `json:"auths"`
+}
+
+// LoadAuthFile 读取登录信息文件，文件不存在时返回空的登录信息
+func LoadAuthFile() (*AuthFile, error) {
+	authFile := &AuthFile{Auths: make(map[string]AuthConfig)}
+	data, err := os.ReadFile(config.AuthFile)
+	if os.IsNotExist(err) {
+		return authFile, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "read auth file %s failed", config.AuthFile)
+	}
+	if err = json.Unmarshal(data, authFile); err != nil {
+		return nil, errors.WithMessagef(err, "parse auth file %s failed", config.AuthFile)
+	}
+	if authFile.Auths == nil {
+		authFile.Auths = make(map[string]AuthConfig)
+	}
+	return authFile, nil
+}
+
+// updateAuthFile 加锁修改登录信息文件，文件中有密码，只有所有者可以读写
+func updateAuthFile(update func(authFile *AuthFile)) error {
+	lockFile, err := os.OpenFile(config.AuthFile+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return errors.WithMessage(err, "open auth file lock failed")
+	}
+	defer lockFile.Close()
+	if err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return errors.WithMessage(err, "lock auth file failed")
+	}
+	authFile, err := LoadAuthFile()
+	if err != nil {
+		return err
+	}
+	update(authFile)
+	data, err := json.MarshalIndent(authFile, "", "    ")
+	if err != nil {
+		return errors.WithMessage(err, "auth file marshal failed")
+	}
+	tmpPath := config.AuthFile + ".tmp"
+	if err = os.WriteFile(tmpPath, data, 0600); err != nil {
+		return errors.WithMessage(err, "write auth file failed")
+	}
+	return errors.WithMessage(os.Rename(tmpPath, config.AuthFile), "rename auth file failed")
+}
+
+// SaveCredentials 保存 registry 的用户名和密码
+func SaveCredentials(registry, username, password string) error {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return updateAuthFile(func(authFile *AuthFile) {
+		authFile.Auths[registry] = AuthConfig{Auth: auth}
+	})
+}
+
+// RemoveCredentials 删除 registry 的登录信息，返回之前是否已登录
+func RemoveCredentials(registry string) (bool, error) {
+	found := false
+	err := updateAuthFile(func(authFile *AuthFile) {
+		_, found = authFile.Auths[registry]
+		delete(authFile.Auths, registry)
+	})
+	return found, err
+}
+
+// LookupCredentials 返回 registry 的用户名和密码，没有登录时返回空，作为 Client.Credentials 使用
+func LookupCredentials(registry string) (string, string) {
+	authFile, err := LoadAuthFile()
+	if err != nil {
+		return "", ""
+	}
+	data, err := base64.StdEncoding.DecodeString(authFile.Auths[registry].Auth)
+	if err != nil {
+		return "", ""
+	}
+	username, password, _ := strings.Cut(string(data), ":")
+	return username, password
+}
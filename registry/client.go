@@ -0,0 +1,279 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aspirshar/myContainer/image"
+	"github.com/pkg/errors"
+)
+
+// InsecureRegistriesEnv 使用 http 访问的 registry 列表，逗号分隔，localhost 和 127.0.0.0/8 默认使用 http
+const InsecureRegistriesEnv = "MYCONTAINER_INSECURE_REGISTRIES"
+
+// Client OCI Distribution API 客户端
+type Client struct {
+	HTTPClient *http.Client
+	// Credentials 返回 registry 的用户名和密码，匿名访问时返回空
+	Credentials func(registry string) (string, string)
+	// Platform 拉取多平台镜像时选择的平台
+	Platform image.Platform
+	// Out 输出拉取进度，为 nil 时不输出
+	Out io.Writer
+
+	mu     sync.Mutex
+	tokens map[string]string // registry 和 scope 对应的认证头
+}
+
+// NewClient 创建 registry 客户端，默认拉取当前平台的镜像
+func NewClient() *Client {
+	return &Client{
+		HTTPClient:  &http.Client{Timeout: 30 * time.Minute},
+		Credentials: LookupCredentials,
+		Platform:    image.DefaultPlatform(),
+		tokens:      make(map[string]string),
+	}
+}
+
+// NormalizeRegistry 统一 registry 地址的写法，省略时为 docker hub
+func NormalizeRegistry(registry string) string {
+	registry = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://"), "/")
+	if registry == "" || registry == "index.docker.io" || registry == defaultRegistryHost {
+		return DefaultRegistry
+	}
+	return registry
+}
+
+// Login 以用户名和密码访问 registry 的 /v2/，验证登录信息是否正确
+func (c *Client) Login(registry, username, password string) error {
+	c.Credentials = func(string) (string, string) {
+		return username, password
+	}
+	r := &Reference{Registry: NormalizeRegistry(registry)}
+	req, err := http.NewRequest(http.MethodGet, baseURL(r), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, r, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "login "+r.Registry)
+	}
+	return nil
+}
+
+// printf 输出进度信息
+func (c *Client) printf(format string, args ...interface{}) {
+	if c.Out != nil {
+		c.mu.Lock()
+		fmt.Fprintf(c.Out, format, args...)
+		c.mu.Unlock()
+	}
+}
+
+// baseURL 返回 registry API 的地址，本机和 MYCONTAINER_INSECURE_REGISTRIES 中的 registry 使用 http
+func baseURL(r *Reference) string {
+	host := r.Host()
+	scheme := "https"
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if ip := net.ParseIP(hostname); hostname == "localhost" || (ip != nil && ip.IsLoopback()) {
+		scheme = "http"
+	}
+	for _, insecure := range strings.Split(os.Getenv(InsecureRegistriesEnv), ",") {
+		if strings.TrimSpace(insecure) == host {
+			scheme = "http"
+		}
+	}
+	return scheme + "://" + host + "/v2/"
+}
+
+// repoURL 返回仓库下的 API 地址，e.g. manifests/latest
+func repoURL(r *Reference, suffix string) string {
+	return baseURL(r) + r.Repository + "/" + suffix
+}
+
+// do 发送请求，收到 401 时按 WWW-Authenticate 完成认证后重试一次
+/*
+1.Bearer 认证：向 realm 申请 scope 对应的 token，有用户名密码时以 basic 认证申请
+2.Basic 认证：直接使用用户名密码
+3.认证头按 registry 和 scope 缓存，同一次拉取中的其他请求直接使用
+*/
+func (c *Client) do(req *http.Request, r *Reference, scope string) (*http.Response, error) {
+	key := r.Registry + " " + scope
+	c.mu.Lock()
+	auth := c.tokens[key]
+	c.mu.Unlock()
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "%s %s failed", req.Method, req.URL)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if auth, err = c.authorize(r, challenge, scope); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[key] = auth
+	c.mu.Unlock()
+
+	if req.Body != nil && req.GetBody == nil {
+		return nil, errors.Errorf("%s %s: authorization required", req.Method, req.URL)
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", auth)
+	resp, err = c.HTTPClient.Do(retry)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "%s %s failed", req.Method, req.URL)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, errors.Errorf("%s %s: unauthorized, check your credentials", req.Method, req.URL)
+	}
+	return resp, nil
+}
+
+// authorize 根据 WWW-Authenticate 生成认证头
+func (c *Client) authorize(r *Reference, challenge, scope string) (string, error) {
+	username, password := "", ""
+	if c.Credentials != nil {
+		username, password = c.Credentials(r.Registry)
+	}
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if username == "" {
+			return "", errors.Errorf("registry %s requires authentication, please login first", r.Registry)
+		}
+		req := &http.Request{Header: make(http.Header)}
+		req.SetBasicAuth(username, password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		token, err := c.fetchToken(params, scope, username, password)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", errors.Errorf("registry %s returns unsupported challenge %q", r.Registry, challenge)
+	}
+}
+
+// fetchToken 向认证服务申请 token，e.g. GET https://auth.docker.io/token?service=registry.docker.io&scope=repository:library/busybox:pull
+func (c *Client) fetchToken(params map[string]string, scope, username, password string) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.New("bearer challenge without realm")
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", errors.WithMessagef(err, "invalid realm %s", realm)
+	}
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	// 优先使用 registry 在 challenge 中要求的 scope
+	if challengeScope := params["scope"]; challengeScope != "" {
+		scope = challengeScope
+	}
+	for _, s := range strings.Fields(scope) {
+		query.Add("scope", s)
+	}
+	tokenURL.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", errors.WithMessagef(err, "fetch token from %s failed", realm)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("fetch token from %s failed, status %s", realm, resp.Status)
+	}
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", errors.WithMessage(err, "parse token response failed")
+	}
+	if tokenResp.Token == "" {
+		tokenResp.Token = tokenResp.AccessToken
+	}
+	if tokenResp.Token == "" {
+		return "", errors.Errorf("empty token from %s", realm)
+	}
+	return tokenResp.Token, nil
+}
+
+// parseChallenge 解析 WWW-Authenticate，e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			// 带引号的值中可能有逗号，e.g. scope="repository:app:pull,push"
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[key] = value[1:]
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+	return scheme, params
+}
+
+// responseError 将 registry 返回的错误转换为 error，registry 的错误响应为 {"errors":[{"code":...,"message":...}]}
+func responseError(resp *http.Response, action string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var errResp struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &errResp) == nil && len(errResp.Errors) > 0 {
+		return errors.Errorf("%s failed, status %s: %s %s", action, resp.Status, errResp.Errors[0].Code, errResp.Errors[0].Message)
+	}
+	return errors.Errorf("%s failed, status %s", action, resp.Status)
+}
@@ -0,0 +1,291 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aspirshar/myContainer/image"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxParallelTransfer 同时下载或上传的 blob 数上限
+	maxParallelTransfer = 3
+	// maxDownloadAttempts 每个 blob 最多下载的次数，重试时从已下载的位置继续
+	maxDownloadAttempts = 3
+	// maxManifestSize manifest 和 image index 的大小上限
+	maxManifestSize = 4 << 20
+)
+
+// retryDelay 第一次重试前的等待时间，之后每次重试加倍
+var retryDelay = time.Second
+
+// manifestAccept 请求 manifest 时接受的格式，不接受 schema1
+var manifestAccept = strings.Join([]string{
+	image.MediaTypeIndex,
+	image.MediaTypeManifest,
+	image.DockerMediaTypeManifestList,
+	image.DockerMediaTypeManifest,
+}, ", ")
+
+// Pull 从 registry 拉取镜像写入镜像仓库，返回 manifest digest
+/*
+1.按 tag 或 digest 获取 manifest，校验 manifest 的 digest
+2.获取到 image index 时选择 Platform 对应的 manifest
+3.并行下载镜像配置和各个层，已有的 blob 跳过，下载中断时从已下载的位置继续
+4.最后写入 manifest，按 tag 拉取时将 tag 指向新的 manifest，按 digest 拉取的镜像通过 repo@sha256:... 使用
+*/
+func (c *Client) Pull(name string) (string, error) {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	scope := "repository:" + ref.Repository + ":pull"
+	c.printf("%s: Pulling from %s\n", ref.Reference(), ref.Repository)
+
+	data, mediaType, digest, err := c.fetchManifest(ref, ref.Reference(), scope)
+	if err != nil {
+		return "", err
+	}
+	if image.IsIndex(mediaType) {
+		index := new(image.IndexManifest)
+		if err = json.Unmarshal(data, index); err != nil {
+			return "", errors.WithMessagef(err, "parse image index %s failed", digest)
+		}
+		desc, err := image.SelectManifest(index, c.Platform)
+		if err != nil {
+			return "", errors.WithMessage(err, ref.String())
+		}
+		if data, mediaType, digest, err = c.fetchManifest(ref, desc.Digest, scope); err != nil {
+			return "", err
+		}
+		if image.IsIndex(mediaType) {
+			return "", errors.Errorf("nested image index %s is not supported", digest)
+		}
+	}
+	manifest := new(image.Manifest)
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return "", errors.WithMessagef(err, "parse manifest %s failed", digest)
+	}
+	if manifest.Config.Digest == "" {
+		return "", errors.Errorf("manifest %s has no image config", digest)
+	}
+
+	blobs := append([]image.Descriptor{manifest.Config}, manifest.Layers...)
+	if err = c.downloadBlobs(ref, scope, blobs); err != nil {
+		return "", err
+	}
+	if _, _, err = image.WriteBlob(bytes.NewReader(data), digest); err != nil {
+		return "", err
+	}
+	c.printf("Digest: %s\n", digest)
+
+	if ref.Tag == "" {
+		c.printf("Status: Downloaded image for %s\n", ref.Name())
+		return digest, nil
+	}
+	tag := image.FamiliarName(ref.Registry + "/" + ref.Repository + ":" + ref.Tag)
+	if old, err := image.Resolve(tag); err == nil && old == digest {
+		c.printf("Status: Image is up to date for %s\n", tag)
+		return digest, nil
+	}
+	if err = image.Tag(tag, digest); err != nil {
+		return "", err
+	}
+	c.printf("Status: Downloaded newer image for %s\n", tag)
+	return digest, nil
+}
+
+// fetchManifest 获取 manifest 或 image index，返回内容、media type 和 digest
+/*
+1.reference 是 digest 时校验内容的 digest，是 tag 时校验 registry 返回的 Docker-Content-Digest
+2.media type 优先取 manifest 中的 mediaType 字段，没有时取 Content-Type
+*/
+func (c *Client) fetchManifest(ref *Reference, reference, scope string) ([]byte, string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, repoURL(ref, "manifests/"+reference), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("Accept", manifestAccept)
+	resp, err := c.do(req, ref, scope)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", "", errors.WithMessagef(image.ErrImageNotFound, "manifest %s:%s", ref.Registry+"/"+ref.Repository, reference)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", responseError(resp, "fetch manifest "+reference)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", "", errors.WithMessagef(err, "read manifest %s failed", reference)
+	}
+	if len(data) > maxManifestSize {
+		return nil, "", "", errors.Errorf("manifest %s is too large", reference)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	expected := resp.Header.Get("Docker-Content-Digest")
+	if strings.HasPrefix(reference, "sha256:") {
+		expected = reference
+	}
+	if strings.HasPrefix(expected, "sha256:") && expected != digest {
+		return nil, "", "", errors.Errorf("manifest digest mismatch, expect %s, got %s", expected, digest)
+	}
+
+	var header struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		Manifests     []json.RawMessage `json:"manifests"`
+	}
+	if err = json.Unmarshal(data, &header); err != nil {
+		return nil, "", "", errors.WithMessagef(err, "parse manifest %s failed", reference)
+	}
+	if header.SchemaVersion == 1 {
+		return nil, "", "", errors.Errorf("manifest %s is schema 1, which is not supported", reference)
+	}
+	mediaType := header.MediaType
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	}
+	if mediaType == "" && header.Manifests != nil {
+		mediaType = image.MediaTypeIndex
+	}
+	return data, mediaType, digest, nil
+}
+
+// downloadBlobs 并行下载镜像配置和各个层，镜像仓库中已有的 blob 跳过
+func (c *Client) downloadBlobs(ref *Reference, scope string, blobs []image.Descriptor) error {
+	errs := make([]error, len(blobs))
+	sem := make(chan struct{}, maxParallelTransfer)
+	var wg sync.WaitGroup
+	for i, desc := range blobs {
+		if image.BlobExists(desc.Digest) {
+			c.printf("%s: Already exists\n", shortDigest(desc.Digest))
+			continue
+		}
+		wg.Add(1)
+		go func(i int, desc image.Descriptor) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = c.downloadBlob(ref, scope, desc)
+		}(i, desc)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadBlob 下载一个 blob，失败时等待后重试
+func (c *Client) downloadBlob(ref *Reference, scope string, desc image.Descriptor) error {
+	if err := image.ValidateDigest(desc.Digest); err != nil {
+		return err
+	}
+	c.printf("%s: Downloading\n", shortDigest(desc.Digest))
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := c.fetchBlob(ref, scope, desc)
+		if err == nil {
+			c.printf("%s: Pull complete\n", shortDigest(desc.Digest))
+			return nil
+		}
+		if attempt == maxDownloadAttempts {
+			return errors.WithMessagef(err, "download blob %s failed", desc.Digest)
+		}
+		log.Warnf("download blob %s failed, retry in %v: %v", desc.Digest, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// fetchBlob 将 blob 下载到镜像仓库的下载文件中，下载完成后校验 digest
+/*
+1.已有部分内容时以 Range 请求剩余部分，registry 返回 206 时追加，返回 200 时从头下载
+2.下载中断时保留已下载的内容，下次从中断的位置继续
+*/
+func (c *Client) fetchBlob(ref *Reference, scope string, desc image.Descriptor) error {
+	partial, err := image.OpenPartialBlob(desc.Digest)
+	if err != nil {
+		return err
+	}
+	// 其他进程已经下载完成
+	if partial == nil {
+		return nil
+	}
+	defer partial.Close()
+	if desc.Size > 0 && partial.Size > desc.Size {
+		if err = partial.Truncate(); err != nil {
+			return err
+		}
+	}
+	if desc.Size > 0 && partial.Size == desc.Size {
+		return partial.Commit()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, repoURL(ref, "blobs/"+desc.Digest), nil)
+	if err != nil {
+		return err
+	}
+	offset := partial.Size
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.do(req, ref, scope)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			partial.Truncate()
+			return errors.Errorf("unexpected content range %s", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		if offset > 0 {
+			if err = partial.Truncate(); err != nil {
+				return err
+			}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		partial.Truncate()
+		return errors.Errorf("range %d- not satisfiable", offset)
+	default:
+		return responseError(resp, "fetch blob "+desc.Digest)
+	}
+	if _, err = io.Copy(partial, resp.Body); err != nil {
+		return errors.WithMessagef(err, "read blob %s failed", desc.Digest)
+	}
+	if desc.Size > 0 && partial.Size != desc.Size {
+		if partial.Size > desc.Size {
+			partial.Truncate()
+		}
+		return errors.Errorf("blob size mismatch, expect %d, got %d", desc.Size, partial.Size)
+	}
+	return partial.Commit()
+}
+
+// shortDigest 截取 digest 的前 12 位用于显示进度
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
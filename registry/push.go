@@ -0,0 +1,280 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/aspirshar/myContainer/image"
+	"github.com/aspirshar/myContainer/utils"
+	"github.com/pkg/errors"
+)
+
+// uploadChunkSize 分块上传时每块的大小，不超过这个大小的 blob 一次上传
+var uploadChunkSize int64 = 16 << 20
+
+// Push 将镜像仓库中的镜像推送到镜像名称中的 registry，返回 manifest digest
+/*
+1.registry 中已有的 blob（HEAD 检查）跳过
+2.同一 registry 的其他仓库中有该 blob 时（本地有该 registry 的其他镜像引用它）尝试跨仓库挂载
+3.否则上传 blob，不超过 uploadChunkSize 时一次上传，否则分块上传
+4.所有 blob 上传完成后 PUT manifest，manifest 按原样上传，digest 与本地一致
+*/
+func (c *Client) Push(name string) (string, error) {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	if ref.Tag == "" || ref.Digest != "" {
+		return "", errors.Errorf("push %s: need a tag, e.g. %s/%s:latest", name, ref.Registry, ref.Repository)
+	}
+	img, err := image.Get(name)
+	if err != nil {
+		return "", err
+	}
+	manifestData, err := image.ReadBlob(img.Digest)
+	if err != nil {
+		return "", err
+	}
+	c.printf("The push refers to repository [%s/%s]\n", ref.Registry, ref.Repository)
+
+	blobs := append([]image.Descriptor{img.Manifest.Config}, img.Manifest.Layers...)
+	errs := make([]error, len(blobs))
+	sem := make(chan struct{}, maxParallelTransfer)
+	var wg sync.WaitGroup
+	for i, desc := range blobs {
+		wg.Add(1)
+		go func(i int, desc image.Descriptor) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = c.pushBlob(ref, desc)
+		}(i, desc)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err = c.putManifest(ref, img, manifestData); err != nil {
+		return "", err
+	}
+	c.printf("%s: digest: %s size: %d\n", ref.Tag, img.Digest, len(manifestData))
+	return img.Digest, nil
+}
+
+// pushBlob 上传一个 blob，registry 中已有或跨仓库挂载成功时不上传内容
+func (c *Client) pushBlob(ref *Reference, desc image.Descriptor) error {
+	scope := "repository:" + ref.Repository + ":pull,push"
+	exists, err := c.blobExists(ref, scope, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		c.printf("%s: Layer already exists\n", shortDigest(desc.Digest))
+		return nil
+	}
+
+	// 发起上传，带 mount 参数时 registry 返回 201 表示挂载成功，返回 202 表示需要上传内容
+	uploadURL := repoURL(ref, "blobs/uploads/")
+	from := c.mountSource(ref, desc.Digest)
+	if from != "" {
+		uploadURL += "?" + url.Values{"mount": {desc.Digest}, "from": {from}}.Encode()
+		scope += " repository:" + from + ":pull"
+	}
+	req, err := http.NewRequest(http.MethodPost, uploadURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, ref, scope)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		c.printf("%s: Mounted from %s\n", shortDigest(desc.Digest), from)
+		return nil
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError(resp, "start upload of blob "+desc.Digest)
+	}
+	location, err := resolveLocation(resp)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(utils.GetBlob(desc.Digest))
+	if err != nil {
+		return errors.WithMessagef(err, "open blob %s failed", desc.Digest)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return errors.WithMessagef(err, "stat blob %s failed", desc.Digest)
+	}
+	c.printf("%s: Pushing\n", shortDigest(desc.Digest))
+	size := info.Size()
+	if size <= uploadChunkSize {
+		err = c.finishUpload(ref, scope, location, desc.Digest, file, 0, size)
+	} else {
+		err = c.chunkedUpload(ref, scope, location, desc.Digest, file, size)
+	}
+	if err != nil {
+		return err
+	}
+	c.printf("%s: Pushed\n", shortDigest(desc.Digest))
+	return nil
+}
+
+// blobExists 以 HEAD 请求检查 registry 的仓库中是否已有该 blob
+func (c *Client) blobExists(ref *Reference, scope, digest string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, repoURL(ref, "blobs/"+digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req, ref, scope)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.Errorf("check blob %s failed, status %s", digest, resp.Status)
+	}
+}
+
+// mountSource 在本地镜像中查找同一 registry 其他仓库中引用该 blob 的镜像，返回可以挂载的仓库名称
+func (c *Client) mountSource(ref *Reference, digest string) string {
+	index, err := image.LoadIndex()
+	if err != nil {
+		return ""
+	}
+	for tag, manifestDigest := range index.Tags {
+		other, err := ParseReference(tag)
+		if err != nil || other.Registry != ref.Registry || other.Repository == ref.Repository {
+			continue
+		}
+		img, err := image.GetByDigest(manifestDigest)
+		if err == nil && slices.Contains(img.Blobs(), digest) {
+			return other.Repository
+		}
+	}
+	return ""
+}
+
+// chunkedUpload 分块上传，每块以 PATCH 请求上传，registry 返回下一块的上传地址，最后以 PUT 结束上传
+func (c *Client) chunkedUpload(ref *Reference, scope, location, digest string, file *os.File, size int64) error {
+	var offset int64
+	for offset < size {
+		n := min(uploadChunkSize, size-offset)
+		req, err := newSectionRequest(http.MethodPatch, location, file, offset, n)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+n-1))
+		resp, err := c.do(req, ref, scope)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return responseError(resp, "upload chunk of blob "+digest)
+		}
+		if location, err = resolveLocation(resp); err != nil {
+			return err
+		}
+		offset += n
+	}
+	return c.finishUpload(ref, scope, location, digest, file, 0, 0)
+}
+
+// finishUpload 以 PUT 请求结束上传，同时上传 [offset, offset+n) 的内容，registry 校验整个 blob 的 digest
+func (c *Client) finishUpload(ref *Reference, scope, location, digest string, file *os.File, offset, n int64) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return errors.WithMessagef(err, "invalid upload location %s", location)
+	}
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+	req, err := newSectionRequest(http.MethodPut, u.String(), file, offset, n)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.do(req, ref, scope)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "upload blob "+digest)
+	}
+	return nil
+}
+
+// putManifest 上传 manifest 并指向 tag，校验 registry 返回的 digest
+func (c *Client) putManifest(ref *Reference, img *image.Image, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, repoURL(ref, "manifests/"+ref.Tag), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	mediaType := img.Manifest.MediaType
+	if mediaType == "" {
+		mediaType = image.MediaTypeManifest
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(req, ref, "repository:"+ref.Repository+":pull,push")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "put manifest "+ref.String())
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" && digest != img.Digest {
+		return errors.Errorf("manifest digest mismatch, expect %s, registry returns %s", img.Digest, digest)
+	}
+	return nil
+}
+
+// newSectionRequest 创建以文件的 [offset, offset+n) 为请求体的请求，认证后重试时重新读取
+func newSectionRequest(method, location string, file *os.File, offset, n int64) (*http.Request, error) {
+	req, err := http.NewRequest(method, location, io.NewSectionReader(file, offset, n))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = n
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(file, offset, n)), nil
+	}
+	if n == 0 {
+		req.Body = http.NoBody
+	}
+	return req, nil
+}
+
+// resolveLocation 返回上传地址，registry 返回的 Location 可以是相对地址
+func resolveLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.Errorf("%s %s: registry returns no upload location", resp.Request.Method, resp.Request.URL)
+	}
+	u, err := resp.Request.URL.Parse(location)
+	if err != nil {
+		return "", errors.WithMessagef(err, "invalid upload location %s", location)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/aspirshar/myContainer/image"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultRegistry 镜像名称中省略 registry 时使用 docker hub
+	DefaultRegistry = "docker.io"
+	// defaultRegistryHost docker hub 的 registry API 地址
+	defaultRegistryHost = "registry-1.docker.io"
+	defaultTag          = "latest"
+)
+
+var (
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// Reference registry 中的一个镜像，e.g. docker.io/library/busybox:latest、localhost:5000/app@sha256:...
+type Reference struct {
+	Registry   string // registry 地址，e.g. docker.io、localhost:5000
+	Repository string // 仓库名称，e.g. library/busybox
+	Tag        string // tag，按 digest 引用时为空
+	Digest     string // manifest digest，按 tag 引用时为空
+}
+
+// ParseReference 解析镜像名称，补全省略的 registry、library/ 前缀和 latest tag
+/*
+第一个斜杠之前的部分包含 . 或 : 或者为 localhost 时才是 registry 地址，否则是仓库名称的一部分
+*/
+func ParseReference(ref string) (*Reference, error) {
+	r := &Reference{Registry: DefaultRegistry}
+	name := ref
+	if before, digest, ok := strings.Cut(name, "@"); ok {
+		if err := image.ValidateDigest(digest); err != nil {
+			return nil, err
+		}
+		name, r.Digest = before, digest
+	}
+	// 冒号出现在最后一个斜杠之后时才是 tag，registry:5000/busybox 中的冒号是端口
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.Tag = name[:i], name[i+1:]
+		if !tagRegexp.MatchString(r.Tag) {
+			return nil, errors.Errorf("invalid tag %s in %s", r.Tag, ref)
+		}
+	}
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		r.Registry, name = first, rest
+	}
+	if r.Registry == "index.docker.io" {
+		r.Registry = DefaultRegistry
+	}
+	if r.Registry == DefaultRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if !repositoryRegexp.MatchString(name) {
+		return nil, errors.Errorf("invalid repository name %s in %s", name, ref)
+	}
+	r.Repository = name
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = defaultTag
+	}
+	return r, nil
+}
+
+// Host 返回 registry API 的地址，docker hub 的 API 地址与镜像名称中的不同
+func (r *Reference) Host() string {
+	if r.Registry == DefaultRegistry {
+		return defaultRegistryHost
+	}
+	return r.Registry
+}
+
+// Reference 返回请求 manifest 时使用的 tag 或 digest，同时指定时以 digest 为准
+func (r *Reference) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// String 返回完整的镜像名称
+func (r *Reference) String() string {
+	name := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		name += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		name += "@" + r.Digest
+	}
+	return name
+}
+
+// Name 返回镜像仓库中使用的镜像名称，docker hub 的镜像省略 registry 和 library/ 前缀，e.g. busybox:latest
+func (r *Reference) Name() string {
+	return image.FamiliarName(r.String())
+}
@@ -0,0 +1,500 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aspirshar/myContainer/config"
+	"github.com/aspirshar/myContainer/image"
+	"github.com/aspirshar/myContainer/utils"
+)
+
+const testToken = "test-token"
+
+// testRegistry 实现 OCI Distribution API 的本地 registry，需要 Bearer token 认证
+type testRegistry struct {
+	server    *httptest.Server
+	mu        sync.Mutex
+	blobs     map[string][]byte            // digest 对应的 blob
+	manifests map[string]map[string][]byte // 仓库中 tag 或 digest 对应的 manifest
+	types     map[string]string            // manifest digest 对应的 media type
+	truncate  map[string]bool              // 第一次下载时只返回一半内容的 blob
+	ranges    []string                     // 收到的 Range 请求头
+	repoBlobs map[string]bool              // 仓库中已有的 blob，e.g. app@sha256:...
+	uploads   map[string][]byte            // 上传中的 blob
+	username  string                       // 不为空时申请 token 需要用户名和密码
+	password  string                       // 申请 token 的密码
+	requests  []string                     // 收到的上传请求，e.g. POST mount、PATCH、PUT
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string]map[string][]byte),
+		types:     make(map[string]string),
+		truncate:  make(map[string]bool),
+		repoBlobs: make(map[string]bool),
+		uploads:   make(map[string][]byte),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		if r.username != "" {
+			if username, password, ok := req.BasicAuth(); !ok || username != r.username || password != r.password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": testToken})
+	})
+	mux.HandleFunc("/v2/", r.serveAPI)
+	r.server = httptest.NewServer(mux)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// host 返回 registry 地址，e.g. 127.0.0.1:12345
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+// addBlob 添加一个 blob，返回它的描述
+func (r *testRegistry) addBlob(mediaType string, data []byte) image.Descriptor {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	r.mu.Lock()
+	r.blobs[digest] = data
+	r.mu.Unlock()
+	return image.Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// addManifest 添加 manifest 或 image index，tag 为空时只能按 digest 获取
+func (r *testRegistry) addManifest(t *testing.T, repo, tag, mediaType string, v interface{}) image.Descriptor {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.manifests[repo] == nil {
+		r.manifests[repo] = make(map[string][]byte)
+	}
+	r.manifests[repo][digest] = data
+	if tag != "" {
+		r.manifests[repo][tag] = data
+	}
+	r.types[digest] = mediaType
+	return image.Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// serveAPI 处理 /v2/<repo>/manifests/<reference> 和 /v2/<repo>/blobs/<digest>
+func (r *testRegistry) serveAPI(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Authorization") != "Bearer "+testToken {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p == "" {
+		return
+	}
+	if i := strings.Index(p, "/blobs/uploads/"); i >= 0 {
+		r.serveUpload(w, req, p[:i], p[i+len("/blobs/uploads/"):])
+		return
+	}
+	if i := strings.LastIndex(p, "/manifests/"); i >= 0 && req.Method == http.MethodPut {
+		data, _ := io.ReadAll(req.Body)
+		repo, reference := p[:i], p[i+len("/manifests/"):]
+		manifest := new(image.Manifest)
+		json.Unmarshal(data, manifest)
+		for _, desc := range append(manifest.Layers, manifest.Config) {
+			if !r.repoBlobs[repo+"@"+desc.Digest] {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"errors":[{"code":"MANIFEST_BLOB_UNKNOWN","message":"blob unknown %s"}]}`, desc.Digest)
+				return
+			}
+		}
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		if r.manifests[repo] == nil {
+			r.manifests[repo] = make(map[string][]byte)
+		}
+		r.manifests[repo][digest] = data
+		r.manifests[repo][reference] = data
+		r.types[digest] = req.Header.Get("Content-Type")
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if i := strings.LastIndex(p, "/manifests/"); i >= 0 {
+		data, ok := r.manifests[p[:i]][p[i+len("/manifests/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+			return
+		}
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		if !strings.Contains(req.Header.Get("Accept"), r.types[digest]) {
+			http.Error(w, "unsupported accept "+req.Header.Get("Accept"), http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[digest])
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Write(data)
+		return
+	}
+	if i := strings.LastIndex(p, "/blobs/"); i >= 0 {
+		digest := p[i+len("/blobs/"):]
+		data, ok := r.blobs[digest]
+		// 通过推送添加的 blob 只在推送到的仓库中可见
+		if !ok || (req.Method == http.MethodHead && !r.repoBlobs[p[:i]+"@"+digest]) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+			r.ranges = append(r.ranges, rangeHeader)
+		}
+		// 模拟下载中断：声明完整长度但只返回一半内容
+		if r.truncate[digest] {
+			delete(r.truncate, digest)
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.Write(data[:len(data)/2])
+			return
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// serveUpload 处理 blob 上传：POST 发起上传或跨仓库挂载，PATCH 上传一块，PUT 上传剩余内容并校验 digest
+func (r *testRegistry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	query := req.URL.Query()
+	switch req.Method {
+	case http.MethodPost:
+		if mount := query.Get("mount"); mount != "" {
+			r.requests = append(r.requests, "POST mount from "+query.Get("from"))
+			if r.repoBlobs[query.Get("from")+"@"+mount] {
+				r.repoBlobs[repo+"@"+mount] = true
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		id = fmt.Sprint(len(r.uploads) + 1)
+		r.uploads[id] = nil
+	case http.MethodPatch:
+		data, _ := io.ReadAll(req.Body)
+		r.requests = append(r.requests, "PATCH "+req.Header.Get("Content-Range"))
+		if !strings.HasPrefix(req.Header.Get("Content-Range"), fmt.Sprintf("%d-", len(r.uploads[id]))) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		r.uploads[id] = append(r.uploads[id], data...)
+	case http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		r.requests = append(r.requests, fmt.Sprintf("PUT %d", len(data)))
+		data = append(r.uploads[id], data...)
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+		if digest != query.Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":[{"code":"DIGEST_INVALID","message":"digest invalid"}]}`)
+			return
+		}
+		delete(r.uploads, id)
+		r.blobs[digest] = data
+		r.repoBlobs[repo+"@"+digest] = true
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	// 返回相对地址，带上 registry 的状态参数
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s?_state=%d", repo, id, len(r.uploads[id])))
+	w.Header().Set("Range", fmt.Sprintf("0-%d", len(r.uploads[id])-1))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// setupStore 使用临时目录作为镜像仓库和层目录
+func setupStore(t *testing.T) {
+	root := t.TempDir()
+	config.ImagesPath = root + "/images/"
+	config.LayersPath = root + "/layers/"
+	config.AuthFile = root + "/auth.json"
+	os.MkdirAll(config.ImagesPath, 0755)
+	retryDelay = 0
+}
+
+// gzipLayer 生成只有一个文件的 gzip 压缩层
+func gzipLayer(t *testing.T, name, content string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(content))
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+// addImage 添加一个单层镜像，返回 manifest 的描述
+func (r *testRegistry) addImage(t *testing.T, repo, tag, arch, content string) image.Descriptor {
+	layer := r.addBlob("application/vnd.docker.image.rootfs.diff.tar.gzip", gzipLayer(t, "arch", content))
+	configData, _ := json.Marshal(&image.ConfigFile{Architecture: arch, OS: "linux", RootFS: image.RootFS{Type: "layers"}})
+	configDesc := r.addBlob("application/vnd.docker.container.image.v1+json", configData)
+	return r.addManifest(t, repo, tag, image.DockerMediaTypeManifest, &image.Manifest{
+		SchemaVersion: 2,
+		MediaType:     image.DockerMediaTypeManifest,
+		Config:        configDesc,
+		Layers:        []image.Descriptor{layer},
+	})
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref, registry, repo, tag, digest, name string
+	}{
+		{"busybox", "docker.io", "library/busybox", "latest", "", "busybox:latest"},
+		{"user/app:v1", "docker.io", "user/app", "v1", "", "user/app:v1"},
+		{"index.docker.io/library/alpine:3.19", "docker.io", "library/alpine", "3.19", "", "alpine:3.19"},
+		{"localhost:5000/app", "localhost:5000", "app", "latest", "", "localhost:5000/app:latest"},
+		{"ghcr.io/org/tools/cli@sha256:" + strings.Repeat("a", 64), "ghcr.io", "org/tools/cli", "", "sha256:" + strings.Repeat("a", 64), "ghcr.io/org/tools/cli@sha256:" + strings.Repeat("a", 64)},
+	}
+	for _, tt := range tests {
+		r, err := ParseReference(tt.ref)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tt.ref, err)
+		}
+		if r.Registry != tt.registry || r.Repository != tt.repo || r.Tag != tt.tag || r.Digest != tt.digest || r.Name() != tt.name {
+			t.Fatalf("parse %s: unexpected %+v name %s", tt.ref, r, r.Name())
+		}
+	}
+	for _, ref := range []string{"Busybox", "busybox:", "busybox@sha256:123", "a//b"} {
+		if _, err := ParseReference(ref); err == nil {
+			t.Fatalf("expect error for %s", ref)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:app:pull,push"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.example.com/token" || params["service"] != "registry" || params["scope"] != "repository:app:pull,push" {
+		t.Fatalf("unexpected challenge %s %v", scheme, params)
+	}
+}
+
+// TestPull 拉取多平台镜像，下载中断后从已下载的位置继续
+func TestPull(t *testing.T) {
+	setupStore(t)
+	r := newTestRegistry(t)
+	amd64 := r.addImage(t, "library/app", "", "amd64", "amd64")
+	arm64 := r.addImage(t, "library/app", "", "arm64", "arm64")
+	amd64.Platform = &image.Platform{OS: "linux", Architecture: "amd64"}
+	arm64.Platform = &image.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	r.addManifest(t, "library/app", "v1", image.MediaTypeIndex, &image.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     image.MediaTypeIndex,
+		Manifests:     []image.Descriptor{amd64, arm64},
+	})
+	armManifest := new(image.Manifest)
+	json.Unmarshal(r.manifests["library/app"][arm64.Digest], armManifest)
+	r.truncate[armManifest.Layers[0].Digest] = true
+
+	client := NewClient()
+	client.Platform = image.Platform{OS: "linux", Architecture: "arm64"}
+	var out bytes.Buffer
+	client.Out = &out
+	digest, err := client.Pull(r.host() + "/library/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != arm64.Digest {
+		t.Fatalf("expect arm64 manifest %s, got %s", arm64.Digest, digest)
+	}
+	if len(r.ranges) != 1 || !strings.HasPrefix(r.ranges[0], "bytes=") || r.ranges[0] == "bytes=0-" {
+		t.Fatalf("expect resumed download, got ranges %v", r.ranges)
+	}
+	img, err := image.Get(r.host() + "/library/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Digest != arm64.Digest || img.Config.Architecture != "arm64" {
+		t.Fatalf("unexpected image %s %s", img.Digest, img.Config.Architecture)
+	}
+	if !strings.Contains(out.String(), "Status: Downloaded newer image for "+r.host()+"/library/app:v1") {
+		t.Fatalf("unexpected output %s", out.String())
+	}
+
+	// 再次拉取时 blob 都已存在
+	out.Reset()
+	if _, err = client.Pull(r.host() + "/library/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "Downloading") || !strings.Contains(out.String(), "Image is up to date") {
+		t.Fatalf("unexpected output %s", out.String())
+	}
+
+	// 按 digest 拉取 amd64 镜像，不添加 tag
+	client.Platform = image.Platform{OS: "linux", Architecture: "amd64"}
+	byDigest := r.host() + "/library/app@" + amd64.Digest
+	if _, err = client.Pull(byDigest); err != nil {
+		t.Fatal(err)
+	}
+	if img, err = image.Get(byDigest); err != nil || img.Config.Architecture != "amd64" {
+		t.Fatalf("unexpected image by digest %v", err)
+	}
+
+	if os.Geteuid() == 0 {
+		layers, err := image.PrepareLayers(r.host() + "/library/app:v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := os.ReadFile(path.Join(utils.GetLayer(layers[0]), "arch")); err != nil || string(data) != "arm64" {
+			t.Fatalf("unexpected layer content %q %v", data, err)
+		}
+	}
+}
+
+func TestPullErrors(t *testing.T) {
+	setupStore(t)
+	r := newTestRegistry(t)
+	client := NewClient()
+	if _, err := client.Pull(r.host() + "/missing:latest"); err == nil || !strings.Contains(err.Error(), "image not found") {
+		t.Fatalf("expect image not found, got %v", err)
+	}
+
+	// 只有 amd64 的镜像
+	amd64 := r.addImage(t, "app", "", "amd64", "amd64")
+	amd64.Platform = &image.Platform{OS: "linux", Architecture: "amd64"}
+	r.addManifest(t, "app", "latest", image.MediaTypeIndex, &image.IndexManifest{SchemaVersion: 2, Manifests: []image.Descriptor{amd64}})
+	client.Platform = image.Platform{OS: "linux", Architecture: "arm64"}
+	if _, err := client.Pull(r.host() + "/app"); err == nil || !strings.Contains(err.Error(), "no manifest for platform linux/arm64") {
+		t.Fatalf("expect platform error, got %v", err)
+	}
+
+	// 被篡改的 blob 校验失败，不写入镜像仓库
+	manifest := new(image.Manifest)
+	json.Unmarshal(r.manifests["app"][amd64.Digest], manifest)
+	r.blobs[manifest.Layers[0].Digest] = bytes.Repeat([]byte("x"), int(manifest.Layers[0].Size))
+	client.Platform = image.Platform{OS: "linux", Architecture: "amd64"}
+	if _, err := client.Pull(r.host() + "/app"); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expect digest mismatch, got %v", err)
+	}
+	if image.BlobExists(manifest.Layers[0].Digest) {
+		t.Fatal("corrupted blob is written to image store")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	setupStore(t)
+	r := newTestRegistry(t)
+	r.username, r.password = "user", "pass"
+	if err := NewClient().Login(r.host(), "user", "wrong"); err == nil {
+		t.Fatal("expect error for wrong password")
+	}
+	if err := NewClient().Login(r.host(), "user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveCredentials(r.host(), "user", "pa:ss"); err != nil {
+		t.Fatal(err)
+	}
+	if username, password := LookupCredentials(r.host()); username != "user" || password != "pa:ss" {
+		t.Fatalf("unexpected credentials %s %s", username, password)
+	}
+	if info, err := os.Stat(config.AuthFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected auth file mode %v", err)
+	}
+	if found, err := RemoveCredentials(r.host()); err != nil || !found {
+		t.Fatalf("remove credentials failed %v %v", found, err)
+	}
+	if username, _ := LookupCredentials(r.host()); username != "" {
+		t.Fatal("credentials are not removed")
+	}
+}
+
+// TestPush 推送镜像：基础层从同一 registry 的其他仓库挂载，大的层分块上传，再拉取回来
+func TestPush(t *testing.T) {
+	setupStore(t)
+	r := newTestRegistry(t)
+	r.username, r.password = "user", "pass"
+	defer func(size int64) { uploadChunkSize = size }(uploadChunkSize)
+	uploadChunkSize = 64
+
+	// registry 的 base 仓库中已有基础层
+	base := gzipLayer(t, "base", "base")
+	baseDesc := r.addBlob(image.MediaTypeLayerGzip, base)
+	r.repoBlobs["base@"+baseDesc.Digest] = true
+	top := gzipLayer(t, "top", strings.Repeat("top", 100))
+	var layers []image.Descriptor
+	for _, data := range [][]byte{base, top} {
+		digest, size, err := image.WriteBlob(bytes.NewReader(data), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, image.Descriptor{MediaType: image.MediaTypeLayerGzip, Digest: digest, Size: size})
+	}
+	if _, err := image.WriteImage(image.NewConfigFile(), layers[:1], r.host()+"/base:v1"); err != nil {
+		t.Fatal(err)
+	}
+	img, err := image.WriteImage(image.NewConfigFile(), layers, r.host()+"/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewClient().Push(r.host() + "/app:v1"); err == nil {
+		t.Fatal("expect error without login")
+	}
+	if err = SaveCredentials(r.host(), "user", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient()
+	var out bytes.Buffer
+	client.Out = &out
+	digest, err := client.Push(r.host() + "/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != img.Digest {
+		t.Fatalf("expect digest %s, got %s", img.Digest, digest)
+	}
+	requests := strings.Join(r.requests, ",")
+	if !strings.Contains(requests, "POST mount from base") || !strings.Contains(out.String(), "Mounted from base") {
+		t.Fatalf("expect cross repository mount, got %s", requests)
+	}
+	// 大于 64 字节的层分块上传，最后的 PUT 不带内容
+	if !strings.Contains(requests, "PATCH 0-63") || !strings.Contains(requests, "PATCH 64-") || !strings.Contains(requests, "PUT 0") {
+		t.Fatalf("expect chunked upload, got %s", requests)
+	}
+
+	// 再次推送时所有 blob 都已存在
+	out.Reset()
+	if _, err = client.Push(r.host() + "/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out.String(), "Layer already exists") != 3 {
+		t.Fatalf("unexpected output %s", out.String())
+	}
+	if _, err = client.Push(r.host() + "/app@" + img.Digest); err == nil {
+		t.Fatal("expect error for push by digest")
+	}
+
+	// 拉取到新的镜像仓库，manifest digest 不变
+	authFile := config.AuthFile
+	setupStore(t)
+	config.AuthFile = authFile
+	if digest, err = NewClient().Pull(r.host() + "/app:v1"); err != nil || digest != img.Digest {
+		t.Fatalf("pull pushed image %s: %v", digest, err)
+	}
+}
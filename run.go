@@ -5,16 +5,22 @@ import (
 	"github.com/aspirshar/myContainer/cgroups"
 	"github.com/aspirshar/myContainer/cgroups/resource"
 	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/logger"
 	"github.com/aspirshar/myContainer/network"
 	"github.com/aspirshar/myContainer/utils"
+	"io"
 	"os"
+	"os/exec"
+	"os/signal"
 	"strconv"
+	"syscall"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 /*
-Run函数主要做了以下几件事：
+Run函数主要做了以下几件事（1-7 由 startContainer 完成）：
 1.生成容器ID
 2.调用container.NewParentProcess创建父进程
 3.调用parent.Start启动父进程
@@ -22,70 +28,159 @@ Run函数主要做了以下几件事：
 5.如果指定了网络信息则进行配置
 6.记录容器信息
 7.在子进程创建后才能通过pipe来发送参数
-8.如果是前台运行，则等待容器进程结束
+8.如果是前台运行，则在启动父进程之前注册信号处理，并将收到的信号转发给容器 init 进程，等待容器进程结束并返回其退出码
 9.如果是后台运行，则等待子进程退出，并清理工作
 */
 
-func Run(tty bool, comArray, envSlice []string, res *resource.ResourceConfig, volume, containerName, imageName string,
-	net string, portMapping []string) {
+// runErrorExitCode 容器未能成功启动时的退出码，与 docker run 保持一致
+const runErrorExitCode = 125
+
+func Run(tty bool, initConf *container.InitConfig, imageConfig *container.ImageConfig, envSlice []string, res *resource.ResourceConfig, volume, containerName, imageName string,
+	net string, portMapping []string, health *container.HealthConfig,
+	labels map[string]string, logConfig *logger.Config) int {
+	// 前台运行时在启动容器进程之前注册信号处理，启动期间收到的信号不会直接终止 CLI
+	var sigCh chan os.Signal
+	if tty {
+		sigCh = notifySignals()
+	}
+	c, err := startContainer(tty, initConf, imageConfig, envSlice, res, volume, containerName, imageName, net, portMapping, health, labels, logConfig, nil)
+	if err != nil {
+		log.Errorf("%v", err)
+		if tty {
+			stopSignals(sigCh)
+		}
+		return runErrorExitCode
+	}
+
+	if tty {
+		// 前台运行，转发信号并等待容器进程结束
+		exitCode := waitForwarding(c.Parent, sigCh)
+		c.Cleanup()
+		return exitCode
+	}
+	// 然后创建一个 goroutine 来处理后台运行的清理工作
+	go func() {
+		// 等待子进程退出
+		_, _ = c.Parent.Process.Wait()
+		c.Cleanup()
+	}()
+	return 0
+}
+
+// newParentProcess 创建容器父进程，测试中替换为不需要镜像和 namespace 的进程
+var newParentProcess = container.NewParentProcess
+
+// startedContainer 已经启动的容器，容器进程退出后需要调用 Cleanup 清理
+type startedContainer struct {
+	Id      string
+	Parent  *exec.Cmd
+	Info    *container.Info
+	Cleanup func() // 删除工作目录和容器信息、断开网络并销毁 cgroup
+}
+
+// startContainer 创建工作目录并启动容器进程，完成资源限制、网络和容器信息的配置后将命令发送给 init 进程
+// output 不为 nil 时容器的 stdout、stderr 都写入 output，不读取标准输入，也不启动日志进程，用于 build 的 RUN
+func startContainer(tty bool, initConf *container.InitConfig, imageConfig *container.ImageConfig, envSlice []string, res *resource.ResourceConfig, volume, containerName, imageName string,
+	net string, portMapping []string, health *container.HealthConfig,
+	labels map[string]string, logConfig *logger.Config, output io.Writer) (*startedContainer, error) {
 	containerId := container.GenerateContainerID() // 生成 10 位容器 id
+	// 未指定主机名时使用容器 id 作为主机名
+	if initConf.Hostname == "" {
+		initConf.Hostname = containerId
+	}
+	// 环境变量优先级从低到高依次为：默认环境变量、镜像配置、--env-file 和 -e
+	envSlice = utils.MergeEnv(container.DefaultEnv(initConf.Hostname, tty), envSlice)
 
+	if output != nil {
+		logConfig = &logger.Config{Type: logger.NoneDriver}
+	}
 	// 创建父进程
-	parent, writePipe := container.NewParentProcess(tty, volume, containerId, imageName, envSlice)
+	parent, writePipe := newParentProcess(tty, volume, containerId, imageName, envSlice,
+		container.NewLogInfo(containerId, containerName, imageName, labels, logConfig))
 	if parent == nil {
-		log.Errorf("New parent process error")
-		return
+		container.DeleteContainerInfo(containerId)
+		return nil, errors.New("New parent process error")
+	}
+	if output != nil {
+		parent.Stdout = output
+		parent.Stderr = output
 	}
 	if err := parent.Start(); err != nil {
-		log.Errorf("Run parent.Start err:%v", err)
-		return
+		writePipe.Close()
+		utils.DeleteWorkSpace(utils.GetRoot(containerId), volume)
+		container.DeleteContainerInfo(containerId)
+		return nil, errors.WithMessage(err, "Run parent.Start err")
 	}
 
 	// 创建cgroup manager, 并通过调用set和apply设置资源限制并使限制在容器上生效
 	cgroupManager := cgroups.NewCgroupManager("mycontainer-cgroup")
-	//defer cgroupManager.Destroy() // 由单独的 goroutine 来处理
+	//defer cgroupManager.Destroy() // 由 Cleanup 来处理
 	_ = cgroupManager.Set(res)
 	_ = cgroupManager.Apply(parent.Process.Pid)
 
+	// 容器进程启动后出错时，杀死还在等待命令的 init 进程，并释放已经分配的网络、cgroup 和工作目录
+	var networkInfo *container.Info
+	abort := func(err error, message string) (*startedContainer, error) {
+		writePipe.Close()
+		_ = parent.Process.Kill()
+		_ = parent.Wait()
+		if networkInfo != nil {
+			if disconnectErr := network.Disconnect(net, networkInfo); disconnectErr != nil {
+				log.Errorf("disconnect network %s error %v", net, disconnectErr)
+			}
+		}
+		_ = cgroupManager.Destroy()
+		utils.DeleteWorkSpace(utils.GetRoot(containerId), volume)
+		container.DeleteContainerInfo(containerId)
+		return nil, errors.WithMessage(err, message)
+	}
+
 	var containerIP string
 	// 如果指定了网络信息则进行配置
 	if net != "" {
-		containerInfo := &container.Info{
+		networkInfo = &container.Info{
 			Id:          containerId,
 			Pid:         strconv.Itoa(parent.Process.Pid),
 			Name:        containerName,
 			PortMapping: portMapping,
 		}
-		ip, err := network.Connect(net, containerInfo)
+		ip, err := network.Connect(net, networkInfo)
+		if ip != nil {
+			networkInfo.IP = ip.String()
+		}
 		if err != nil {
-			log.Errorf("Error Connect Network %v", err)
-			return
+			return abort(err, "Error Connect Network")
 		}
 		containerIP = ip.String()
 	}
 
+	// 为镜像声明的数据卷创建匿名数据卷，由 init 进程挂载
+	if imageConfig != nil && len(imageConfig.Volumes) > 0 {
+		mounts, err := container.PrepareImageVolumes(containerId, imageConfig.SortedVolumes())
+		if err != nil {
+			return abort(err, "Prepare image volumes error")
+		}
+		initConf.Mounts = mounts
+	}
+
 	// 记录容器信息
-	containerInfo, err := container.RecordContainerInfo(parent.Process.Pid, comArray, containerName, containerId,
-		volume, net, containerIP, portMapping)
+	containerInfo, err := container.RecordContainerInfo(parent.Process.Pid, initConf, containerName, containerId, imageName,
+		volume, net, containerIP, portMapping, health, labels, imageConfig, logConfig)
 	if err != nil {
-		log.Errorf("Record container info error %v", err)
-		return
+		return abort(err, "Record container info error")
 	}
 
 	// 在子进程创建后才能通过pipe来发送参数
-	sendInitCommand(comArray, writePipe)
+	sendInitCommand(initConf, writePipe)
 
-	if tty {
-		_ = parent.Wait() // 前台运行，等待容器进程结束
-	}
-	// 然后创建一个 goroutine 来处理后台运行的清理工作
-	go func() {
-		if !tty {
-			// 等待子进程退出
-			_, _ = parent.Process.Wait()
+	// 配置了健康检查则启动后台进程周期性探测
+	if containerInfo.Health != nil {
+		if err = startHealthMonitor(containerId); err != nil {
+			log.Errorf("Start health monitor error %v", err)
 		}
+	}
 
-		// 清理工作
+	cleanup := func() {
 		utils.DeleteWorkSpace(utils.GetRoot(containerId), volume)
 		container.DeleteContainerInfo(containerId)
 		if net != "" {
@@ -94,12 +189,91 @@ func Run(tty bool, comArray, envSlice []string, res *resource.ResourceConfig, vo
 
 		// 销毁 cgroup
 		cgroupManager.Destroy()
-	}()
+	}
+	return &startedContainer{Id: containerId, Parent: parent, Info: containerInfo, Cleanup: cleanup}, nil
+}
+
+// applyImageConfig 将镜像配置作为容器的默认配置
+/*
+1.指定了 --entrypoint 时使用指定的 entrypoint，并忽略镜像的 Cmd，否则使用镜像的 Entrypoint
+2.没有指定命令时使用镜像的 Cmd，最终的命令为 entrypoint + cmd
+3.没有指定 -w、-u 时使用镜像的 WorkingDir、User
+*/
+func applyImageConfig(initConf *container.InitConfig, imageConfig *container.ImageConfig, entrypoint []string,
+	entrypointSet bool, cmdArray []string) {
+	if imageConfig == nil {
+		imageConfig = &container.ImageConfig{}
+	}
+	if !entrypointSet {
+		entrypoint = imageConfig.Entrypoint
+	}
+	if len(cmdArray) == 0 && !entrypointSet {
+		cmdArray = imageConfig.Cmd
+	}
+	initConf.Args = append(append([]string{}, entrypoint...), cmdArray...)
+	if initConf.Cwd == "" {
+		initConf.Cwd = imageConfig.WorkingDir
+	}
+	if initConf.User == "" {
+		initConf.User = imageConfig.User
+	}
+}
+
+// notifySignals 接收 CLI 收到的所有信号，在容器进程启动之前收到的信号会缓存在 channel 中，启动后再转发
+func notifySignals() chan os.Signal {
+	sigCh := make(chan os.Signal, 128)
+	signal.Notify(sigCh)
+	return sigCh
+}
+
+// stopSignals 停止接收信号并关闭 sigCh，转发信号的 goroutine 随之退出
+// signal.Stop 返回后不会再向 sigCh 发送信号，此时关闭是安全的
+func stopSignals(sigCh chan os.Signal) {
+	signal.Stop(sigCh)
+	close(sigCh)
+}
+
+// waitForwarding 将 notifySignals 收到的信号转发给容器 init 进程，并等待其退出
+// 返回容器的退出码，如果容器被信号杀死则返回 128+signal
+func waitForwarding(parent *exec.Cmd, sigCh chan os.Signal) int {
+	defer stopSignals(sigCh)
+	go forwardSignals(sigCh, parent.Process.Pid)
+
+	err := parent.Wait()
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return container.ExitCodeFromStatus(status)
+		}
+		return exitErr.ExitCode()
+	}
+	log.Errorf("wait container process error %v", err)
+	return runErrorExitCode
+}
+
+// forwardSignals 将收到的信号转发给指定进程，直到 sigCh 被关闭
+func forwardSignals(sigCh <-chan os.Signal, pid int) {
+	for sig := range sigCh {
+		sysSig, ok := sig.(syscall.Signal)
+		if !ok {
+			continue
+		}
+		// SIGCHLD 是子进程退出的通知，SIGURG 是 Go 运行时内部使用的抢占信号，都不需要转发
+		if sysSig == syscall.SIGCHLD || sysSig == syscall.SIGURG {
+			continue
+		}
+		if err := syscall.Kill(pid, sysSig); err != nil && err != syscall.ESRCH {
+			log.Errorf("forward signal %v to container process %d error %v", sysSig, pid, err)
+		}
+	}
 }
 
 // sendInitCommand 通过writePipe将指令发送给子进程
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command, err := json.Marshal(comArray)
+func sendInitCommand(initConf *container.InitConfig, writePipe *os.File) {
+	command, err := json.Marshal(initConf)
 	if err != nil {
 		log.Errorf("marshal command failed, err: %v", err)
 		return
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/aspirshar/myContainer/cgroups"
+	"github.com/aspirshar/myContainer/cgroups/fs2"
+	"github.com/aspirshar/myContainer/cgroups/resource"
+	"github.com/aspirshar/myContainer/config"
+	"github.com/aspirshar/myContainer/container"
+	"github.com/aspirshar/myContainer/logger"
+	"github.com/aspirshar/myContainer/utils"
+)
+
+func TestStartContainerCleanupOnFailure(t *testing.T) {
+	config.RootPath = t.TempDir() + "/overlay2/"
+	var parent *exec.Cmd
+	newParentProcess = func(tty bool, volume, containerId, imageName string, envSlice []string, logInfo *logger.Info) (*exec.Cmd, *os.File) {
+		// 工作目录下的 volumes 是普通文件，创建匿名数据卷会失败
+		if err := os.MkdirAll(utils.GetMerged(containerId), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(utils.GetRoot(containerId), "volumes"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		readPipe, writePipe, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = exec.Command("sleep", "60")
+		parent.ExtraFiles = []*os.File{readPipe}
+		return parent, writePipe
+	}
+	defer func() { newParentProcess = container.NewParentProcess }()
+
+	imageConfig := &container.ImageConfig{Volumes: map[string]struct{}{"/data": {}}}
+	_, err := startContainer(false, &container.InitConfig{Args: []string{"sh"}}, imageConfig, nil, &resource.ResourceConfig{},
+		"", "", "busybox", "", nil, nil, nil, nil, nil)
+	if err == nil {
+		t.Fatal("expect prepare image volumes error")
+	}
+	if parent.ProcessState == nil {
+		t.Fatal("container process not killed")
+	}
+	if entries, _ := os.ReadDir(config.RootPath); len(entries) != 0 {
+		t.Fatalf("workspace not removed: %v", entries)
+	}
+	if cgroups.IsCgroup2UnifiedMode() {
+		if exists, _ := utils.PathExists(path.Join(fs2.UnifiedMountpoint, "mycontainer-cgroup")); exists {
+			t.Fatal("cgroup not destroyed")
+		}
+	}
+}
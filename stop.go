@@ -46,8 +46,15 @@ func stopContainer(containerIdOrName string) {
 		log.Errorf("Conver pid from string to int error %v", err)
 		return
 	}
-	// 2.发送SIGTERM信号
-	if err = syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
+	// 2.发送停止信号，默认为SIGTERM，镜像配置中指定了 StopSignal 时使用镜像的配置
+	stopSignal := syscall.SIGTERM
+	if containerInfo.StopSignal != "" {
+		if stopSignal, err = container.ParseSignal(containerInfo.StopSignal); err != nil {
+			log.Errorf("Parse stop signal of container %s error %v, fallback to SIGTERM", containerId, err)
+			stopSignal = syscall.SIGTERM
+		}
+	}
+	if err = syscall.Kill(pidInt, stopSignal); err != nil {
 		log.Errorf("Stop container %s error %v", containerId, err)
 		// 如果进程不存在，直接更新状态为STOP
 		if err == syscall.ESRCH {
@@ -83,6 +90,7 @@ func getInfoByContainerId(containerId string) (*container.Info, error) {
 	if err = json.Unmarshal(contentBytes, &containerInfo); err != nil {
 		return nil, err
 	}
+	container.LoadHealthState(&containerInfo)
 	return &containerInfo, nil
 }
 
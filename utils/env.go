@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseEnv 解析 --env-file 和 -e 指定的环境变量，-e 中的同名变量会覆盖文件中的值
+/*
+1.NAME=VALUE 直接设置变量
+2.只写 NAME 时从宿主机透传同名变量，宿主机上不存在该变量时忽略
+*/
+func ParseEnv(envs, envFiles []string) ([]string, error) {
+	var result []string
+	for _, envFile := range envFiles {
+		lines, err := readKVFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			env, ok, err := resolveEnv(line)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "parse env file %s failed", envFile)
+			}
+			if ok {
+				result = append(result, env)
+			}
+		}
+	}
+	for _, e := range envs {
+		env, ok, err := resolveEnv(e)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, env)
+		}
+	}
+	return MergeEnv(result), nil
+}
+
+// MergeEnv 按顺序合并多组环境变量，后出现的同名变量覆盖之前的值，变量的顺序保持第一次出现的位置
+func MergeEnv(envGroups ...[]string) []string {
+	var result []string
+	index := make(map[string]int)
+	for _, envs := range envGroups {
+		for _, env := range envs {
+			key, _, _ := strings.Cut(env, "=")
+			if i, ok := index[key]; ok {
+				result[i] = env
+				continue
+			}
+			index[key] = len(result)
+			result = append(result, env)
+		}
+	}
+	return result
+}
+
+// LookupEnv 在 NAME=VALUE 格式的环境变量列表中查找变量
+func LookupEnv(envs []string, key string) (string, bool) {
+	for i := len(envs) - 1; i >= 0; i-- {
+		if name, value, ok := strings.Cut(envs[i], "="); ok && name == key {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func resolveEnv(env string) (string, bool, error) {
+	key, _, hasValue := strings.Cut(env, "=")
+	if strings.TrimSpace(key) == "" {
+		return "", false, fmt.Errorf("invalid env [%s], name can't be empty", env)
+	}
+	if hasValue {
+		return env, true, nil
+	}
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", false, nil
+	}
+	return key + "=" + value, true, nil
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	merged := MergeEnv([]string{"PATH=/bin", "HOME=/root"}, []string{"LANG=C", "PATH=/usr/bin"})
+	expect := []string{"PATH=/usr/bin", "HOME=/root", "LANG=C"}
+	if !reflect.DeepEqual(merged, expect) {
+		t.Fatalf("expect %v, got %v", expect, merged)
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	t.Setenv("MYCONTAINER_TEST_PASS", "from-host")
+	envFile := path.Join(t.TempDir(), "env")
+	if err := os.WriteFile(envFile, []byte("# comment\nA=file\nB=file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	envs, err := ParseEnv([]string{"B=flag", "MYCONTAINER_TEST_PASS", "MYCONTAINER_TEST_MISSING"}, []string{envFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"A=file", "B=flag", "MYCONTAINER_TEST_PASS=from-host"}
+	if !reflect.DeepEqual(envs, expect) {
+		t.Fatalf("expect %v, got %v", expect, envs)
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseLabels 解析 --label k=v 和 --label-file 指定的标签，--label 中的同名标签会覆盖文件中的值
+/*
+label 文件每行一个 k=v，空行和以 # 开头的行会被忽略
+只写 k 不写值时，标签值为空字符串
+*/
+func ParseLabels(labels, labelFiles []string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, labelFile := range labelFiles {
+		lines, err := readKVFile(labelFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			key, value, err := splitLabel(line)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "parse label file %s failed", labelFile)
+			}
+			result[key] = value
+		}
+	}
+	for _, label := range labels {
+		key, value, err := splitLabel(label)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// MergeLabels 合并两组标签，overrides 中的同名标签覆盖 base 中的值
+func MergeLabels(base, overrides map[string]string) map[string]string {
+	result := make(map[string]string, len(base)+len(overrides))
+	for key, value := range base {
+		result[key] = value
+	}
+	for key, value := range overrides {
+		result[key] = value
+	}
+	return result
+}
+
+// MatchLabelFilters 判断标签是否满足所有过滤条件
+/*
+过滤条件的格式与 docker 一致：
+label=k    存在标签 k
+label=k=v  标签 k 的值为 v
+不是 label= 开头的过滤条件会返回错误
+*/
+func MatchLabelFilters(labels map[string]string, filters []string) (bool, error) {
+	for _, filter := range filters {
+		cond, ok := strings.CutPrefix(filter, "label=")
+		if !ok {
+			return false, fmt.Errorf("invalid filter [%s], only label=k or label=k=v is supported", filter)
+		}
+		key, value, hasValue := strings.Cut(cond, "=")
+		actual, exist := labels[key]
+		if !exist || (hasValue && actual != value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// FormatLabels 将标签格式化为 k1=v1,k2=v2 的形式，便于在列表中展示
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func splitLabel(label string) (string, string, error) {
+	key, value, _ := strings.Cut(label, "=")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", "", fmt.Errorf("invalid label [%s], key can't be empty", label)
+	}
+	return key, value, nil
+}
+
+// readKVFile 读取 k=v 格式的文件，跳过空行和注释行
+func readKVFile(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "open %s failed", filePath)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.WithMessagef(err, "read %s failed", filePath)
+	}
+	return lines, nil
+}
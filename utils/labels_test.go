@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	labelFile := path.Join(t.TempDir(), "labels")
+	content := "# team labels\nteam=infra\n\nservice=db\n"
+	if err := os.WriteFile(labelFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	labels, err := ParseLabels([]string{"service=web", "canary"}, []string{labelFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels["team"] != "infra" || labels["service"] != "web" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	if value, ok := labels["canary"]; !ok || value != "" {
+		t.Fatalf("expect empty canary label, got %v", labels)
+	}
+	if _, err = ParseLabels([]string{"=web"}, nil); err == nil {
+		t.Fatal("expect error for empty key")
+	}
+}
+
+func TestMatchLabelFilters(t *testing.T) {
+	labels := map[string]string{"team": "infra", "service": "web"}
+	cases := []struct {
+		filters []string
+		matched bool
+	}{
+		{nil, true},
+		{[]string{"label=team"}, true},
+		{[]string{"label=team=infra", "label=service=web"}, true},
+		{[]string{"label=team=db"}, false},
+		{[]string{"label=owner"}, false},
+	}
+	for _, c := range cases {
+		matched, err := MatchLabelFilters(labels, c.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched != c.matched {
+			t.Fatalf("filters %v expect %v, got %v", c.filters, c.matched, matched)
+		}
+	}
+	if _, err := MatchLabelFilters(labels, []string{"name=web"}); err == nil {
+		t.Fatal("expect error for unsupported filter")
+	}
+}
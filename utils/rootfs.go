@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"strings"
+
 	"github.com/aspirshar/myContainer/config"
 )
 
@@ -22,6 +24,26 @@ func GetImage(imageName string) string {
 	return fmt.Sprintf("%s%s.tar", config.ImagesPath, imageName) 
 }
 
+// GetBlob 返回镜像仓库中内容为 digest 的 blob 路径，e.g. sha256:abc 对应 images/blobs/sha256/abc
+func GetBlob(digest string) string {
+	return config.ImagesPath + "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// GetImageIndex 返回镜像仓库索引文件路径，索引记录 repo:tag 对应的 manifest digest
+func GetImageIndex() string {
+	return config.ImagesPath + "index.json"
+}
+
+// GetLayerDir 返回镜像层相对于 config.LayersPath 的目录，e.g. sha256:abc 对应 sha256/abc
+func GetLayerDir(digest string) string {
+	return strings.Replace(digest, ":", "/", 1)
+}
+
+// GetLayer 返回镜像层解压后的目录
+func GetLayer(digest string) string {
+	return config.LayersPath + GetLayerDir(digest)
+}
+
 func GetLower(containerID string) string {
 	return fmt.Sprintf(lowerDirFormat, config.RootPath, containerID)
 }
@@ -38,6 +60,7 @@ func GetMerged(containerID string) string {
 	return fmt.Sprintf(mergedDirFormat, config.RootPath, containerID) 
 }
 
-func GetOverlayFSDirs(lower, upper, worker string) string {
-	return fmt.Sprintf(overlayFSFormat, lower, upper, worker)
+// GetOverlayFSDirs 拼接 overlayfs 挂载参数，lowers 按从上层到下层的顺序排列，用冒号连接
+func GetOverlayFSDirs(lowers []string, upper, worker string) string {
+	return fmt.Sprintf(overlayFSFormat, strings.Join(lowers, ":"), upper, worker)
 }
\ No newline at end of file
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// OpenPty 打开一对伪终端，返回主设备和从设备
+func OpenPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "open /dev/ptmx failed")
+	}
+	fd := int(master.Fd())
+	// 解锁从设备并获取从设备编号，相当于 unlockpt 和 ptsname
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, errors.WithMessage(err, "unlock pty failed")
+	}
+	ptyNum, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.WithMessage(err, "get pty number failed")
+	}
+	slavePath := fmt.Sprintf("/dev/pts/%d", ptyNum)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, errors.WithMessagef(err, "open %s failed", slavePath)
+	}
+	return master, slave, nil
+}
+
+// IsTerminal 判断文件是否是终端
+func IsTerminal(file *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(file.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// SetRawTerminal 将终端设置为 raw 模式，输入不再由宿主机终端回显和处理，返回的函数用于恢复原来的设置
+func SetRawTerminal(file *os.File) (func(), error) {
+	fd := int(file.Fd())
+	oldState, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get terminal attributes failed")
+	}
+	// 与 cfmakeraw 相同
+	raw := *oldState
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, errors.WithMessage(err, "set terminal raw mode failed")
+	}
+	return func() { _ = unix.IoctlSetTermios(fd, unix.TCSETS, oldState) }, nil
+}
+
+// DisableEcho 关闭终端回显，用于输入密码，返回的函数用于恢复原来的设置
+func DisableEcho(file *os.File) (func(), error) {
+	fd := int(file.Fd())
+	oldState, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, errors.WithMessage(err, "get terminal attributes failed")
+	}
+	noEcho := *oldState
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, &noEcho); err != nil {
+		return nil, errors.WithMessage(err, "disable terminal echo failed")
+	}
+	return func() { _ = unix.IoctlSetTermios(fd, unix.TCSETS, oldState) }, nil
+}
+
+// ResizePty 将伪终端的窗口大小设置为与宿主机终端一致
+func ResizePty(pty, terminal *os.File) error {
+	size, err := unix.IoctlGetWinsize(int(terminal.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return errors.WithMessage(err, "get terminal size failed")
+	}
+	return errors.WithMessage(unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, size), "set pty size failed")
+}